@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/audio"
+	"livelylivecaptions/internal/eval"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/transcriber"
+	"livelylivecaptions/internal/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// benchResultPrefix marks result lines in a child's output, which also
+// carries whatever Sherpa-ONNX prints.
+const benchResultPrefix = "BENCH_RESULT "
+
+// benchResult is one model configuration's run over one WAV file.
+type benchResult struct {
+	Model          string   `json:"model"`
+	Provider       string   `json:"provider"`
+	Threads        int      `json:"threads"`
+	File           string   `json:"file"`
+	AudioSeconds   float64  `json:"audio_seconds,omitempty"`
+	LoadSeconds    float64  `json:"load_seconds,omitempty"`
+	RTF            float64  `json:"rtf,omitempty"`
+	FirstPartialMs float64  `json:"first_partial_ms,omitempty"`
+	PeakRSSMB      float64  `json:"peak_rss_mb,omitempty"`
+	WER            *float64 `json:"wer,omitempty"` // Only when a reference transcript exists
+	Transcript     string   `json:"transcript,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// runBench implements the bench subcommand and returns the exit code.
+//
+// Each (model, provider, threads) combination runs in a child process, so a
+// crash in one doesn't end the benchmark and each gets its own peak memory.
+func runBench(args []string) int {
+	flags := pflag.NewFlagSet("bench", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s bench [flags]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(os.Stderr, "Runs every model and provider of the fallback hierarchy over the WAV files")
+		fmt.Fprintln(os.Stderr, "in a directory and reports speed, memory and accuracy.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	assets := flags.String("assets", "test_assets", "Directory of WAV files; <name>.txt next to a file is its reference transcript")
+	threads := flags.IntSlice("threads", []int{1, 2, 4}, "Thread counts to try")
+	only := flags.String("only", "", "Only run combinations whose model or provider contains this text, e.g. nemotron or cuda")
+	asJSON := flags.Bool("json", false, "Print results as JSON instead of a table")
+	verbose := flags.BoolP("verbose", "v", false, "Show log and model output")
+	child := flags.Int("child", -1, "Run one combination and print its results (used internally)")
+	flags.MarkHidden("child")
+	if err := flags.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	level := "warn"
+	if *verbose {
+		level = "debug"
+	}
+	logger.InitGlobalLogger(types.LogConfig{Level: level})
+
+	wavs, err := filepath.Glob(filepath.Join(*assets, "*.wav"))
+	if err != nil || len(wavs) == 0 {
+		fmt.Fprintf(os.Stderr, "No WAV files found in %s\n", *assets)
+		return 1
+	}
+	sort.Strings(wavs)
+
+	choices := transcriber.FallbackChoices()
+	if *child >= 0 {
+		if *child >= len(choices) || len(*threads) != 1 {
+			fmt.Fprintln(os.Stderr, "invalid child invocation")
+			return 2
+		}
+		for _, wav := range wavs {
+			line, _ := json.Marshal(benchFile(choices[*child], (*threads)[0], wav))
+			fmt.Printf("%s%s\n", benchResultPrefix, line)
+		}
+		return 0
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot locate own executable: %v\n", err)
+		return 1
+	}
+
+	var results []benchResult
+	for i, choice := range choices {
+		if *only != "" && !strings.Contains(strings.ToLower(choice.String()), strings.ToLower(*only)) {
+			continue
+		}
+		for _, n := range *threads {
+			fmt.Fprintf(os.Stderr, "Benchmarking %s with %d thread(s)...\n", choice, n)
+			results = append(results, runBenchChild(exe, i, choice, n, *assets, *verbose)...)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write results: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	printBenchTable(os.Stdout, results)
+	return 0
+}
+
+// runBenchChild benchmarks one combination in a child process.
+func runBenchChild(exe string, index int, choice transcriber.ModelChoice, threads int, assets string, verbose bool) []benchResult {
+	cmd := exec.Command(exe, "bench", "--child", strconv.Itoa(index),
+		"--threads", strconv.Itoa(threads), "--assets", assets)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = io.Discard
+	if verbose {
+		cmd.Stderr = os.Stderr
+	}
+	runErr := cmd.Run()
+
+	var results []benchResult
+	scanner := bufio.NewScanner(&stdout)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), benchResultPrefix)
+		if !ok {
+			continue
+		}
+		var r benchResult
+		if err := json.Unmarshal([]byte(line), &r); err == nil {
+			results = append(results, r)
+		}
+	}
+
+	// The child may have crashed, e.g. inside CUDA, before finishing every file.
+	if runErr != nil && len(results) == 0 {
+		return []benchResult{{
+			Model:    choice.Name,
+			Provider: string(choice.Provider),
+			Threads:  threads,
+			File:     "*",
+			Error:    runErr.Error(),
+		}}
+	}
+	if cmd.ProcessState != nil {
+		peak := float64(peakRSS(cmd.ProcessState)) / (1 << 20)
+		for i := range results {
+			results[i].PeakRSSMB = peak
+		}
+	}
+	return results
+}
+
+// benchFile transcribes one WAV file as fast as the model allows.
+func benchFile(choice transcriber.ModelChoice, threads int, wav string) benchResult {
+	r := benchResult{
+		Model:    choice.Name,
+		Provider: string(choice.Provider),
+		Threads:  threads,
+		File:     filepath.Base(wav),
+	}
+
+	samples, rate, err := readWAV(wav)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.AudioSeconds = float64(len(samples)) / float64(rate)
+
+	loadStart := time.Now()
+	tr, err := transcriber.NewTranscriberWithChoice(choice, threads)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer tr.Close()
+	r.LoadSeconds = time.Since(loadStart).Seconds()
+
+	transcript, firstPartial, err := transcribeSamples(tr, samples, rate)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.FirstPartialMs = float64(firstPartial.Microseconds()) / 1000
+	r.RTF = tr.Metrics().Snapshot().RTF
+	r.Transcript = transcript
+
+	if ref, err := os.ReadFile(referenceFor(wav)); err == nil {
+		errorRate := eval.WordErrors(eval.Normalize(string(ref)), eval.Normalize(transcript)).Rate()
+		r.WER = &errorRate
+	}
+	return r
+}
+
+// transcribeSamples feeds samples to tr as fast as it will take them and
+// returns the text of its final events, joined, and how long the first
+// non-empty event took to arrive. tr must not have been started.
+func transcribeSamples(tr *transcriber.Transcriber, samples []float32, rate int) (string, time.Duration, error) {
+	start := time.Now()
+	go func() {
+		defer close(tr.InputChan)
+		frameLen := rate / 10
+		for seq := uint64(0); len(samples) > 0; seq++ {
+			n := min(frameLen, len(samples))
+			tr.InputChan <- types.AudioFrame{
+				Seq:         seq,
+				CaptureTime: time.Now(),
+				SampleRate:  rate,
+				Channels:    1,
+				Samples:     samples[:n],
+			}
+			samples = samples[n:]
+		}
+	}()
+	tr.Start(context.Background())
+
+	var firstPartial time.Duration
+	var finals []string
+	for ev := range tr.OutputChan {
+		if firstPartial == 0 && ev.Text != "" {
+			firstPartial = time.Since(start)
+		}
+		if ev.Kind == types.EventFinal {
+			finals = append(finals, ev.Text)
+		}
+	}
+	return strings.Join(finals, " "), firstPartial, tr.Wait()
+}
+
+// referenceFor returns where the reference transcript of a WAV file would be:
+// name.txt for name.wav, or, following the naming of the bundled golden test,
+// name_transcript.txt for name_speech_16k.wav.
+func referenceFor(wav string) string {
+	base := strings.TrimSuffix(wav, filepath.Ext(wav))
+	if _, err := os.Stat(base + ".txt"); err == nil {
+		return base + ".txt"
+	}
+	if prefix, _, ok := strings.Cut(base, "_speech"); ok {
+		return prefix + "_transcript.txt"
+	}
+	return base + ".txt"
+}
+
+// readWAV loads a WAV file as mono samples at the file's own rate.
+func readWAV(path string) ([]float32, int, error) {
+	wav, err := audio.ReadWAVFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return audio.Downmix(wav.Samples, wav.Channels), wav.SampleRate, nil
+}
+
+// printBenchTable writes results as an aligned table, followed by the
+// configuration with the lowest real-time factor.
+func printBenchTable(w io.Writer, results []benchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tPROVIDER\tTHREADS\tFILE\tLOAD\tRTF\tFIRST PARTIAL\tPEAK RSS\tWER")
+
+	var best *benchResult
+	for i, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\tunavailable: %s\n", r.Model, r.Provider, r.Threads, r.File, firstLine(r.Error))
+			continue
+		}
+		werText := "-"
+		if r.WER != nil {
+			werText = fmt.Sprintf("%.1f%%", *r.WER*100)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%.1fs\t%.3f\t%.0fms\t%.0f MB\t%s\n",
+			r.Model, r.Provider, r.Threads, r.File, r.LoadSeconds, r.RTF, r.FirstPartialMs, r.PeakRSSMB, werText)
+		if best == nil || r.RTF < best.RTF {
+			best = &results[i]
+		}
+	}
+	tw.Flush()
+
+	if best != nil {
+		fmt.Fprintf(w, "\nLowest RTF: %s (%s) with %d thread(s), %.3f on %s\n", best.Model, best.Provider, best.Threads, best.RTF, best.File)
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
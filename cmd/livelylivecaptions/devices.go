@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/audio"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/types"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+)
+
+// deviceEntry describes one capture device for the devices subcommand.
+type deviceEntry struct {
+	ID                 string  `json:"id"`
+	Name               string  `json:"name"`
+	HostAPI            string  `json:"host_api"`
+	Index              int     `json:"index"`
+	MaxChannels        int     `json:"max_channels"`
+	DefaultSampleRate  float64 `json:"default_sample_rate"`
+	DefaultLowLatency  float64 `json:"default_low_latency_ms"`
+	DefaultHighLatency float64 `json:"default_high_latency_ms"`
+	IsDefault          bool    `json:"is_default"`
+}
+
+// runDevices implements the devices subcommand and returns the exit code.
+func runDevices(args []string) int {
+	flags := pflag.NewFlagSet("devices", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s devices [flags]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(os.Stderr, "Lists the capture devices and exits. The ID column is what audio.device_id takes.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	monitor := flags.Bool("monitor", false, "List monitor sources of the system's audio output instead (Linux)")
+	asJSON := flags.Bool("json", false, "Print the devices as JSON instead of a table")
+	verbose := flags.BoolP("verbose", "v", false, "Show log output")
+	if err := flags.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	level := "warn"
+	if *verbose {
+		level = "debug"
+	}
+	logger.InitGlobalLogger(types.LogConfig{Level: level})
+
+	var provider types.AudioProvider = audio.PortAudioProvider{}
+	if *monitor {
+		provider = audio.MonitorProvider{}
+	}
+	devices, err := provider.GetDevices()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list audio devices: %v\n", err)
+		return 1
+	}
+
+	entries := deviceEntries(devices)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write devices: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	printDeviceTable(os.Stdout, entries)
+	return 0
+}
+
+// deviceEntries describes devices for listing.
+func deviceEntries(devices []types.AudioDevice) []deviceEntry {
+	entries := make([]deviceEntry, 0, len(devices))
+	for _, device := range devices {
+		info := device.Info()
+		entries = append(entries, deviceEntry{
+			ID:                 fmt.Sprint(device.ID()),
+			Name:               device.Name(),
+			HostAPI:            info.HostAPI,
+			Index:              info.Index,
+			MaxChannels:        info.MaxChannels,
+			DefaultSampleRate:  info.DefaultSampleRate,
+			DefaultLowLatency:  info.DefaultLowLatency.Seconds() * 1000,
+			DefaultHighLatency: info.DefaultHighLatency.Seconds() * 1000,
+			IsDefault:          info.IsDefault,
+		})
+	}
+	return entries
+}
+
+// printDeviceTable writes devices as an aligned table, numbered in the order
+// listed and with the default input marked by an asterisk.
+func printDeviceTable(w io.Writer, entries []deviceEntry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No capture devices found")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tDEFAULT\tID\tNAME\tHOST API\tINDEX\tCHANNELS\tRATE\tLATENCY")
+	for i, e := range entries {
+		mark := ""
+		if e.IsDefault {
+			mark = "*"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%.0f Hz\t%.0f-%.0f ms\n",
+			i, mark, e.ID, e.Name, e.HostAPI, e.Index, e.MaxChannels, e.DefaultSampleRate, e.DefaultLowLatency, e.DefaultHighLatency)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/engine"
+	"livelylivecaptions/internal/eval"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/transcriber"
+	"livelylivecaptions/internal/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// evalItem is one audio file and what should have been heard in it.
+type evalItem struct {
+	Audio     string
+	Reference string
+}
+
+// evalFileResult scores one file.
+type evalFileResult struct {
+	File       string     `json:"file"`
+	Reference  string     `json:"reference"`
+	Hypothesis string     `json:"hypothesis"`
+	WER        float64    `json:"wer"`
+	CER        float64    `json:"cer"`
+	Words      eval.Score `json:"words"`
+	Chars      eval.Score `json:"chars"`
+	Diff       string     `json:"diff"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// evalReport scores a whole dataset. Corpus rates weigh each file by its
+// length rather than averaging the per-file rates.
+type evalReport struct {
+	Model      string           `json:"model"`
+	Provider   string           `json:"provider"`
+	Normalized bool             `json:"normalized"`
+	WER        float64          `json:"wer"`
+	CER        float64          `json:"cer"`
+	Words      eval.Score       `json:"words"`
+	Chars      eval.Score       `json:"chars"`
+	Files      []evalFileResult `json:"files"`
+}
+
+// sharedEngine lets a series of Transcribers decode with one loaded model.
+// Closing it is left to whoever loaded the model.
+type sharedEngine struct {
+	engine.SpeechEngine
+}
+
+func (sharedEngine) Close() {}
+
+// runEval implements the eval subcommand and returns the exit code.
+func runEval(args []string) int {
+	flags := pflag.NewFlagSet("eval", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s eval [flags]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(os.Stderr, "Transcribes a dataset offline and scores it against reference transcripts.")
+		fmt.Fprintln(os.Stderr, "A manifest has one pair per line, either as JSON, {\"audio\": \"a.wav\", \"text\": \"...\"},")
+		fmt.Fprintln(os.Stderr, "or as the audio path and the reference text separated by a tab. Relative paths")
+		fmt.Fprintln(os.Stderr, "are resolved against the manifest's directory.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	dir := flags.String("dir", "test_assets", "Directory of WAV files with <name>.txt reference transcripts")
+	manifest := flags.String("manifest", "", "Manifest of audio and reference pairs; overrides --dir")
+	model := flags.String("model", "", "Use the first model of the fallback hierarchy whose name contains this, e.g. nemotron")
+	provider := flags.String("provider", "", "Only use this execution provider: cpu or cuda")
+	threads := flags.Int("threads", 1, "Threads for the model")
+	noNormalize := flags.Bool("no-normalize", false, "Score the raw text instead of lowercasing it and removing punctuation")
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	verbose := flags.BoolP("verbose", "v", false, "Show log output")
+	if err := flags.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	level := "warn"
+	if *verbose {
+		level = "debug"
+	}
+	logger.InitGlobalLogger(types.LogConfig{Level: level})
+
+	var items []evalItem
+	var err error
+	if *manifest != "" {
+		items, err = readManifest(*manifest)
+	} else {
+		items, err = scanEvalDir(*dir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load dataset: %v\n", err)
+		return 1
+	}
+	if len(items) == 0 {
+		fmt.Fprintln(os.Stderr, "The dataset has no audio with a reference transcript")
+		return 1
+	}
+
+	e, choice, err := loadEvalEngine(*model, *provider, *threads)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer e.Close()
+
+	report := evalReport{Model: choice.Name, Provider: string(choice.Provider), Normalized: !*noNormalize}
+	for _, item := range items {
+		fmt.Fprintf(os.Stderr, "Transcribing %s...\n", item.Audio)
+		result := evalFile(e, item, !*noNormalize)
+		if result.Error == "" {
+			report.Words = report.Words.Add(result.Words)
+			report.Chars = report.Chars.Add(result.Chars)
+		}
+		report.Files = append(report.Files, result)
+	}
+	report.WER = report.Words.Rate()
+	report.CER = report.Chars.Rate()
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+			return 1
+		}
+	} else {
+		printEvalReport(os.Stdout, report)
+	}
+	return 0
+}
+
+// loadEvalEngine loads the first model of the fallback hierarchy that matches
+// the filters and can be loaded.
+func loadEvalEngine(model, provider string, threads int) (engine.SpeechEngine, transcriber.ModelChoice, error) {
+	var errs []error
+	for _, choice := range transcriber.FallbackChoices() {
+		if model != "" && !strings.Contains(strings.ToLower(choice.Name), strings.ToLower(model)) {
+			continue
+		}
+		if provider != "" && string(choice.Provider) != provider {
+			continue
+		}
+		e, err := transcriber.NewEngineForChoice(choice, threads)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Using %s\n", choice)
+			return e, choice, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", choice, err))
+	}
+	if len(errs) == 0 {
+		return nil, transcriber.ModelChoice{}, fmt.Errorf("no model matches --model %q and --provider %q", model, provider)
+	}
+	return nil, transcriber.ModelChoice{}, fmt.Errorf("no model could be loaded:\n%w", errors.Join(errs...))
+}
+
+// evalFile transcribes one item with a fresh stream of e and scores it.
+func evalFile(e engine.SpeechEngine, item evalItem, normalize bool) evalFileResult {
+	result := evalFileResult{File: item.Audio, Reference: item.Reference}
+
+	samples, rate, err := readWAV(item.Audio)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	tr, err := transcriber.NewTranscriberWithEngine(sharedEngine{e})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer tr.Close()
+
+	hypothesis, _, err := transcribeSamples(tr, samples, rate)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Hypothesis = hypothesis
+
+	ref, hyp := item.Reference, hypothesis
+	if normalize {
+		ref, hyp = eval.Normalize(ref), eval.Normalize(hyp)
+	}
+	words := eval.Words(ref, hyp)
+	result.Words = eval.Count(words)
+	result.Chars = eval.CharErrors(ref, hyp)
+	result.WER = result.Words.Rate()
+	result.CER = result.Chars.Rate()
+	result.Diff = eval.Diff(words)
+	return result
+}
+
+// scanEvalDir pairs every WAV file in dir with its reference transcript.
+// Files without one are skipped.
+func scanEvalDir(dir string) ([]evalItem, error) {
+	wavs, err := filepath.Glob(filepath.Join(dir, "*.wav"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(wavs)
+
+	var items []evalItem
+	for _, wav := range wavs {
+		ref, err := os.ReadFile(referenceFor(wav))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: no reference transcript\n", wav)
+			continue
+		}
+		items = append(items, evalItem{Audio: wav, Reference: strings.TrimSpace(string(ref))})
+	}
+	return items, nil
+}
+
+// readManifest reads audio and reference pairs, one per line, as JSON objects
+// or tab-separated path and text. Blank lines and lines starting with # are ignored.
+func readManifest(path string) ([]evalItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	base := filepath.Dir(path)
+	var items []evalItem
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var item evalItem
+		if strings.HasPrefix(line, "{") {
+			var entry struct {
+				Audio string `json:"audio"`
+				Text  string `json:"text"`
+			}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+			item = evalItem{Audio: entry.Audio, Reference: entry.Text}
+		} else {
+			audio, text, ok := strings.Cut(line, "\t")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: expected the audio path and reference text separated by a tab", path, n)
+			}
+			item = evalItem{Audio: audio, Reference: text}
+		}
+		if item.Audio == "" {
+			return nil, fmt.Errorf("%s:%d: missing audio path", path, n)
+		}
+		if !filepath.IsAbs(item.Audio) {
+			item.Audio = filepath.Join(base, item.Audio)
+		}
+		item.Reference = strings.TrimSpace(item.Reference)
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// printEvalReport writes per-file scores with a word diff, then the corpus totals.
+func printEvalReport(w io.Writer, r evalReport) {
+	fmt.Fprintf(w, "Model: %s (%s), normalized: %t\n\n", r.Model, r.Provider, r.Normalized)
+	for _, f := range r.Files {
+		if f.Error != "" {
+			fmt.Fprintf(w, "%s\n  error: %s\n\n", f.File, firstLine(f.Error))
+			continue
+		}
+		fmt.Fprintf(w, "%s\n  WER %.2f%% (%s)  CER %.2f%%\n", f.File, f.WER*100, scoreBreakdown(f.Words), f.CER*100)
+		if f.Words.Errors() > 0 {
+			fmt.Fprintf(w, "  %s\n", f.Diff)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Corpus: WER %.2f%% (%s)  CER %.2f%% (%s)\n",
+		r.WER*100, scoreBreakdown(r.Words), r.CER*100, scoreBreakdown(r.Chars))
+}
+
+func scoreBreakdown(s eval.Score) string {
+	return fmt.Sprintf("S %d, I %d, D %d of %d", s.Substitutions, s.Insertions, s.Deletions, s.RefLen())
+}
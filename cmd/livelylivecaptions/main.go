@@ -1,22 +1,42 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"livelylivecaptions/internal/audio"
 	"livelylivecaptions/internal/banner"
+	"livelylivecaptions/internal/dsp"
+	"livelylivecaptions/internal/engine"
 	"livelylivecaptions/internal/hardware"
 	"livelylivecaptions/internal/logger"
 	"livelylivecaptions/internal/transcriber"
 	"livelylivecaptions/internal/types"
 	"livelylivecaptions/internal/ui"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 func main() {
+	// Subcommands run without the captioning UI
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "eval":
+			os.Exit(runEval(os.Args[2:]))
+		case "devices":
+			os.Exit(runDevices(os.Args[2:]))
+		}
+	}
+
 	// Initialize Viper
 	v := viper.New()
 	v.SetConfigFile("config.yaml") // Look for config.yaml in the current directory
@@ -29,9 +49,35 @@ func main() {
 	v.SetDefault("model.decoder", "")
 	v.SetDefault("model.joiner", "")
 	v.SetDefault("model.tokens", "")
+	v.SetDefault("model.server_url", "http://127.0.0.1:8080")
+	v.SetDefault("model.server_model", "")
+	v.SetDefault("model.language", "")
+	v.SetDefault("model.reprobe", false)
 	v.SetDefault("audio.sample_rate", 16000)
 	v.SetDefault("audio.device_id", "") // Auto-select/prompt
 	v.SetDefault("audio.monitor_mode", false)
+	v.SetDefault("audio.overflow_policy", string(audio.OverflowBlock))
+	v.SetDefault("audio.file_pacing", string(audio.PaceRealtime))
+	v.SetDefault("audio.pcm_format", string(audio.PCMS16LE))
+	v.SetDefault("audio.pcm_rate", 16000)
+	v.SetDefault("audio.pcm_channels", 1)
+	v.SetDefault("audio.reconnect", true)
+	v.SetDefault("audio.fallback_devices", []string{})
+	v.SetDefault("audio.device_priority", []string{})
+	v.SetDefault("audio.non_interactive", false)
+	v.SetDefault("audio.rtp_jitter_ms", int(audio.DefaultRTPJitterDelay/time.Millisecond))
+	v.SetDefault("audio.rtp_l16_payload_type", 96)
+	v.SetDefault("audio.filters", []string{})
+	v.SetDefault("audio.highpass_hz", audio.DefaultHighPassHz)
+	v.SetDefault("audio.gate_threshold_db", audio.DefaultGateThresholdDB)
+	v.SetDefault("audio.agc_target_db", audio.DefaultAGCTargetDB)
+	v.SetDefault("audio.agc_max_gain_db", audio.DefaultAGCMaxGainDB)
+	v.SetDefault("audio.gain_db", 0.0)
+	v.SetDefault("transcriber.stable_updates", transcriber.DefaultStableUpdates)
+	v.SetDefault("transcriber.stable_ms", transcriber.DefaultStableAge.Milliseconds())
+	v.SetDefault("transcriber.max_segment_seconds", transcriber.DefaultMaxSegmentDuration.Seconds())
+	v.SetDefault("transcriber.max_segment_chars", transcriber.DefaultMaxSegmentChars)
+	v.SetDefault("transcriber.warm_up", true)
 	v.SetDefault("log.to_memory", true)
 	v.SetDefault("log.file_path", "")
 	v.SetDefault("log.level", "info")
@@ -52,12 +98,38 @@ func main() {
 	v.AutomaticEnv()         // Automatically bind environment variables
 
 	// Define CLI arguments using pflag (highest priority)
-	pflag.String("model.provider", "", "Force specific model provider (cpu, cuda, mock)")
+	pflag.String("model.provider", "", "Force specific model provider (cpu, cuda, nemotron_only, sherpa_only, telephony, whisper_http)")
 	pflag.String("model.path", "", "Base path for Sherpa-ONNX models")
-	pflag.String("audio.device_id", "", "ID or name of the audio device to use")
-	pflag.Bool("audio.monitor_mode", false, "Enable monitor mode (capture output audio)")
+	pflag.String("model.server_url", "http://127.0.0.1:8080", "Speech-to-text server URL for the whisper_http provider")
+	pflag.String("model.server_model", "", "Model name sent to the speech-to-text server")
+	pflag.String("model.language", "", "Language hint for the speech-to-text server (e.g. en)")
+	pflag.Bool("model.reprobe", false, "Time each execution provider again instead of using the cached result")
+	pflag.String("audio.device_id", "", "Audio device to use: an ID, a name, part of one, a /regexp/ or \"default\"")
+	pflag.StringSlice("audio.device_priority", nil, "Device patterns to try in order when device_id is empty or absent, e.g. \"Blue Yeti,USB Audio,default\"")
+	pflag.Bool("audio.non_interactive", false, "Exit with an error instead of prompting when no configured device is present")
+	pflag.String("audio.file_pacing", string(audio.PaceRealtime), "How fast a file: device plays: realtime or fast")
+	pflag.String("audio.pcm_format", string(audio.PCMS16LE), "Sample encoding of raw PCM read from stdin, a pipe or the network: s16le or f32le")
+	pflag.Int("audio.pcm_rate", 16000, "Sample rate of raw PCM read from stdin, a pipe or the network (Hz)")
+	pflag.Int("audio.pcm_channels", 1, "Channels of raw PCM read from stdin, a pipe or the network")
+	pflag.Bool("audio.reconnect", true, "Reopen the audio device, or a fallback, when it is unplugged or fails")
+	pflag.StringSlice("audio.fallback_devices", nil, "Device patterns, as for device_id, to use while the chosen device is missing, in order")
+	pflag.Int("audio.rtp_jitter_ms", int(audio.DefaultRTPJitterDelay/time.Millisecond), "How long an rtp:// device waits for a late packet (ms)")
+	pflag.Int("audio.rtp_l16_payload_type", 96, "Dynamic RTP payload type carrying L16 at pcm_rate and pcm_channels")
+	pflag.StringSlice("audio.filters", nil, "Filters to condition audio with, in order: dc, highpass, gate, agc, gain")
+	pflag.Float64("audio.highpass_hz", audio.DefaultHighPassHz, "Cutoff of the highpass filter (Hz)")
+	pflag.Float64("audio.gate_threshold_db", audio.DefaultGateThresholdDB, "Level below which the gate filter turns audio down (dBFS)")
+	pflag.Float64("audio.agc_target_db", audio.DefaultAGCTargetDB, "Level the agc filter brings speech to (dBFS)")
+	pflag.Float64("audio.agc_max_gain_db", audio.DefaultAGCMaxGainDB, "Most the agc filter amplifies (dB)")
+	pflag.Float64("audio.gain_db", 0, "Gain of the gain filter (dB)")
+	pflag.Bool("audio.monitor_mode", false, "Caption system audio (PulseAudio/PipeWire monitor sources) instead of a microphone")
+	pflag.String("audio.overflow_policy", string(audio.OverflowBlock), "What to do when the transcriber falls behind: block, drop-oldest or drop-newest")
 	pflag.Bool("debug.enabled", false, "Enable general debug features")
 	pflag.Int("audio.sample_rate", 16000, "Sample rate for audio capture (Hz)")
+	pflag.Int("transcriber.stable_updates", transcriber.DefaultStableUpdates, "Partials a word must survive before it is shown as stable")
+	pflag.Int("transcriber.stable_ms", int(transcriber.DefaultStableAge.Milliseconds()), "Milliseconds a word must stay unchanged before it is shown as stable")
+	pflag.Float64("transcriber.max_segment_seconds", transcriber.DefaultMaxSegmentDuration.Seconds(), "Finalize a caption after this many seconds without a pause (0 disables)")
+	pflag.Int("transcriber.max_segment_chars", transcriber.DefaultMaxSegmentChars, "Finalize a caption once it is this many characters long (0 disables)")
+	pflag.Bool("transcriber.warm_up", true, "Decode a second of audio before captioning starts so the first words aren't late")
 	pflag.String("log.file_path", "", "Path to a file for persistent logging")
 	pflag.String("log.level", "info", "Minimum log level to capture")
 	pflag.Bool("log.to_memory", true, "Log to in-memory ring buffer for UI display")
@@ -86,8 +158,9 @@ func main() {
 	if cfg.Model.Provider != "" {
 		provider = cfg.Model.Provider
 	} else {
-		// Auto-detect if no provider is specified in config/env/flags
-		provider = hardware.DetectBestProvider()
+		// Auto-detect if no provider is specified in config/env/flags by
+		// timing each execution provider; the result is cached between runs
+		provider = transcriber.DetectBestProvider(cfg.Model.Reprobe)
 	}
 
 	logger.Info("Compute provider: %s", provider)
@@ -97,94 +170,90 @@ func main() {
 		logger.Warn("GPU (CUDA) was requested but not detected/available. Falling back to CPU provider.")
 	}
 
-	// Get audio devices using the new Provider interface
-	audioProvider := audio.PortAudioProvider{}
-	devices, err := audioProvider.GetDevices()
-	if err != nil {
-		logger.Error("Failed to get audio devices: %v", err)
-		return
-	}
-
-	logger.Info("\n\nAvailable audio devices:\n\n")
-	
-	// Define colors for device list
-	colorPurple := "\033[38;2;170;100;255m" // A nice purple
-	colorSkyBlue := "\033[38;2;135;206;250m" // Sky blue
-	colorReset := "\033[0m"
-
-	fmt.Fprintf(os.Stderr, "\n") // Add a newline for spacing
-
-	for i, device := range devices {
-		// Print the device info with custom colors
-		fmt.Fprintf(os.Stderr, "%s%d:%s %s%s (ID: %v)%s\n\n",
-			colorPurple,
-			i,
-			colorReset, // Reset after the colon
-			colorSkyBlue,
-			device.Name(),
-			device.ID(),
-			colorReset,
-		)
-	}
-
-	// Use device_id from config or prompt if not set
+	// A file: device ID plays a recording, "-" or pipe: reads raw PCM, rtp://
+	// receives an RTP stream and tcp://, udp:// or ws:// listens for a network
+	// sender instead of capturing
 	var selectedDevice types.AudioDevice
-	if cfg.Audio.DeviceID != "" {
-		found := false
-		for _, device := range devices {
-			// Compare by Name or ID
-			if fmt.Sprintf("%v", device.ID()) == cfg.Audio.DeviceID || device.Name() == cfg.Audio.DeviceID {
-				selectedDevice = device
-				found = true
-				break
-			}
+	deviceChan := make(chan types.DeviceStatusMsg, 4)
+	if path, ok := audio.FileDevicePath(cfg.Audio.DeviceID); ok {
+		pacing, err := audio.ParseFilePacing(cfg.Audio.FilePacing)
+		if err != nil {
+			logger.Error("Invalid audio configuration: %v", err)
+			return
 		}
-		if !found {
-			logger.Warn("Configured audio device '%s' not found. Please select from available devices.", cfg.Audio.DeviceID)
-			// Fall through to interactive selection if not found
-		} else {
-			logger.Info("Using configured audio device: %s (ID: %v)", selectedDevice.Name(), selectedDevice.ID())
+		selectedDevice = audio.NewFileAudioDevice(path, pacing)
+	} else if path, ok := audio.PipeDevicePath(cfg.Audio.DeviceID); ok {
+		// Raw PCM from another program, e.g. ffmpeg ... -f s16le - | livelylivecaptions --audio.device_id=-
+		format, err := audio.ParsePCMFormat(cfg.Audio.PCMFormat, cfg.Audio.PCMRate, cfg.Audio.PCMChannels)
+		if err != nil {
+			logger.Error("Invalid audio configuration: %v", err)
+			return
 		}
-	}
-
-	if selectedDevice == nil { // If no device selected by config or not found
-		fmt.Print("Select a device: ") // Keep fmt.Print for user input prompt
-		var selectedDeviceIndex int
-		_, err = fmt.Scanln(&selectedDeviceIndex)
-		if err != nil || selectedDeviceIndex < 0 || selectedDeviceIndex >= len(devices) {
-			logger.Error("Invalid selection. Exiting.")
+		selectedDevice = audio.NewPipeAudioDevice(path, format)
+	} else if addr, ok := audio.RTPDevicePath(cfg.Audio.DeviceID); ok {
+		// RTP from a PBX or media gateway; G.711 arrives at 8 kHz and is
+		// resampled to the capture rate, unless the telephony model hears it as is
+		rate := cfg.Audio.SampleRate
+		if cfg.Model.Provider == hardware.ProviderTelephony {
+			rate = transcriber.TelephonySampleRate
+		}
+		selectedDevice = audio.NewRTPAudioDevice(addr, audio.RTPConfig{
+			L16PayloadType: cfg.Audio.RTPL16PayloadType,
+			L16Rate:        cfg.Audio.PCMRate,
+			L16Channels:    cfg.Audio.PCMChannels,
+			JitterDelay:    time.Duration(cfg.Audio.RTPJitterMs) * time.Millisecond,
+			SampleRate:     rate,
+		})
+	} else if audio.IsNetworkDeviceID(cfg.Audio.DeviceID) {
+		format, err := audio.ParsePCMFormat(cfg.Audio.PCMFormat, cfg.Audio.PCMRate, cfg.Audio.PCMChannels)
+		if err != nil {
+			logger.Error("Invalid audio configuration: %v", err)
 			return
 		}
-		selectedDevice = devices[selectedDeviceIndex]
+		if selectedDevice, err = audio.NewNetworkAudioDevice(cfg.Audio.DeviceID, format); err != nil {
+			logger.Error("Invalid audio configuration: %v", err)
+			return
+		}
+	} else if selectedDevice = chooseCaptureDevice(cfg); selectedDevice == nil {
+		os.Exit(1)
+	} else if cfg.Audio.Reconnect {
+		// Reopen the device, or a fallback, if it is unplugged or fails
+		selectedDevice = audio.NewSupervisor(selectedDevice, audio.SupervisorConfig{
+			Provider:  captureProvider(cfg),
+			Fallbacks: cfg.Audio.FallbackDevices,
+			OnStatus:  func(msg types.DeviceStatusMsg) { publishDeviceStatus(deviceChan, msg) },
+		})
 	}
-    
-    // Start the selected audio device
-    err = selectedDevice.Start()
-    if err != nil {
-        logger.Error("Failed to start audio device: %v", err)
-        return
-    }
-    defer selectedDevice.Close() // Ensure device is closed on exit
 
 	// Initialize Transcriber based on configuration
 	var tr *transcriber.Transcriber
+	var err error
 
 	// Determine which model loading strategy to use based on config
 	if cfg.Model.Provider == "nemotron_only" {
-		logger.Info("Attempting to initialize with Nemotron-only hierarchical model loading (CUDA -> CPU)...")
-		logger.Info("Primary: Nemotron CUDA, Fallback: Nemotron CPU")
+		logger.Info("Attempting to initialize with Nemotron-only hierarchical model loading (fastest provider first)...")
 		tr, err = transcriber.NewNemotronOnlyTranscriberWithFallback()
 	} else if cfg.Model.Provider == "" {
 		// Auto-detect mode: Nemotron primary with comprehensive fallbacks
-		logger.Info("Attempting to initialize with comprehensive hierarchical model loading (Nemotron CUDA -> Nemotron CPU -> Sherpa GPU -> Sherpa CPU)...")
-		logger.Info("Primary: Nemotron CUDA, Fallback: Nemotron CPU, Secondary Fallback: Sherpa GPU, Final Fallback: Sherpa CPU")
+		logger.Info("Attempting to initialize with comprehensive hierarchical model loading (Nemotron -> Sherpa, fastest provider first)...")
 		tr, err = transcriber.NewTranscriberWithFallback()
+	} else if cfg.Model.Provider == hardware.ProviderWhisperHTTP {
+		// Remote mode: share a speech-to-text server instead of loading a model here
+		logger.Info("Using speech-to-text server at %s", cfg.Model.ServerURL)
+		tr, err = transcriber.NewWhisperHTTPTranscriber(engine.WhisperHTTPConfig{
+			URL:      cfg.Model.ServerURL,
+			Model:    cfg.Model.ServerModel,
+			Language: cfg.Model.Language,
+		})
 	} else if cfg.Model.Provider == "sherpa_only" {
-		// Sherpa-only mode: Sherpa GPU primary with CPU fallback
-		logger.Info("Attempting to initialize with Sherpa-only model loading...")
-		logger.Info("Primary: Sherpa GPU model, Fallback: Sherpa CPU model")
-		
+		// Sherpa-only mode: the Sherpa model on the fastest provider, then the others
+		logger.Info("Attempting to initialize with Sherpa-only model loading (fastest provider first)...")
+
 		tr, err = transcriber.NewSherpaOnlyTranscriberWithFallback()
+	} else if cfg.Model.Provider == hardware.ProviderTelephony {
+		// Telephony mode: an 8 kHz model for phone calls, e.g. from an rtp:// device
+		logger.Info("Attempting to initialize with the telephony model (fastest provider first)...")
+		tr, err = transcriber.NewTelephonyTranscriberWithFallback()
 	} else {
 		// Standard mode based on hardware detection
 		logger.Info("Attempting to initialize transcriber with %s provider...", provider)
@@ -208,42 +277,100 @@ func main() {
 	}
 	defer tr.Close()
 	logger.Info("Transcriber initialized successfully with selected model.")
+	tr.SetStabilization(cfg.Transcriber.StableUpdates, time.Duration(cfg.Transcriber.StableMs)*time.Millisecond)
+	tr.SetSegmentLimits(time.Duration(cfg.Transcriber.MaxSegmentSeconds*float64(time.Second)), cfg.Transcriber.MaxSegmentChars)
+
+	// Take the slow first decode now rather than on the first words spoken
+	if cfg.Transcriber.WarmUp {
+		if took, err := tr.WarmUp(); err != nil {
+			logger.Warn("Model warm-up failed after %s: %v", took.Round(time.Millisecond), err)
+		} else {
+			logger.Info("Model warmed up in %s", took.Round(time.Millisecond))
+		}
+	}
+
+	// Start capturing only once the model is ready, so no audio piles up meanwhile
+	err = selectedDevice.Start()
+	if err != nil {
+		logger.Error("Failed to start audio device: %v", err)
+		return
+	}
+	defer selectedDevice.Close() // Ensure device is closed on exit
+
+	overflowPolicy, err := audio.ParseOverflowPolicy(cfg.Audio.OverflowPolicy)
+	if err != nil {
+		logger.Error("Invalid audio configuration: %v", err)
+		return
+	}
+
+	filters, err := audio.NewFilterChain(cfg.Audio.Filters, audio.FilterConfig{
+		HighPassHz:      cfg.Audio.HighPassHz,
+		GateThresholdDB: cfg.Audio.GateThresholdDB,
+		AGCTargetDB:     cfg.Audio.AGCTargetDB,
+		AGCMaxGainDB:    cfg.Audio.AGCMaxGainDB,
+		GainDB:          cfg.Audio.GainDB,
+	})
+	if err != nil {
+		logger.Error("Invalid audio configuration: %v", err)
+		return
+	}
+	if len(filters) > 0 {
+		logger.Info("Audio filters: %s", filters)
+	}
 
 	// Create channels
 	micAudioChan := tr.InputChan
 	uiUpdateChan := tr.OutputChan
 	levelChan := make(chan types.AudioLevelMsg, 60) // Buffer for 60fps
+	statsChan := make(chan types.AudioStatsMsg, 1)
+	metricsChan := make(chan types.MetricsMsg, 1)
 	quitChan := make(chan struct{})
+	sink := audio.NewFrameSink(micAudioChan, overflowPolicy)
 
 	logger.Info("Channels created")
 
 	// Start audio capture goroutine using the new AudioDevice interface
 	go func() {
 		defer close(micAudioChan) // Close the input channel when audio capture stops
-        defer close(levelChan)    // Close level channel as well
+		defer close(levelChan)    // Close level channel as well
 
 		for {
 			select {
 			case <-quitChan:
 				return
 			default:
-				audioData, err := selectedDevice.Read()
+				frame, err := selectedDevice.Read()
+				if errors.Is(err, io.EOF) {
+					logger.Info("Reached the end of %s", selectedDevice.Name())
+					publishDeviceStatus(deviceChan, types.DeviceStatusMsg{State: types.DeviceStopped, Device: selectedDevice.Name(), Reason: "end of input"})
+					return
+				}
 				if err != nil {
 					logger.Error("Error reading from audio device: %v", err)
+					publishDeviceStatus(deviceChan, types.DeviceStatusMsg{State: types.DeviceStopped, Device: selectedDevice.Name(), Reason: err.Error()})
 					return // Exit goroutine on error
 				}
-				
-				if audioData == nil {
+
+				if len(frame.Samples) == 0 {
 					// No data yet, wait a bit to prevent busy-looping
 					time.Sleep(10 * time.Millisecond)
 					continue
 				}
 
-				// Send audio data to transcriber
-				micAudioChan <- audioData
+				// Condition the audio, so the level meter shows what the
+				// transcriber hears
+				filters.Process(&frame)
+
+				// Measure the level first: the transcriber releases the
+				// frame's samples for reuse once it has decoded them.
+				rms := dsp.RMS(frame.Samples)
 
-				// Calculate and send RMS level
-				rms := audio.CalculateRMS(audioData)
+				// Send audio data to transcriber, as the overflow policy allows
+				if !sink.Deliver(frame, quitChan) {
+					return
+				}
+
+				// Send RMS level
 				select {
 				case levelChan <- types.AudioLevelMsg(rms):
 				default: // Non-blocking send to levelChan
@@ -252,15 +379,162 @@ func main() {
 		}
 	}()
 
-	// Start transcriber
-	tr.Start()
+	// Publish capture statistics and caption latency once a second, and log
+	// when audio goes missing
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		var last types.AudioStatsMsg
+		for {
+			select {
+			case <-quitChan:
+				return
+			case <-ticker.C:
+				stats := sink.Stats()
+				if stats.Lost > last.Lost || stats.Dropped > last.Dropped {
+					logger.Warn("Audio lost in the last second: %d frames by the device, %d dropped (policy %s)",
+						stats.Lost-last.Lost, stats.Dropped-last.Dropped, overflowPolicy)
+				}
+				last = stats
+				select {
+				case statsChan <- stats:
+				default: // The UI still has the previous update
+				}
+				select {
+				case metricsChan <- tr.Metrics().Snapshot():
+				default:
+				}
+			}
+		}
+	}()
+
+	// Start transcriber; SIGINT/SIGTERM outside the UI stop it cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	tr.Start(ctx)
+
+	// Log decoding errors as they happen; Wait reports the one that stopped captioning
+	go func() {
+		for err := range tr.Errors() {
+			logger.Warn("Transcriber error: %v", err)
+		}
+	}()
 
-    // Initialize and run Bubble Tea program
-    if err := ui.RunProgram(uiUpdateChan, levelChan, quitChan); err != nil {
-        logger.Error("Error running UI: %v", err)
-        os.Exit(1)
-    }
+	// Initialize and run Bubble Tea program
+	// Keys must come from the terminal when stdin carries audio
+	var uiOptions []tea.ProgramOption
+	if pipe, ok := selectedDevice.(*audio.PipeAudioDevice); ok && pipe.ReadsStdin() {
+		uiOptions = append(uiOptions, tea.WithInputTTY())
+	}
+	// Tell the UI why captioning stops, should it stop before the user quits
+	stoppedChan := make(chan error, 1)
+	go func() {
+		err := tr.Wait()
+		if errors.Is(err, context.Canceled) {
+			err = nil
+		}
+		stoppedChan <- err
+	}()
+	uiChannels := ui.Channels{
+		Transcriptions: uiUpdateChan,
+		Levels:         levelChan,
+		Stats:          statsChan,
+		Metrics:        metricsChan,
+		Devices:        deviceChan,
+		Stopped:        stoppedChan,
+		Quit:           quitChan,
+	}
+	if err := ui.RunProgram(uiChannels, uiOptions...); err != nil {
+		logger.Error("Error running UI: %v", err)
+		os.Exit(1)
+	}
 
 	// Cleanup after UI exits
 	logger.Info("Shutting down gracefully...")
+	tr.Close()
+	if err := tr.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+		logger.Error("Captioning stopped: %v", err)
+	}
+	logger.Info("Audio frames: %s", sink.Stats())
+	logger.Info("Caption metrics for %s: %s", tr.Engine().Name(), tr.Metrics().Snapshot())
+}
+
+// chooseCaptureDevice lists the capture devices and picks the configured one,
+// or asks which to use. It returns nil if there is none to use.
+func chooseCaptureDevice(cfg types.AppConfig) types.AudioDevice {
+	// Get audio devices using the new Provider interface
+	devices, err := captureProvider(cfg).GetDevices()
+	if err != nil {
+		logger.Error("Failed to get audio devices: %v", err)
+		return nil
+	}
+
+	// The listing goes to stderr, out of the way of the captions, and
+	// numbers the devices for the prompt below.
+	fmt.Fprintf(os.Stderr, "\nAvailable audio devices:\n\n")
+	printDeviceTable(os.Stderr, deviceEntries(devices))
+	fmt.Fprintln(os.Stderr)
+
+	// device_id comes first, then the priority list; see audio.SelectDevice
+	patterns := append([]string{cfg.Audio.DeviceID}, cfg.Audio.DevicePriority...)
+	selectedDevice, pattern, err := audio.SelectDevice(devices, patterns)
+	if err == nil {
+		logger.Info("Using audio device %s (ID: %v), matched by %q", selectedDevice.Name(), selectedDevice.ID(), pattern)
+		return selectedDevice
+	}
+	if !errors.Is(err, audio.ErrNoMatchingDevice) {
+		logger.Error("Invalid audio configuration: %v", err)
+		return nil
+	}
+
+	// Prompting would hang a kiosk or service waiting for a keyboard nobody
+	// will use.
+	if cfg.Audio.NonInteractive || !stdinIsTerminal() {
+		logger.Error("No audio device selected: %v. Set audio.device_id or audio.device_priority; see the devices subcommand.", err)
+		return nil
+	}
+	if cfg.Audio.DeviceID != "" || len(cfg.Audio.DevicePriority) > 0 {
+		logger.Warn("%v. Please select from available devices.", err)
+	}
+
+	fmt.Print("Select a device: ") // Keep fmt.Print for user input prompt
+	var selectedDeviceIndex int
+	_, err = fmt.Scanln(&selectedDeviceIndex)
+	if err != nil || selectedDeviceIndex < 0 || selectedDeviceIndex >= len(devices) {
+		logger.Error("Invalid selection. Exiting.")
+		return nil
+	}
+	return devices[selectedDeviceIndex]
+}
+
+// stdinIsTerminal reports whether someone could answer a prompt.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// captureProvider returns the provider listing the capture devices cfg
+// chooses from.
+func captureProvider(cfg types.AppConfig) types.AudioProvider {
+	if cfg.Audio.MonitorMode {
+		// Caption what this machine is playing instead of a microphone
+		return audio.MonitorProvider{}
+	}
+	return audio.PortAudioProvider{}
+}
+
+// publishDeviceStatus hands msg to the UI. If the UI is behind, the oldest
+// status waiting is dropped, so the newest always gets through.
+func publishDeviceStatus(ch chan types.DeviceStatusMsg, msg types.DeviceStatusMsg) {
+	for {
+		select {
+		case ch <- msg:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
 }
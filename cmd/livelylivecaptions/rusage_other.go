@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "os"
+
+// peakRSS is not available on this platform and reports 0.
+func peakRSS(ps *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// peakRSS returns the peak resident memory of an exited process in bytes.
+func peakRSS(ps *os.ProcessState) int64 {
+	usage, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		return usage.Maxrss // Already in bytes
+	}
+	return usage.Maxrss * 1024 // Kilobytes on Linux
+}
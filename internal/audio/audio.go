@@ -6,11 +6,28 @@ import (
 	"livelylivecaptions/internal/types"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gordonklaus/portaudio"
 )
 
+const (
+	// captureSampleRate and captureChannels are the format requested from
+	// PortAudio: 16kHz mono, what the speech models expect.
+	captureSampleRate = 16000
+	captureChannels   = 1
+
+	// captureFrameSamples is the length of the frames Read returns: 100ms.
+	captureFrameSamples = captureSampleRate / 10
+	// ringSeconds of audio can wait in the ring before the callback drops audio.
+	ringSeconds = 2
+	// framePoolSize is how many released frames are kept for reuse.
+	framePoolSize = 32
+	// readTimeout is how long Read waits for audio before returning an empty frame.
+	readTimeout = 100 * time.Millisecond
+)
+
 // PortAudioProvider implements the AudioProvider interface using portaudio.
 type PortAudioProvider struct{}
 
@@ -18,92 +35,122 @@ func (p PortAudioProvider) GetDevices() ([]types.AudioDevice, error) {
 	if err := portaudio.Initialize(); err != nil {
 		return nil, fmt.Errorf("failed to initialize portaudio: %w", err)
 	}
-	// Note: This means Init/Terminate are called frequently. A global management
-	// strategy would be better for a more complex app.
+	// PortAudio only enumerates devices when it is first initialized, so
+	// balance this call: once no device is open, the next listing sees
+	// devices plugged in since.
+	defer portaudio.Terminate()
 
 	devices, err := portaudio.Devices()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get portaudio devices: %w", err)
 	}
+	defaultInput, _ := portaudio.DefaultInputDevice() // nil if there is none
 
 	audioDevices := make([]types.AudioDevice, 0)
+	seen := make(map[string]int)
 	for _, deviceInfo := range devices {
 		if deviceInfo.MaxInputChannels > 0 {
 			// Important: create a new variable for the pointer.
 			dev := deviceInfo
-			audioDevices = append(audioDevices, &PortAudioDevice{Info: dev})
+			audioDevices = append(audioDevices, &PortAudioDevice{
+				PaInfo:    dev,
+				id:        stableDeviceID(hostAPIName(dev), dev.Name, seen),
+				isDefault: dev == defaultInput,
+			})
 		}
 	}
 
 	return audioDevices, nil
 }
 
+// stableDeviceID identifies a device by host API and name, which unlike its
+// index stay the same across reboots and hotplugging. Devices that share both
+// are told apart by the order they are listed in: the second is "#2", and so
+// on. seen counts the IDs handed out so far in this listing.
+func stableDeviceID(hostAPI, name string, seen map[string]int) string {
+	id := hostAPI + ":" + name
+	seen[id]++
+	if n := seen[id]; n > 1 {
+		id += fmt.Sprintf("#%d", n)
+	}
+	return id
+}
+
+func hostAPIName(info *portaudio.DeviceInfo) string {
+	if info.HostApi == nil {
+		return "unknown"
+	}
+	return info.HostApi.Name
+}
+
 // PortAudioDevice implements the AudioDevice interface using portaudio.
 type PortAudioDevice struct {
-	Info        *portaudio.DeviceInfo
-	stream      *portaudio.Stream
-	audioBuffer chan []byte
-	quitRead    chan struct{}
-	isCapturing bool
-	mutex       sync.Mutex
+	PaInfo    *portaudio.DeviceInfo
+	id        string // See stableDeviceID
+	isDefault bool
+	stream    *portaudio.Stream
+
+	// The callback pushes into frames, which Read cuts into pooled frames.
+	// Nothing on this path allocates once warmed up.
+	frames *ringFrames
+
+	isCapturing atomic.Bool // Checked by the callback, so it never takes mutex
+	mutex       sync.Mutex  // Serializes Start and Close
 }
 
 func (d *PortAudioDevice) Name() string {
-	return d.Info.Name
+	return d.PaInfo.Name
 }
 
+// ID returns the device's stable ID from PortAudioProvider, or its name for a
+// device that wasn't listed by one.
 func (d *PortAudioDevice) ID() interface{} {
-	// Using the device name as a unique ID. PortAudio doesn't provide a stable ID string.
-	return d.Info.Name
+	if d.id == "" {
+		return d.PaInfo.Name
+	}
+	return d.id
+}
+
+func (d *PortAudioDevice) Info() types.DeviceInfo {
+	return types.DeviceInfo{
+		HostAPI:            hostAPIName(d.PaInfo),
+		Index:              d.PaInfo.Index,
+		MaxChannels:        d.PaInfo.MaxInputChannels,
+		DefaultSampleRate:  d.PaInfo.DefaultSampleRate,
+		DefaultLowLatency:  d.PaInfo.DefaultLowInputLatency,
+		DefaultHighLatency: d.PaInfo.DefaultHighInputLatency,
+		IsDefault:          d.isDefault,
+	}
 }
 
 func (d *PortAudioDevice) Start() error {
 	d.mutex.Lock()
-	if d.isCapturing {
-		d.mutex.Unlock()
+	defer d.mutex.Unlock()
+	if d.isCapturing.Load() {
 		return fmt.Errorf("portaudio device already started")
 	}
-	d.audioBuffer = make(chan []byte, 100)
-	d.quitRead = make(chan struct{})
-	d.mutex.Unlock()
+	d.frames = newRingFrames(d.Name(), captureSampleRate, captureChannels)
 
 	if err := portaudio.Initialize(); err != nil {
 		return fmt.Errorf("failed to initialize portaudio for capture: %w", err)
 	}
 
-	processAudio := func(in []int16) {
+	processAudio := func(in []float32) {
 		// This callback is executed by PortAudio's processing thread.
-		d.mutex.Lock()
-		if !d.isCapturing {
-			d.mutex.Unlock()
+		if !d.isCapturing.Load() {
 			return
 		}
-		d.mutex.Unlock()
-
-		// Convert int16 samples to a byte slice (Little Endian).
-		byteBuf := make([]byte, len(in)*2)
-		for i, sample := range in {
-			byteBuf[i*2] = byte(sample)
-			byteBuf[i*2+1] = byte(sample >> 8)
-		}
-
-		select {
-		case d.audioBuffer <- byteBuf:
-		default:
-			// Non-blocking, so we don't hold up PortAudio's thread.
-			// logger.Warn is commented out to avoid log spam on busy systems.
-			// logger.Warn("PortAudio buffer full, dropping frame.")
-		}
+		d.frames.push(in)
 	}
 
 	streamParams := portaudio.StreamParameters{
 		Input: portaudio.StreamDeviceParameters{
-			Device:   d.Info,
-			Channels: 1, // Mono
+			Device:   d.PaInfo,
+			Channels: captureChannels,
 			Latency:  time.Millisecond * 100,
 		},
 		Output:     portaudio.StreamDeviceParameters{}, // No output
-		SampleRate: 16000.0,
+		SampleRate: captureSampleRate,
 	}
 
 	var err error
@@ -119,46 +166,35 @@ func (d *PortAudioDevice) Start() error {
 		return fmt.Errorf("failed to start portaudio stream: %w", err)
 	}
 
-	d.mutex.Lock()
-	d.isCapturing = true
-	d.mutex.Unlock()
+	d.isCapturing.Store(true)
 
 	logger.Info("Audio capture started on device: %s", d.Name())
 	return nil
 }
 
-func (d *PortAudioDevice) Read() ([]byte, error) {
-	select {
-	case <-d.quitRead:
-		return nil, fmt.Errorf("portaudio device closed")
-	case frame := <-d.audioBuffer:
-		return frame, nil
-	case <-time.After(100 * time.Millisecond):
-		d.mutex.Lock()
-		capturing := d.isCapturing
-		d.mutex.Unlock()
-		if !capturing {
-			return nil, fmt.Errorf("portaudio device not capturing")
-		}
-		return nil, nil // Indicate no data yet, but still capturing
+// Read returns the next 100ms of audio. Its samples come from a pool, so the
+// last holder of the frame should call Release on it.
+func (d *PortAudioDevice) Read() (types.AudioFrame, error) {
+	if d.frames == nil {
+		return types.AudioFrame{}, fmt.Errorf("portaudio device not capturing")
 	}
+	return d.frames.read()
 }
 
 func (d *PortAudioDevice) Close() error {
 	d.mutex.Lock()
-	if !d.isCapturing {
-		d.mutex.Unlock()
+	defer d.mutex.Unlock()
+	if !d.isCapturing.Load() {
 		return nil
 	}
-	d.isCapturing = false
-	close(d.quitRead)
-	d.mutex.Unlock()
+	d.isCapturing.Store(false)
+	d.frames.stop(fmt.Errorf("portaudio device closed"))
 
 	var firstErr error
 	if d.stream != nil {
 		if err := d.stream.Stop(); err != nil {
 			firstErr = fmt.Errorf("failed to stop portaudio stream: %w", err)
-			logger.Warn(firstErr.Error())
+			logger.Warn("%v", firstErr)
 		}
 		if err := d.stream.Close(); err != nil {
 			if firstErr == nil {
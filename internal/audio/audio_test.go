@@ -1,9 +1,23 @@
 package audio
 
 import (
+	"bufio"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/dsp"
+	"livelylivecaptions/internal/types"
 	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCalculateRMS(t *testing.T) {
@@ -55,3 +69,1312 @@ func TestCalculateRMS(t *testing.T) {
 		})
 	}
 }
+
+func TestSampleRing(t *testing.T) {
+	r := newSampleRing(6) // Rounded up to 8
+	if len(r.buf) != 8 {
+		t.Fatalf("ring size = %d, want 8", len(r.buf))
+	}
+
+	dst := make([]float32, 4)
+	if r.readFull(dst) {
+		t.Fatal("readFull succeeded on an empty ring")
+	}
+
+	// Write and read across the end of the buffer several times.
+	next := float32(0)
+	for round := 0; round < 5; round++ {
+		block := []float32{next, next + 1, next + 2, next + 3}
+		if !r.write(block[:3]) || !r.write(block[3:]) {
+			t.Fatalf("round %d: write failed with room to spare", round)
+		}
+		if !r.readFull(dst) {
+			t.Fatalf("round %d: readFull failed with %d buffered", round, r.buffered())
+		}
+		for i, v := range dst {
+			if v != next+float32(i) {
+				t.Fatalf("round %d: read %v, want samples from %v", round, dst, next)
+			}
+		}
+		next += 4
+	}
+
+	// A block that doesn't fit is dropped whole and counted.
+	r.write(make([]float32, 6))
+	if r.write(make([]float32, 3)) {
+		t.Error("write succeeded on a ring without room")
+	}
+	if got := r.dropped.Load(); got != 3 {
+		t.Errorf("dropped = %d, want 3", got)
+	}
+	if got := r.buffered(); got != 6 {
+		t.Errorf("buffered = %d, want 6", got)
+	}
+}
+
+func TestSamplePoolReuses(t *testing.T) {
+	pool := types.NewSamplePool(4, 1)
+	s := pool.Get()
+	pool.Put(s)
+	if again := pool.Get(); &again[0] != &s[0] {
+		t.Error("Get did not reuse the released slice")
+	}
+	pool.Put(make([]float32, 2)) // Too small to be kept
+	if got := pool.Get(); len(got) != 4 {
+		t.Errorf("Get returned %d samples, want 4", len(got))
+	}
+}
+
+// benchBlock is one PortAudio callback's worth of audio: 10ms at 16kHz.
+const benchBlock = 160
+
+// BenchmarkCaptureInt16 measures the previous capture path: each callback
+// converts its int16 samples to a new byte slice, the level meter parses the
+// bytes and the recognizer converts them again to float32.
+func BenchmarkCaptureInt16(b *testing.B) {
+	in := make([]int16, benchBlock)
+	queue := make(chan []byte, 1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		byteBuf := make([]byte, len(in)*2)
+		for j, sample := range in {
+			byteBuf[j*2] = byte(sample)
+			byteBuf[j*2+1] = byte(sample >> 8)
+		}
+		queue <- byteBuf
+		data := <-queue
+
+		_ = CalculateRMS(data)
+		samples := make([]float32, len(data)/2)
+		int16ToFloat32(samples, data)
+	}
+}
+
+// BenchmarkCaptureFloat32 measures the current path: callbacks copy float32
+// samples into the ring and Read cuts pooled frames that the level meter and
+// the recognizer share.
+func BenchmarkCaptureFloat32(b *testing.B) {
+	in := make([]float32, benchBlock)
+	ring := newSampleRing(ringSeconds * captureSampleRate)
+	pool := types.NewSamplePool(benchBlock, framePoolSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ring.write(in)
+
+		frame := types.AudioFrame{Channels: 1, Samples: pool.Get(), Pool: pool}
+		ring.readFull(frame.Samples)
+		_ = dsp.RMS(frame.Samples)
+		frame.Release()
+	}
+}
+
+func TestStableDeviceID(t *testing.T) {
+	seen := make(map[string]int)
+	var got []string
+	for _, d := range []struct{ hostAPI, name string }{
+		{"ALSA", "USB Audio"},
+		{"JACK Audio Connection Kit", "USB Audio"},
+		{"ALSA", "USB Audio"},
+		{"ALSA", "USB Audio"},
+	} {
+		got = append(got, stableDeviceID(d.hostAPI, d.name, seen))
+	}
+	want := []string{"ALSA:USB Audio", "JACK Audio Connection Kit:USB Audio", "ALSA:USB Audio#2", "ALSA:USB Audio#3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("IDs = %q, want %q", got, want)
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for name, want := range map[string]OverflowPolicy{
+		"":            OverflowBlock,
+		"block":       OverflowBlock,
+		"drop-oldest": OverflowDropOldest,
+		"drop-newest": OverflowDropNewest,
+	} {
+		if got, err := ParseOverflowPolicy(name); err != nil || got != want {
+			t.Errorf("ParseOverflowPolicy(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseOverflowPolicy("drop-all"); err == nil {
+		t.Error("ParseOverflowPolicy accepted an unknown policy")
+	}
+}
+
+// deliverAll sends frames with the given sequence numbers into a channel
+// that holds two, without anyone reading, and returns what was queued.
+func deliverAll(t *testing.T, policy OverflowPolicy, seqs ...uint64) ([]uint64, types.AudioStatsMsg) {
+	t.Helper()
+	out := make(chan types.AudioFrame, 2)
+	sink := NewFrameSink(out, policy)
+	for _, seq := range seqs {
+		sink.Deliver(types.AudioFrame{Seq: seq, Channels: 1, Samples: make([]float32, 1)}, nil)
+	}
+	close(out)
+	var queued []uint64
+	for frame := range out {
+		queued = append(queued, frame.Seq)
+	}
+	return queued, sink.Stats()
+}
+
+func TestFrameSinkDropPolicies(t *testing.T) {
+	queued, stats := deliverAll(t, OverflowDropOldest, 0, 1, 2, 5)
+	if !reflect.DeepEqual(queued, []uint64{2, 5}) {
+		t.Errorf("drop-oldest kept %v, want [2 5]", queued)
+	}
+	if want := (types.AudioStatsMsg{Captured: 4, Lost: 2, Dropped: 2}); stats != want {
+		t.Errorf("drop-oldest stats = %+v, want %+v", stats, want)
+	}
+
+	queued, stats = deliverAll(t, OverflowDropNewest, 0, 1, 2, 3)
+	if !reflect.DeepEqual(queued, []uint64{0, 1}) {
+		t.Errorf("drop-newest kept %v, want [0 1]", queued)
+	}
+	if stats.Dropped != 2 {
+		t.Errorf("drop-newest dropped %d frames, want 2", stats.Dropped)
+	}
+}
+
+func TestFrameSinkBlock(t *testing.T) {
+	out := make(chan types.AudioFrame)
+	sink := NewFrameSink(out, OverflowBlock)
+	quit := make(chan struct{})
+
+	// A blocked delivery gives up when quit is closed.
+	close(quit)
+	if sink.Deliver(types.AudioFrame{}, quit) {
+		t.Error("Deliver reported success with nobody reading and quit closed")
+	}
+
+	// An old frame is delivered but counted as late.
+	go func() { <-out }()
+	old := types.AudioFrame{Seq: 1, CaptureTime: time.Now().Add(-2 * LateThreshold)}
+	if !sink.Deliver(old, make(chan struct{})) {
+		t.Fatal("Deliver failed with a reader waiting")
+	}
+	if stats := sink.Stats(); stats.Late != 1 || stats.Dropped != 0 {
+		t.Errorf("stats = %+v, want one late frame and none dropped", stats)
+	}
+}
+
+func TestParsePulseSources(t *testing.T) {
+	output := `Source #56
+	State: SUSPENDED
+	Name: alsa_output.pci-0000_00_1f.3.analog-stereo.monitor
+	Description: Monitor of Built-in Audio Analog Stereo
+	Driver: PipeWire
+
+Source #57
+	State: RUNNING
+	Name: alsa_input.pci-0000_00_1f.3.analog-stereo
+	Description: Built-in Audio Analog Stereo
+`
+	sources := parsePulseSources(output)
+	if len(sources) != 2 {
+		t.Fatalf("parsePulseSources found %d sources, want 2", len(sources))
+	}
+	if sources[0].Description != "Monitor of Built-in Audio Analog Stereo" {
+		t.Errorf("description = %q", sources[0].Description)
+	}
+	if sink, ok := sources[0].monitorSink(); !ok || sink != "alsa_output.pci-0000_00_1f.3.analog-stereo" {
+		t.Errorf("monitorSink() = %q, %v", sink, ok)
+	}
+	if _, ok := sources[1].monitorSink(); ok {
+		t.Error("an input source was taken for a monitor")
+	}
+
+	args := recorderArgs("pw-record", sources[0])
+	if args[0] != "pw-record" || args[2] != "alsa_output.pci-0000_00_1f.3.analog-stereo" {
+		t.Errorf("pw-record should target the sink, got %v", args)
+	}
+	if args := recorderArgs("parec", sources[0]); args[1] != "--device="+sources[0].Name {
+		t.Errorf("parec should record the monitor source, got %v", args)
+	}
+}
+
+func TestCommandDevice(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to run a recorder with")
+	}
+	// Two frames of silence, then the recorder exits with an error
+	script := fmt.Sprintf("head -c %d /dev/zero; echo 'device busy' >&2; exit 3", 2*captureFrameSamples*4)
+	d := NewCommandDevice("test", "test", "sh", "-c", script)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var frames int
+	for {
+		frame, err := d.Read()
+		if err != nil {
+			if !strings.Contains(err.Error(), "device busy") {
+				t.Errorf("Read() error %q doesn't include the recorder's message", err)
+			}
+			break
+		}
+		if len(frame.Samples) == 0 {
+			continue
+		}
+		if frame.Seq != uint64(frames) || len(frame.Samples) != captureFrameSamples {
+			t.Errorf("frame %d: seq %d with %d samples", frames, frame.Seq, len(frame.Samples))
+		}
+		frame.Release()
+		frames++
+	}
+	if frames != 2 {
+		t.Errorf("read %d frames before the recorder exited, want 2", frames)
+	}
+}
+
+func TestCommandDeviceClose(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("no sleep command to stand in for a recorder")
+	}
+	d := NewCommandDevice("test", "test", "sleep", "60")
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- d.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Close() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() didn't stop the recorder")
+	}
+	if _, err := d.Read(); err == nil {
+		t.Error("Read() after Close() returned no error")
+	}
+}
+
+// makeWAV builds a WAV file from already encoded sample data. A non-zero
+// subFormat writes a WAVE_FORMAT_EXTENSIBLE header with that format.
+func makeWAV(format, subFormat uint16, channels, rate, bits int, data []byte) []byte {
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:], format)
+	binary.LittleEndian.PutUint16(fmtChunk[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:], uint32(rate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:], uint32(rate*channels*bits/8))
+	binary.LittleEndian.PutUint16(fmtChunk[12:], uint16(channels*bits/8))
+	binary.LittleEndian.PutUint16(fmtChunk[14:], uint16(bits))
+	if subFormat != 0 {
+		ext := make([]byte, 24)
+		binary.LittleEndian.PutUint16(ext[0:], 22)
+		binary.LittleEndian.PutUint16(ext[2:], uint16(bits))
+		binary.LittleEndian.PutUint16(ext[8:], subFormat)
+		fmtChunk = append(fmtChunk, ext...)
+	}
+
+	var out []byte
+	chunk := func(id string, body []byte) {
+		out = append(out, id...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(body)))
+		out = append(out, body...)
+		if len(body)%2 == 1 {
+			out = append(out, 0)
+		}
+	}
+	out = append(out, "RIFF\x00\x00\x00\x00WAVE"...)
+	chunk("fmt ", fmtChunk)
+	chunk("LIST", []byte("INFOjunk")) // Chunks the parser must skip
+	chunk("data", data)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+func TestParseWAV(t *testing.T) {
+	float32le := func(vs ...float32) []byte {
+		var b []byte
+		for _, v := range vs {
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+		}
+		return b
+	}
+	float64le := func(vs ...float64) []byte {
+		var b []byte
+		for _, v := range vs {
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		}
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		wav      []byte
+		channels int
+		want     []float32
+	}{
+		{"8-bit", makeWAV(wavFormatPCM, 0, 1, 8000, 8, []byte{128, 255, 0}), 1, []float32{0, 127.0 / 128, -1}},
+		{"16-bit", makeWAV(wavFormatPCM, 0, 1, 16000, 16, []byte{0x00, 0x40, 0x00, 0xC0}), 1, []float32{0.5, -0.5}},
+		{"24-bit", makeWAV(wavFormatPCM, 0, 1, 48000, 24, []byte{0, 0, 0x40, 0, 0, 0xC0}), 1, []float32{0.5, -0.5}},
+		{"32-bit", makeWAV(wavFormatPCM, 0, 1, 48000, 32, []byte{0, 0, 0, 0x40, 0, 0, 0, 0xC0}), 1, []float32{0.5, -0.5}},
+		{"float", makeWAV(wavFormatFloat, 0, 2, 44100, 32, float32le(0.25, -0.75)), 2, []float32{0.25, -0.75}},
+		{"double", makeWAV(wavFormatFloat, 0, 1, 44100, 64, float64le(0.125)), 1, []float32{0.125}},
+		{"extensible", makeWAV(wavFormatExtensible, wavFormatPCM, 2, 48000, 16, []byte{0x00, 0x40, 0x00, 0xC0}), 2, []float32{0.5, -0.5}},
+	}
+	for _, tt := range tests {
+		w, err := ParseWAV(tt.wav)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if w.Channels != tt.channels || !reflect.DeepEqual(w.Samples, tt.want) {
+			t.Errorf("%s: got %d channels %v, want %d channels %v", tt.name, w.Channels, w.Samples, tt.channels, tt.want)
+		}
+	}
+
+	if _, err := ParseWAV(makeWAV(2, 0, 1, 8000, 4, []byte{0})); err == nil {
+		t.Error("ParseWAV accepted ADPCM")
+	}
+	if _, err := ParseWAV([]byte("RIFF\x04\x00\x00\x00WAVE")); err == nil {
+		t.Error("ParseWAV accepted a file without chunks")
+	}
+}
+
+func TestDownmixAndResample(t *testing.T) {
+	if got := Downmix([]float32{1, 0, 0.5, -0.5}, 2); !reflect.DeepEqual(got, []float32{0.5, 0}) {
+		t.Errorf("Downmix = %v", got)
+	}
+
+	// A 1kHz tone at 48kHz should come out at 16kHz with the same level,
+	// and a 12kHz tone, above the new Nyquist frequency, almost removed.
+	tone := func(freq float64) []float32 {
+		s := make([]float32, 48000)
+		for i := range s {
+			s[i] = float32(0.5 * math.Sin(2*math.Pi*freq*float64(i)/48000))
+		}
+		return s
+	}
+	low := Resample(tone(1000), 48000, 16000)
+	if len(low) != 16000 {
+		t.Fatalf("Resample returned %d samples, want 16000", len(low))
+	}
+	if rms := dsp.RMS(low[100 : len(low)-100]); math.Abs(rms-0.5/math.Sqrt2) > 0.01 {
+		t.Errorf("1kHz tone RMS after resampling = %.3f, want %.3f", rms, 0.5/math.Sqrt2)
+	}
+	if rms := dsp.RMS(Resample(tone(12000), 48000, 16000)[100:15900]); rms > 0.02 {
+		t.Errorf("12kHz tone RMS after resampling to 16kHz = %.3f, want it filtered out", rms)
+	}
+	if up := Resample(make([]float32, 8000), 8000, 16000); len(up) != 16000 {
+		t.Errorf("upsampling returned %d samples, want 16000", len(up))
+	}
+}
+
+func TestResamplerBlocks(t *testing.T) {
+	// Fed in uneven blocks, the streaming resampler gives what Resample does
+	// on the whole input.
+	in := make([]float32, 10007)
+	for i := range in {
+		in[i] = float32(math.Sin(float64(i)*0.05) + 0.3*math.Sin(float64(i)*1.3))
+	}
+	for _, rates := range [][2]int{{48000, 16000}, {8000, 16000}, {44100, 16000}, {16000, 16000}} {
+		want := Resample(in, rates[0], rates[1])
+		r := newResampler(rates[0], rates[1])
+		var got []float32
+		for rest := in; len(rest) > 0; {
+			n := min(len(rest), 1+len(got)%977)
+			got = r.process(got, rest[:n], false)
+			rest = rest[n:]
+		}
+		got = r.process(got, nil, true)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d to %d Hz: streamed %d samples differ from Resample's %d", rates[0], rates[1], len(got), len(want))
+		}
+		if len(r.history) > 2*int(r.half)+1 {
+			t.Errorf("%d to %d Hz: resampler kept %d input samples", rates[0], rates[1], len(r.history))
+		}
+	}
+}
+
+func TestFileAudioDevice(t *testing.T) {
+	// 250ms of 48kHz stereo: 2 full frames and a half one at 16kHz
+	data := make([]byte, 12000*2*2)
+	for i := 0; i < len(data); i += 2 {
+		binary.LittleEndian.PutUint16(data[i:], uint16(int16(8000*math.Sin(float64(i)*0.01))))
+	}
+	path := filepath.Join(t.TempDir(), "talk.wav")
+	if err := os.WriteFile(path, makeWAV(wavFormatPCM, 0, 2, 48000, 16, data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := FileDevicePath(FileDevicePrefix + path); !ok || got != path {
+		t.Errorf("FileDevicePath() = %q, %v", got, ok)
+	}
+
+	d := NewFileAudioDevice(path, PaceFast)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	var lengths []int
+	var samples []float32
+	for {
+		frame, err := d.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.Seq != uint64(len(lengths)) || frame.SampleRate != captureSampleRate || frame.Channels != 1 {
+			t.Errorf("frame %d: %+v", len(lengths), frame)
+		}
+		lengths = append(lengths, len(frame.Samples))
+		samples = append(samples, frame.Samples...)
+		frame.Release()
+	}
+	if want := []int{1600, 1600, 800}; !reflect.DeepEqual(lengths, want) {
+		t.Errorf("frame lengths = %v, want %v", lengths, want)
+	}
+	// Converting the file a frame at a time matches converting all of it.
+	wav, err := ReadWAVFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Resample(Downmix(wav.Samples, wav.Channels), wav.SampleRate, captureSampleRate); !reflect.DeepEqual(samples, want) {
+		t.Error("streamed samples differ from the whole file resampled")
+	}
+}
+
+func TestFileAudioDeviceRealtime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "talk.wav")
+	if err := os.WriteFile(path, makeWAV(wavFormatPCM, 0, 1, 16000, 16, make([]byte, 3*captureFrameSamples*2)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := NewFileAudioDevice(path, PaceRealtime)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	start := time.Now()
+	for frames := 0; frames < 3; {
+		frame, err := d.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frame.Samples) > 0 {
+			frames++
+		}
+	}
+	// Three 100ms frames can't all be out before 300ms have passed
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("read 300ms of audio in %s", elapsed)
+	}
+}
+
+func TestParsePCMFormat(t *testing.T) {
+	if f, err := ParsePCMFormat("", 16000, 1); err != nil || f.Encoding != PCMS16LE {
+		t.Errorf("ParsePCMFormat(\"\") = %v, %v; want s16le", f, err)
+	}
+	for _, bad := range []struct {
+		encoding string
+		rate     int
+		channels int
+	}{{"mp3", 16000, 1}, {"s16le", 0, 1}, {"f32le", 16000, 0}} {
+		if _, err := ParsePCMFormat(bad.encoding, bad.rate, bad.channels); err == nil {
+			t.Errorf("ParsePCMFormat(%q, %d, %d) accepted an invalid format", bad.encoding, bad.rate, bad.channels)
+		}
+	}
+	if path, ok := PipeDevicePath("-"); !ok || path != StdinDeviceID {
+		t.Errorf("PipeDevicePath(\"-\") = %q, %v", path, ok)
+	}
+	if _, ok := PipeDevicePath("default"); ok {
+		t.Error("PipeDevicePath took a device name for a pipe")
+	}
+}
+
+func TestPipeAudioDevice(t *testing.T) {
+	// 150ms of 8kHz stereo f32le: one full frame, then half of one
+	var data []byte
+	for i := 0; i < 1200*2; i++ {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(0.25))
+	}
+	path := filepath.Join(t.TempDir(), "audio.raw")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewPipeAudioDevice(path, PCMFormat{Encoding: PCMF32LE, SampleRate: 8000, Channels: 2})
+	if info := d.Info(); info.MaxChannels != 2 || info.DefaultSampleRate != 8000 || info.Index != -1 {
+		t.Errorf("Info = %+v", info)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	var lengths []int
+	for {
+		frame, err := d.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frame.Samples) == 0 {
+			continue
+		}
+		if frame.SampleRate != 8000 || frame.Channels != 2 || frame.Samples[0] != 0.25 {
+			t.Errorf("frame %d: rate %d, %d channels, first sample %v", frame.Seq, frame.SampleRate, frame.Channels, frame.Samples[0])
+		}
+		lengths = append(lengths, len(frame.Samples))
+		frame.Release()
+	}
+	if want := []int{1600, 800}; !reflect.DeepEqual(lengths, want) {
+		t.Errorf("frame lengths = %v, want %v", lengths, want)
+	}
+}
+
+// readSamples reads frames from d until it has n samples or the deadline passes.
+func readSamples(t *testing.T, d types.AudioDevice, n int, deadline time.Duration) []float32 {
+	t.Helper()
+	var got []float32
+	for end := time.Now().Add(deadline); len(got) < n && time.Now().Before(end); {
+		frame, err := d.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, frame.Samples...)
+		frame.Release()
+	}
+	return got
+}
+
+func s16le(value int16, n int) []byte {
+	var data []byte
+	for i := 0; i < n; i++ {
+		data = binary.LittleEndian.AppendUint16(data, uint16(value))
+	}
+	return data
+}
+
+func TestNetworkAudioDeviceTCP(t *testing.T) {
+	d, err := NewNetworkAudioDevice("tcp://127.0.0.1:0", PCMFormat{Encoding: PCMS16LE, SampleRate: 16000, Channels: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	conn, err := net.Dial("tcp", d.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100ms at half scale, split mid-sample
+	data := s16le(16384, 1600)
+	conn.Write(data[:1001])
+	conn.Write(data[1001:])
+	got := readSamples(t, d, 1600, 2*time.Second)
+	if len(got) != 1600 || got[0] != 0.5 || got[1599] != 0.5 {
+		t.Fatalf("got %d samples, want 1600 of 0.5", len(got))
+	}
+
+	// The sender goes away: the gap is filled with silence.
+	conn.Close()
+	silence := readSamples(t, d, 1600, 2*time.Second)
+	if len(silence) < 1600 {
+		t.Fatalf("got %d samples of silence, want 1600", len(silence))
+	}
+	for _, s := range silence {
+		if s != 0 {
+			t.Fatalf("gap filled with %v, want silence", s)
+		}
+	}
+
+	// And it comes back.
+	conn, err = net.Dial("tcp", d.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(s16le(-16384, 1600))
+	for end := time.Now().Add(2 * time.Second); time.Now().Before(end); {
+		frame, err := d.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resumed := len(frame.Samples) > 0 && frame.Samples[0] == -0.5
+		frame.Release()
+		if resumed {
+			return
+		}
+	}
+	t.Fatal("no audio after the sender reconnected")
+}
+
+func TestNetworkAudioDeviceUDP(t *testing.T) {
+	d, err := NewNetworkAudioDevice("udp://127.0.0.1:0", PCMFormat{Encoding: PCMF32LE, SampleRate: 8000, Channels: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	conn, err := net.Dial("udp", d.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 4; i++ {
+		var packet []byte
+		for j := 0; j < 400; j++ {
+			packet = binary.LittleEndian.AppendUint32(packet, math.Float32bits(0.25))
+		}
+		conn.Write(packet)
+	}
+	frame, err := d.Read()
+	for err == nil && len(frame.Samples) == 0 {
+		frame, err = d.Read()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frame.Release()
+	if frame.SampleRate != 8000 || frame.Channels != 2 || len(frame.Samples) != 1600 || frame.Samples[0] != 0.25 {
+		t.Errorf("frame: rate %d, %d channels, %d samples, first %v", frame.SampleRate, frame.Channels, len(frame.Samples), frame.Samples[0])
+	}
+}
+
+func TestNewNetworkAudioDevice(t *testing.T) {
+	format := PCMFormat{Encoding: PCMS16LE, SampleRate: 16000, Channels: 1}
+	for _, id := range []string{"tcp://:9000", "udp://0.0.0.0:9000", "ws://localhost:9000/audio"} {
+		if !IsNetworkDeviceID(id) {
+			t.Errorf("IsNetworkDeviceID(%q) = false", id)
+		}
+		if _, err := NewNetworkAudioDevice(id, format); err != nil {
+			t.Errorf("NewNetworkAudioDevice(%q): %v", id, err)
+		}
+	}
+	for _, id := range []string{"http://localhost:9000", "tcp://"} {
+		if _, err := NewNetworkAudioDevice(id, format); err == nil {
+			t.Errorf("NewNetworkAudioDevice(%q) succeeded", id)
+		}
+	}
+	if IsNetworkDeviceID("default") {
+		t.Error("IsNetworkDeviceID took a device name for a listener")
+	}
+}
+
+// dialWebSocket opens a WebSocket to d by hand, so tests can send frames a
+// client library wouldn't.
+func dialWebSocket(t *testing.T, d *NetworkAudioDevice) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", d.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET /audio HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", d.Addr())
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The accept value is the example from RFC 6455, section 1.3
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: %s, accept %q", resp.Status, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return conn, r
+}
+
+// wsFrame builds a masked client frame; lengths up to 65535 bytes.
+func wsFrame(first byte, payload []byte) []byte {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{first}
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(len(payload)))
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func startWebSocketDevice(t *testing.T) *NetworkAudioDevice {
+	t.Helper()
+	d, err := NewNetworkAudioDevice("ws://127.0.0.1:0/audio", PCMFormat{Encoding: PCMS16LE, SampleRate: 16000, Channels: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestNetworkAudioDeviceWebSocket(t *testing.T) {
+	d := startWebSocketDevice(t)
+	conn, _ := dialWebSocket(t, d)
+
+	// A final binary frame with a 16-bit length, as clients send
+	conn.Write(wsFrame(0x82, s16le(16384, 1600)))
+
+	got := readSamples(t, d, 1600, 2*time.Second)
+	if len(got) != 1600 || got[0] != 0.5 {
+		t.Fatalf("got %d samples, want 1600 of 0.5", len(got))
+	}
+}
+
+func TestNetworkAudioDeviceWebSocketBadControlFrames(t *testing.T) {
+	// RFC 6455 section 5.5: control frames carry at most 125 bytes and
+	// can't be fragmented. Either closes the connection with 1002.
+	for name, frame := range map[string][]byte{
+		"long ping":       wsFrame(0x89, make([]byte, 126)),
+		"fragmented ping": wsFrame(0x09, []byte("hi")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := startWebSocketDevice(t)
+			conn, r := dialWebSocket(t, d)
+			conn.Write(frame)
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			var head [4]byte
+			if _, err := io.ReadFull(r, head[:]); err != nil {
+				t.Fatal(err)
+			}
+			if head[0] != 0x88 || head[1] < 2 || binary.BigEndian.Uint16(head[2:]) != 1002 {
+				t.Errorf("server answered % x, want a close frame with status 1002", head)
+			}
+		})
+	}
+}
+
+func TestG711(t *testing.T) {
+	tests := []struct {
+		table []float32
+		code  byte
+		want  int
+	}{
+		{pcmuTable[:], 0xFF, 0},
+		{pcmuTable[:], 0x7F, 0},
+		{pcmuTable[:], 0x80, 32124},
+		{pcmuTable[:], 0x00, -32124},
+		{pcmaTable[:], 0xD5, 8},
+		{pcmaTable[:], 0x55, -8},
+		{pcmaTable[:], 0xAA, 32256},
+		{pcmaTable[:], 0x2A, -32256},
+	}
+	for _, tt := range tests {
+		if got := int(tt.table[tt.code] * 32768); got != tt.want {
+			t.Errorf("decoding %#02x = %d, want %d", tt.code, got, tt.want)
+		}
+	}
+}
+
+// rtpBytes builds an RTP packet.
+func rtpBytes(pt uint8, seq uint16, ts, ssrc uint32, payload []byte) []byte {
+	b := []byte{0x80, pt}
+	b = binary.BigEndian.AppendUint16(b, seq)
+	b = binary.BigEndian.AppendUint32(b, ts)
+	b = binary.BigEndian.AppendUint32(b, ssrc)
+	return append(b, payload...)
+}
+
+func TestParseRTP(t *testing.T) {
+	p, err := parseRTP(rtpBytes(0, 7, 160, 42, []byte{1, 2, 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.payloadType != 0 || p.seq != 7 || p.timestamp != 160 || p.ssrc != 42 || string(p.payload) != "\x01\x02\x03" {
+		t.Errorf("parsed %+v", p)
+	}
+
+	// One CSRC, a one-word header extension and two bytes of padding
+	b := rtpBytes(8, 1, 0, 1, nil)
+	b[0] |= 0x20 | 0x10 | 1
+	b = append(b, 0, 0, 0, 9)       // CSRC
+	b = append(b, 0xBE, 0xDE, 0, 1) // Extension header
+	b = append(b, 1, 2, 3, 4)       // Extension
+	b = append(b, 5, 6, 0, 2)       // Payload, then padding
+	p, err = parseRTP(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.payloadType != 8 || string(p.payload) != "\x05\x06" {
+		t.Errorf("payload type %d, payload %v", p.payloadType, p.payload)
+	}
+
+	if _, err := parseRTP([]byte{0x80, 0}); err == nil {
+		t.Error("parsed a truncated packet")
+	}
+	if _, err := parseRTP(rtpBytes(200, 0, 0, 0, nil)); err == nil {
+		t.Error("parsed an RTCP sender report")
+	}
+}
+
+func TestJitterBuffer(t *testing.T) {
+	packet := func(v float32) []float32 {
+		s := make([]float32, 160)
+		for i := range s {
+			s[i] = v
+		}
+		return s
+	}
+	start := time.Now()
+	j := newJitterBuffer(60*time.Millisecond, 8000, 1)
+
+	// Reordered packets are played in order, even the first.
+	j.put(1, 160, packet(2), start)
+	j.put(0, 0, packet(1), start)
+	j.put(2, 320, packet(3), start)
+	if _, ok := j.pull(start); ok {
+		t.Fatal("played the first packet before the jitter delay")
+	}
+	for want := float32(1); want <= 3; want++ {
+		s, ok := j.pull(start.Add(60 * time.Millisecond))
+		if !ok || len(s) != 160 || s[0] != want {
+			t.Fatalf("pull: ok %v, %d samples of %v, want %v", ok, len(s), s, want)
+		}
+	}
+
+	// A missing packet is waited for, then concealed with a fading copy of
+	// the last one.
+	j.put(4, 640, packet(5), start)
+	if _, ok := j.pull(start.Add(30 * time.Millisecond)); ok {
+		t.Fatal("gave up on packet 3 before the jitter delay")
+	}
+	s, ok := j.pull(start.Add(60 * time.Millisecond))
+	if !ok || len(s) != 160 || s[0] <= 0 || s[0] >= 3 {
+		t.Fatalf("concealment: ok %v, %d samples starting %v", ok, len(s), s[0])
+	}
+	if s, _ := j.pull(start.Add(60 * time.Millisecond)); len(s) != 160 || s[0] != 5 {
+		t.Fatalf("after concealment got %v", s[0])
+	}
+	j.put(3, 480, packet(4), start.Add(70*time.Millisecond))
+	if j.stats.lost != 1 || j.stats.late != 1 || j.buffered() != 0 {
+		t.Errorf("stats %+v with %d buffered, want 1 lost, 1 late, 0 buffered", j.stats, j.buffered())
+	}
+
+	// A pause in transmission, with consecutive sequence numbers but a jump
+	// in timestamps, is played as silence.
+	j.put(5, 800+400, packet(6), start)
+	if s, _ := j.pull(start); len(s) != 400 || s[0] != 0 {
+		t.Errorf("pause: %d samples starting %v, want 400 of silence", len(s), s[0])
+	}
+	if s, _ := j.pull(start); len(s) != 160 || s[0] != 6 {
+		t.Errorf("after pause got %d samples starting %v", len(s), s[0])
+	}
+}
+
+func TestRTPAudioDevice(t *testing.T) {
+	d := NewRTPAudioDevice("127.0.0.1:0", RTPConfig{})
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	conn, err := net.Dial("udp", d.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 200ms of PCMU in 20ms packets, the first two swapped
+	payload := make([]byte, 160)
+	for i := range payload {
+		payload[i] = 0x80 // Loudest positive sample
+	}
+	for _, seq := range []uint16{1, 0, 2, 3, 4, 5, 6, 7, 8, 9} {
+		conn.Write(rtpBytes(rtpPayloadPCMU, 1000+seq, 5000+160*uint32(seq), 0xCAFE, payload))
+	}
+	got := readSamples(t, d, 1600, 2*time.Second)
+	if len(got) < 1600 {
+		t.Fatalf("got %d samples, want 1600", len(got))
+	}
+	for i, s := range got[:1600] {
+		if int(s*32768) != 32124 {
+			t.Fatalf("sample %d = %v", i, s)
+		}
+	}
+
+	frame, err := d.Read()
+	for err == nil && len(frame.Samples) == 0 {
+		frame, err = d.Read()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frame.Release()
+	if frame.SampleRate != 8000 || frame.Channels != 1 {
+		t.Errorf("frame at %d Hz with %d channels, want 8000 Hz mono", frame.SampleRate, frame.Channels)
+	}
+	for _, s := range frame.Samples {
+		if s != 0 {
+			t.Fatalf("after the stream stopped got %v, want silence", s)
+		}
+	}
+}
+
+func TestRTPAudioDeviceResamples(t *testing.T) {
+	d := NewRTPAudioDevice("127.0.0.1:0", RTPConfig{SampleRate: 16000})
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	conn, err := net.Dial("udp", d.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 300ms of PCMU at 8 kHz
+	payload := make([]byte, 160)
+	for i := range payload {
+		payload[i] = 0x80
+	}
+	for seq := range uint16(15) {
+		conn.Write(rtpBytes(rtpPayloadPCMU, seq, 160*uint32(seq), 0xCAFE, payload))
+	}
+	var frames int
+	for deadline := time.Now().Add(2 * time.Second); frames < 4 && time.Now().Before(deadline); {
+		frame, err := d.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frame.Samples) == 0 {
+			continue
+		}
+		if frame.SampleRate != 16000 || len(frame.Samples) != 1600 {
+			t.Fatalf("frame %d: %d samples at %d Hz, want 1600 at 16000 Hz", frames, len(frame.Samples), frame.SampleRate)
+		}
+		if frames == 1 {
+			// Inside the stream the level comes through resampling unchanged.
+			for i, s := range frame.Samples {
+				if math.Abs(float64(s)*32768-32124) > 1 {
+					t.Fatalf("sample %d of the second frame = %v", i, s)
+				}
+			}
+		}
+		frame.Release()
+		frames++
+	}
+	if frames < 4 {
+		t.Fatalf("got %d frames, want 4 with silence after the stream", frames)
+	}
+	if info := d.Info(); info.DefaultSampleRate != 16000 {
+		t.Errorf("Info reports %v Hz, want 16000", info.DefaultSampleRate)
+	}
+}
+
+// scriptedDevice returns frames of one sample until it has returned frames,
+// then fails with err, or returns nothing if err is nil.
+type scriptedDevice struct {
+	name      string
+	isDefault bool
+	frames    int
+	err       error
+	started   bool
+	closed    bool
+	seq       uint64
+}
+
+func (d *scriptedDevice) Name() string    { return d.name }
+func (d *scriptedDevice) ID() interface{} { return "id:" + d.name }
+func (d *scriptedDevice) Info() types.DeviceInfo {
+	return types.DeviceInfo{HostAPI: "test", Index: -1, MaxChannels: 1, DefaultSampleRate: 16000, IsDefault: d.isDefault}
+}
+func (d *scriptedDevice) Start() error { d.started = true; return nil }
+func (d *scriptedDevice) Close() error { d.closed = true; return nil }
+
+func (d *scriptedDevice) Read() (types.AudioFrame, error) {
+	if d.frames == 0 {
+		return types.AudioFrame{}, d.err
+	}
+	d.frames--
+	d.seq++
+	return types.AudioFrame{Seq: d.seq - 1, SampleRate: 16000, Channels: 1, Samples: []float32{0.5}}, nil
+}
+
+type deviceList []types.AudioDevice
+
+func (l *deviceList) GetDevices() ([]types.AudioDevice, error) {
+	if len(*l) == 0 {
+		return nil, fmt.Errorf("no devices")
+	}
+	return *l, nil
+}
+
+func TestSupervisorReconnects(t *testing.T) {
+	mic := &scriptedDevice{name: "USB Mic", frames: 2, err: fmt.Errorf("device unplugged")}
+	var present deviceList
+	var statuses []types.DeviceStatusMsg
+	s := NewSupervisor(mic, SupervisorConfig{
+		Provider: &present,
+		Interval: time.Millisecond,
+		OnStatus: func(msg types.DeviceStatusMsg) { statuses = append(statuses, msg) },
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var seqs []uint64
+	read := func() {
+		t.Helper()
+		frame, err := s.Read()
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if len(frame.Samples) > 0 {
+			seqs = append(seqs, frame.Seq)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		read()
+	}
+	if !mic.closed || len(statuses) != 1 || statuses[0].State != types.DeviceReconnecting || statuses[0].Device != "USB Mic" {
+		t.Fatalf("after the failure: closed %v, statuses %+v", mic.closed, statuses)
+	}
+
+	// Nothing to reconnect to yet
+	for i := 0; i < 3; i++ {
+		read()
+	}
+
+	// The microphone is plugged back in, under a new device object.
+	replugged := &scriptedDevice{name: "USB Mic", frames: 2}
+	present = deviceList{&scriptedDevice{name: "Other"}, replugged}
+	for i := 0; i < 4; i++ {
+		read()
+	}
+	if !replugged.started || len(statuses) != 2 || statuses[1].State != types.DeviceConnected {
+		t.Fatalf("after replugging: started %v, statuses %+v", replugged.started, statuses)
+	}
+	if want := []uint64{0, 1, 2, 3}; !reflect.DeepEqual(seqs, want) {
+		t.Errorf("sequence numbers %v, want %v carrying on across devices", seqs, want)
+	}
+	if s.Name() != "USB Mic" {
+		t.Errorf("Name() = %q", s.Name())
+	}
+	s.Close()
+	if !replugged.closed {
+		t.Error("Close didn't close the reconnected device")
+	}
+	if _, err := s.Read(); err == nil {
+		t.Error("Read after Close succeeded")
+	}
+}
+
+func TestSupervisorFallbackAndStall(t *testing.T) {
+	// A device that simply stops delivering audio is lost too.
+	mic := &scriptedDevice{name: "USB Mic", frames: 1}
+	fallback := &scriptedDevice{name: "Built-in Mic", frames: 1}
+	present := deviceList{fallback}
+	var states []types.DeviceState
+	s := NewSupervisor(mic, SupervisorConfig{
+		Provider:     &present,
+		Fallbacks:    []string{"Missing Mic", "id:Built-in Mic"},
+		Interval:     time.Millisecond,
+		StallTimeout: 20 * time.Millisecond,
+		OnStatus:     func(msg types.DeviceStatusMsg) { states = append(states, msg.State) },
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for end := time.Now().Add(time.Second); !fallback.started && time.Now().Before(end); {
+		if _, err := s.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !mic.closed || !fallback.started || s.Name() != "Built-in Mic" {
+		t.Fatalf("mic closed %v, fallback started %v, using %q", mic.closed, fallback.started, s.Name())
+	}
+	if want := []types.DeviceState{types.DeviceReconnecting, types.DeviceConnected}; !reflect.DeepEqual(states, want) {
+		t.Errorf("states %v, want %v", states, want)
+	}
+}
+
+// closingDevice has its Supervisor closed while a Read is in progress, as
+// happens at shutdown.
+type closingDevice struct {
+	*scriptedDevice
+	supervisor *Supervisor
+}
+
+func (d *closingDevice) Read() (types.AudioFrame, error) {
+	d.supervisor.Close()
+	return types.AudioFrame{}, fmt.Errorf("%s closed", d.name)
+}
+
+func TestSupervisorClosedDuringRead(t *testing.T) {
+	device := &closingDevice{scriptedDevice: &scriptedDevice{name: "USB Mic"}}
+	var states []types.DeviceState
+	s := NewSupervisor(device, SupervisorConfig{
+		Provider: &deviceList{device},
+		OnStatus: func(msg types.DeviceStatusMsg) { states = append(states, msg.State) },
+	})
+	device.supervisor = s
+	s.Start()
+	if _, err := s.Read(); err == nil {
+		t.Error("Read interrupted by Close succeeded")
+	}
+	if len(states) != 0 {
+		t.Errorf("Close was reported as %v", states)
+	}
+}
+
+func TestSupervisorPassesEOF(t *testing.T) {
+	s := NewSupervisor(&scriptedDevice{name: "talk.wav", err: io.EOF}, SupervisorConfig{})
+	s.Start()
+	if _, err := s.Read(); err != io.EOF {
+		t.Errorf("Read = %v, want io.EOF", err)
+	}
+}
+
+func TestSelectDevice(t *testing.T) {
+	hdmi := &scriptedDevice{name: "HDA Intel HDMI"}
+	builtIn := &scriptedDevice{name: "Built-in Microphone", isDefault: true}
+	yeti := &scriptedDevice{name: "Blue Yeti Stereo Microphone"}
+	usb := &scriptedDevice{name: "USB Audio Device"}
+	devices := []types.AudioDevice{hdmi, builtIn, yeti, usb}
+
+	for _, tt := range []struct {
+		patterns []string
+		want     types.AudioDevice
+	}{
+		{[]string{"default"}, builtIn},
+		{[]string{"id:USB Audio Device"}, usb},
+		{[]string{"HDA Intel HDMI"}, hdmi},
+		{[]string{"blue yeti"}, yeti},
+		{[]string{"/^USB.*Device$/"}, usb},
+		{[]string{"microphone"}, builtIn}, // The first of several matches
+		{[]string{"", "Rode NT-USB", "Blue Yeti", "default"}, yeti},
+		{[]string{"Rode NT-USB", "default"}, builtIn},
+	} {
+		got, _, err := SelectDevice(devices, tt.patterns)
+		if err != nil || got != tt.want {
+			t.Errorf("SelectDevice(%q) = %v, %v; want %s", tt.patterns, got, err, tt.want.Name())
+		}
+	}
+
+	// Without a marked default, the first device listed stands in for it.
+	if got, _, _ := SelectDevice([]types.AudioDevice{hdmi, usb}, []string{"default"}); got != hdmi {
+		t.Errorf("default without a marked default = %v, want the first device", got)
+	}
+	if _, _, err := SelectDevice(devices, []string{"Rode NT-USB"}); !errors.Is(err, ErrNoMatchingDevice) {
+		t.Errorf("unmatched pattern: err = %v, want ErrNoMatchingDevice", err)
+	}
+	if _, _, err := SelectDevice(devices, nil); !errors.Is(err, ErrNoMatchingDevice) {
+		t.Errorf("no patterns: err = %v, want ErrNoMatchingDevice", err)
+	}
+	if _, _, err := SelectDevice(devices, []string{"/(/"}); err == nil || errors.Is(err, ErrNoMatchingDevice) {
+		t.Errorf("invalid regexp: err = %v", err)
+	}
+}
+
+// filterSine runs seconds of a sine wave through f in 100ms frames of 16kHz
+// mono, offset by dc, and returns the RMS level of the last frame.
+func filterSine(f AudioFilter, freq, amplitude, dc, seconds float64) float64 {
+	const rate = 16000
+	var last []float32
+	for n := 0; n < int(seconds*rate); n += rate / 10 {
+		samples := make([]float32, rate/10)
+		for i := range samples {
+			samples[i] = float32(dc + amplitude*math.Sin(2*math.Pi*freq*float64(n+i)/rate))
+		}
+		frame := types.AudioFrame{SampleRate: rate, Channels: 1, Samples: samples}
+		f.Process(&frame)
+		last = frame.Samples
+	}
+	return dsp.RMS(last)
+}
+
+func TestDCBlocker(t *testing.T) {
+	// An offset alone disappears; a tone on top of one is kept.
+	if level := filterSine(NewDCBlocker(), 0, 0, 0.3, 2); level > 0.001 {
+		t.Errorf("offset left at %v", level)
+	}
+	if level := filterSine(NewDCBlocker(), 440, 0.5, 0.3, 2); math.Abs(level-0.5/math.Sqrt2) > 0.01 {
+		t.Errorf("tone level %v, want %v", level, 0.5/math.Sqrt2)
+	}
+}
+
+func TestHighPass(t *testing.T) {
+	for _, tt := range []struct {
+		freq     float64
+		min, max float64 // Range of output/input level
+	}{
+		{20, 0, 0.1},       // Rumble, two octaves below the cutoff: -24 dB
+		{80, 0.65, 0.75},   // At the cutoff: -3 dB
+		{1000, 0.99, 1.01}, // Speech
+	} {
+		ratio := filterSine(NewHighPass(80), tt.freq, 0.5, 0, 1) / (0.5 / math.Sqrt2)
+		if ratio < tt.min || ratio > tt.max {
+			t.Errorf("%v Hz passed at %.3f, want %v to %v", tt.freq, ratio, tt.min, tt.max)
+		}
+	}
+}
+
+func TestNoiseGate(t *testing.T) {
+	// Hiss at -60 dBFS is turned down by the gate's range; speech at -20 dBFS
+	// passes unchanged.
+	quiet := dbToLinear(-60)
+	if level := filterSine(NewNoiseGate(-50), 1000, quiet, 0, 2); level > quiet/math.Sqrt2*dbToLinear(gateFloorDB+1) {
+		t.Errorf("gated level %v dBFS", 20*math.Log10(level))
+	}
+	loud := dbToLinear(-20)
+	if level := filterSine(NewNoiseGate(-50), 1000, loud, 0, 1); math.Abs(level-loud/math.Sqrt2) > 0.001 {
+		t.Errorf("open gate changed the level to %v", level)
+	}
+}
+
+func TestAGC(t *testing.T) {
+	// A quiet voice is brought up to the target level...
+	agc := NewAGC(-20, 30)
+	level := filterSine(agc, 300, dbToLinear(-40), 0, 10)
+	if db := 20 * math.Log10(level); math.Abs(db+20) > 1 {
+		t.Errorf("AGC output %.1f dBFS, want -20", db)
+	}
+	// ...but never by more than the maximum gain,
+	agc = NewAGC(-20, 10)
+	filterSine(agc, 300, dbToLinear(-50), 0, 10)
+	if db := 20 * math.Log10(agc.Gain()); math.Abs(db-10) > 0.5 {
+		t.Errorf("AGC gain %.1f dB, want the 10 dB maximum", db)
+	}
+	// and silence doesn't raise it.
+	agc = NewAGC(-20, 30)
+	filterSine(agc, 0, 0, 0, 5)
+	if agc.Gain() != 1 {
+		t.Errorf("AGC gain %v after silence, want 1", agc.Gain())
+	}
+}
+
+func TestFilterChain(t *testing.T) {
+	chain, err := NewFilterChain([]string{"dc", "HighPass", "gate", "agc", "gain"}, FilterConfig{
+		HighPassHz: 100, GateThresholdDB: -50, AGCTargetDB: -20, AGCMaxGainDB: 30, GainDB: 6,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := chain.String(), "dc -> highpass 100 Hz -> gate -50 dBFS -> agc -20 dBFS -> gain +6.0 dB"; got != want {
+		t.Errorf("chain = %q, want %q", got, want)
+	}
+
+	frame := types.AudioFrame{SampleRate: 16000, Channels: 2, Samples: []float32{0.9, -0.9, 0.9, -0.9}}
+	gain, _ := NewFilterChain([]string{"gain"}, FilterConfig{GainDB: 6})
+	gain.Process(&frame)
+	if want := []float32{1, -1, 1, -1}; !reflect.DeepEqual(frame.Samples, want) {
+		t.Errorf("gain output %v, want clipped %v", frame.Samples, want)
+	}
+
+	if chain, err := NewFilterChain(nil, FilterConfig{}); err != nil || len(chain) != 0 {
+		t.Errorf("empty chain = %v, %v", chain, err)
+	}
+	for _, bad := range [][]string{{"reverb"}, {"highpass"}} {
+		if _, err := NewFilterChain(bad, FilterConfig{}); err == nil {
+			t.Errorf("NewFilterChain(%q) accepted an invalid chain", bad)
+		}
+	}
+}
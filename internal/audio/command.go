@@ -0,0 +1,194 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/types"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// CommandDevice captures audio from a recorder program, such as parec or
+// pw-record, that writes raw 32-bit float little-endian samples to its
+// standard output at captureSampleRate with captureChannels channels.
+type CommandDevice struct {
+	name string
+	id   string
+	args []string // Program and arguments
+	info types.DeviceInfo
+
+	cmd    *exec.Cmd
+	stderr *tailBuffer // End of the recorder's error output, for error messages
+	frames *ringFrames
+	wg     sync.WaitGroup // The goroutine reading the recorder's output
+	mutex  sync.Mutex     // Serializes Start and Close
+	closed bool
+}
+
+// NewCommandDevice creates a device named name that runs args when started.
+func NewCommandDevice(name, id string, args ...string) *CommandDevice {
+	info := types.DeviceInfo{HostAPI: "command", Index: -1, MaxChannels: captureChannels, DefaultSampleRate: captureSampleRate}
+	return &CommandDevice{name: name, id: id, args: args, info: info}
+}
+
+func (d *CommandDevice) Name() string {
+	return d.name
+}
+
+func (d *CommandDevice) ID() interface{} {
+	return d.id
+}
+
+func (d *CommandDevice) Info() types.DeviceInfo {
+	return d.info
+}
+
+func (d *CommandDevice) Start() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.cmd != nil {
+		return fmt.Errorf("%s already started", d.name)
+	}
+	if len(d.args) == 0 {
+		return fmt.Errorf("no recorder command for %s", d.name)
+	}
+
+	cmd := exec.Command(d.args[0], d.args[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	d.stderr = &tailBuffer{max: 4096}
+	cmd.Stderr = d.stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", d.args[0], err)
+	}
+	d.cmd = cmd
+	d.closed = false
+	d.frames = newRingFrames(d.name, captureSampleRate, captureChannels)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.capture(stdout)
+	}()
+
+	logger.Info("Audio capture started on device: %s (via %s)", d.name, d.args[0])
+	return nil
+}
+
+// capture converts the recorder's output into samples until it ends.
+func (d *CommandDevice) capture(stdout io.Reader) {
+	const blockSamples = captureFrameSamples * captureChannels / 4 // 25ms blocks
+	buf := make([]byte, blockSamples*4)
+	samples := make([]float32, blockSamples)
+	pending := 0 // Bytes of buf carried over from the previous read
+	for {
+		n, err := stdout.Read(buf[pending:])
+		pending += n
+		whole := pending / 4 * 4
+		if whole > 0 {
+			for i := 0; i < whole/4; i++ {
+				samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+			}
+			d.frames.push(samples[:whole/4])
+			pending = copy(buf, buf[whole:pending])
+		}
+		if err != nil {
+			d.finish(err)
+			return
+		}
+	}
+}
+
+// finish waits for the recorder to exit and stops the frames with the reason.
+func (d *CommandDevice) finish(readErr error) {
+	waitErr := d.cmd.Wait()
+
+	d.mutex.Lock()
+	closed := d.closed
+	d.mutex.Unlock()
+	if closed {
+		d.frames.stop(fmt.Errorf("%s closed", d.name))
+		return
+	}
+
+	reason := waitErr
+	if reason == nil && !errors.Is(readErr, io.EOF) {
+		reason = readErr
+	}
+	if reason == nil {
+		reason = errors.New("exited")
+	}
+	if msg := strings.TrimSpace(d.stderr.String()); msg != "" {
+		reason = fmt.Errorf("%w: %s", reason, msg)
+	}
+	err := fmt.Errorf("recorder %s for %s stopped: %w", d.args[0], d.name, reason)
+	logger.Warn("%v", err)
+	d.frames.stop(err)
+}
+
+// Read returns the next 100ms of audio. Its samples come from a pool, so the
+// last holder of the frame should call Release on it.
+func (d *CommandDevice) Read() (types.AudioFrame, error) {
+	d.mutex.Lock()
+	frames := d.frames
+	d.mutex.Unlock()
+	if frames == nil {
+		return types.AudioFrame{}, fmt.Errorf("%s not capturing", d.name)
+	}
+	return frames.read()
+}
+
+func (d *CommandDevice) Close() error {
+	d.mutex.Lock()
+	if d.cmd == nil || d.closed {
+		d.mutex.Unlock()
+		return nil
+	}
+	d.closed = true
+	cmd := d.cmd
+	d.mutex.Unlock()
+
+	// The recorder exits once killed, which ends capture and reaps it.
+	if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		logger.Debug("Killing %s: %v", d.args[0], err)
+	}
+	d.wg.Wait()
+
+	d.mutex.Lock()
+	d.cmd = nil
+	d.mutex.Unlock()
+	logger.Info("Audio capture stopped on device: %s", d.name)
+	return nil
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	if extra := b.buf.Len() - b.max; extra > 0 {
+		b.buf.Next(extra)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
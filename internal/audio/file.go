@@ -0,0 +1,194 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/types"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileDevicePrefix marks a device ID that names a WAV file rather than a
+// capture device, as in "file:/path/to/talk.wav".
+const FileDevicePrefix = "file:"
+
+// FilePacing is how fast a FileAudioDevice hands out its audio.
+type FilePacing string
+
+const (
+	// PaceRealtime returns each frame once as much time has passed since
+	// Start as the audio before it lasts, as if it were being captured.
+	PaceRealtime FilePacing = "realtime"
+	// PaceFast returns frames as fast as they are read.
+	PaceFast FilePacing = "fast"
+)
+
+// ParseFilePacing validates a pacing name from the configuration.
+func ParseFilePacing(name string) (FilePacing, error) {
+	switch p := FilePacing(name); p {
+	case PaceRealtime, PaceFast:
+		return p, nil
+	case "":
+		return PaceRealtime, nil
+	default:
+		return "", fmt.Errorf("unknown file pacing %q (want %s or %s)", name, PaceRealtime, PaceFast)
+	}
+}
+
+// FileAudioDevice plays a WAV file as if it were captured, converted to the
+// capture format: 16kHz mono. Once the file is used up, Read returns io.EOF.
+type FileAudioDevice struct {
+	path   string
+	pacing FilePacing
+
+	file      *os.File
+	wav       *wavReader
+	resampler *resampler
+	block     []float32 // Interleaved input, read a frame at a time
+	mono      []float32 // block downmixed
+	pending   []float32 // Converted samples not yet returned
+	ended     bool      // The whole file has been read
+
+	pool    *types.SamplePool
+	pos     int       // Samples returned so far, at captureSampleRate
+	seq     uint64    // Sequence number of the next frame
+	start   time.Time // When Start was called
+	started bool
+	closed  bool
+	mu      sync.Mutex
+}
+
+// NewFileAudioDevice creates a device playing the WAV file at path. Start
+// opens the file, and Read decodes it as it goes.
+func NewFileAudioDevice(path string, pacing FilePacing) *FileAudioDevice {
+	return &FileAudioDevice{path: path, pacing: pacing}
+}
+
+// FileDevicePath returns the file a device ID names and whether it names one.
+func FileDevicePath(id string) (string, bool) {
+	return strings.CutPrefix(id, FileDevicePrefix)
+}
+
+func (d *FileAudioDevice) Name() string {
+	return d.path
+}
+
+func (d *FileAudioDevice) ID() interface{} {
+	return FileDevicePrefix + d.path
+}
+
+// Info describes the audio as Read returns it, whatever the file holds.
+func (d *FileAudioDevice) Info() types.DeviceInfo {
+	return types.DeviceInfo{HostAPI: "file", Index: -1, MaxChannels: 1, DefaultSampleRate: captureSampleRate}
+}
+
+// Start opens the file and reads its header.
+func (d *FileAudioDevice) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.started {
+		return fmt.Errorf("file device %s already started", d.path)
+	}
+
+	f, err := os.Open(d.path)
+	if err != nil {
+		return err
+	}
+	wav, err := newWAVReader(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", d.path, err)
+	}
+	d.file, d.wav = f, wav
+	d.resampler = newResampler(wav.sampleRate, captureSampleRate)
+	d.block = make([]float32, max(1, wav.sampleRate/10)*wav.channels) // 100ms
+	d.mono = make([]float32, max(1, wav.sampleRate/10))
+	d.pending, d.ended = d.pending[:0], false
+	d.pool = types.NewSamplePool(captureFrameSamples, framePoolSize)
+	d.pos, d.seq = 0, 0
+	d.start = time.Now()
+	d.started, d.closed = true, false
+
+	logger.Info("Playing %s (%d Hz, %d channels, %s) at %s pace", d.path, wav.sampleRate, wav.channels,
+		time.Duration(wav.frames)*time.Second/time.Duration(wav.sampleRate), d.pacing)
+	return nil
+}
+
+// fill decodes and converts the file until a frame's worth of samples is
+// pending or the file ends.
+func (d *FileAudioDevice) fill() error {
+	for len(d.pending) < captureFrameSamples && !d.ended {
+		n, err := d.wav.read(d.block)
+		if err == io.EOF {
+			d.ended = true
+		} else if err != nil {
+			return fmt.Errorf("reading %s: %w", d.path, err)
+		}
+		d.pending = d.resampler.process(d.pending, downmixInto(d.mono, d.block[:n], d.wav.channels), d.ended)
+	}
+	return nil
+}
+
+// Read returns the next 100ms of the file, the last frame possibly shorter,
+// then io.EOF. Its samples come from a pool, so the last holder of the frame
+// should call Release on it.
+func (d *FileAudioDevice) Read() (types.AudioFrame, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.started || d.closed {
+		return types.AudioFrame{}, fmt.Errorf("file device %s not playing", d.path)
+	}
+	if err := d.fill(); err != nil {
+		return types.AudioFrame{}, err
+	}
+	if len(d.pending) == 0 {
+		return types.AudioFrame{}, io.EOF
+	}
+
+	// The frame is "captured" once its last sample would have been heard.
+	n := min(captureFrameSamples, len(d.pending))
+	end := time.Duration(d.pos+n) * time.Second / captureSampleRate
+	captureTime := time.Now()
+	if d.pacing == PaceRealtime {
+		if wait := time.Until(d.start.Add(end)); wait > 0 {
+			if wait > readTimeout {
+				// Like a capture device, report no data rather than block long.
+				time.Sleep(readTimeout)
+				return types.AudioFrame{}, nil
+			}
+			time.Sleep(wait)
+		}
+		captureTime = d.start.Add(time.Duration(d.pos) * time.Second / captureSampleRate)
+	}
+
+	samples := d.pool.Get()[:n]
+	copy(samples, d.pending)
+	d.pending = append(d.pending[:0], d.pending[n:]...)
+	frame := types.AudioFrame{
+		Seq:         d.seq,
+		CaptureTime: captureTime,
+		SampleRate:  captureSampleRate,
+		Channels:    1,
+		Samples:     samples,
+		Pool:        d.pool,
+	}
+	d.pos += n
+	d.seq++
+	return frame, nil
+}
+
+func (d *FileAudioDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	d.started = false
+	if d.file == nil {
+		return nil
+	}
+	err := d.file.Close()
+	d.file, d.wav = nil, nil
+	return err
+}
@@ -0,0 +1,342 @@
+package audio
+
+import (
+	"fmt"
+	"livelylivecaptions/internal/dsp"
+	"livelylivecaptions/internal/types"
+	"math"
+	"strings"
+	"time"
+)
+
+// AudioFilter conditions captured audio before it is transcribed. Process
+// changes the frame's samples in place. Filters keep state from frame to
+// frame, so each instance must only see one stream, in order; they start
+// over when the stream's sample rate or channel count changes.
+type AudioFilter interface {
+	Name() string
+	Process(frame *types.AudioFrame)
+}
+
+// Filter names accepted by NewFilterChain.
+const (
+	FilterDC       = "dc"
+	FilterHighPass = "highpass"
+	FilterGate     = "gate"
+	FilterAGC      = "agc"
+	FilterGain     = "gain"
+)
+
+// Defaults for FilterConfig.
+const (
+	DefaultHighPassHz      = 80.0
+	DefaultGateThresholdDB = -50.0
+	DefaultAGCTargetDB     = -20.0
+	DefaultAGCMaxGainDB    = 30.0
+)
+
+// FilterConfig holds the settings of the filters NewFilterChain builds.
+type FilterConfig struct {
+	HighPassHz      float64 // Cutoff of the high-pass filter
+	GateThresholdDB float64 // Level in dBFS below which the gate closes
+	AGCTargetDB     float64 // RMS level in dBFS the AGC aims for
+	AGCMaxGainDB    float64 // Most the AGC amplifies, so silence isn't boosted into noise
+	GainDB          float64 // Fixed gain
+}
+
+// FilterChain applies filters in order. An empty chain leaves audio alone.
+type FilterChain []AudioFilter
+
+// NewFilterChain builds the filters named, in the order given.
+func NewFilterChain(names []string, cfg FilterConfig) (FilterChain, error) {
+	var chain FilterChain
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case FilterDC:
+			chain = append(chain, NewDCBlocker())
+		case FilterHighPass:
+			if cfg.HighPassHz <= 0 {
+				return nil, fmt.Errorf("high-pass cutoff must be positive, not %v Hz", cfg.HighPassHz)
+			}
+			chain = append(chain, NewHighPass(cfg.HighPassHz))
+		case FilterGate:
+			chain = append(chain, NewNoiseGate(cfg.GateThresholdDB))
+		case FilterAGC:
+			if cfg.AGCMaxGainDB < 0 {
+				return nil, fmt.Errorf("AGC maximum gain must not be negative, not %v dB", cfg.AGCMaxGainDB)
+			}
+			chain = append(chain, NewAGC(cfg.AGCTargetDB, cfg.AGCMaxGainDB))
+		case FilterGain:
+			chain = append(chain, NewGain(cfg.GainDB))
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown audio filter %q (want %s, %s, %s, %s or %s)", name, FilterDC, FilterHighPass, FilterGate, FilterAGC, FilterGain)
+		}
+	}
+	return chain, nil
+}
+
+// Process runs frame through every filter.
+func (c FilterChain) Process(frame *types.AudioFrame) {
+	for _, f := range c {
+		f.Process(frame)
+	}
+}
+
+func (c FilterChain) String() string {
+	names := make([]string, len(c))
+	for i, f := range c {
+		names[i] = f.Name()
+	}
+	return strings.Join(names, " -> ")
+}
+
+// streamFormat remembers the format a filter's state was set up for.
+type streamFormat struct {
+	rate, channels int
+}
+
+// changed reports whether frame has a different format, taking it on if so.
+func (s *streamFormat) changed(frame *types.AudioFrame) bool {
+	channels := max(frame.Channels, 1)
+	if frame.SampleRate == s.rate && channels == s.channels {
+		return false
+	}
+	s.rate, s.channels = frame.SampleRate, channels
+	return true
+}
+
+func dbToLinear(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// smoothing returns the coefficient of a one-pole smoother that covers about
+// two thirds of a step in tau, updated every step.
+func smoothing(tau, step time.Duration) float64 {
+	if tau <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-step.Seconds()/tau.Seconds())
+}
+
+func clip(v float64) float32 {
+	return float32(min(max(v, -1), 1))
+}
+
+// dcCutoffHz is where the DC blocker starts letting audio through; far
+// below speech, so it only removes offset and the slowest drift.
+const dcCutoffHz = 5
+
+// DCBlocker removes a constant offset from the signal, as cheap sound cards
+// and some USB microphones add.
+type DCBlocker struct {
+	format streamFormat
+	r      float64
+	x1, y1 []float64 // Previous input and output, per channel
+}
+
+// NewDCBlocker creates a DC blocker.
+func NewDCBlocker() *DCBlocker {
+	return &DCBlocker{}
+}
+
+func (f *DCBlocker) Name() string { return FilterDC }
+
+func (f *DCBlocker) Process(frame *types.AudioFrame) {
+	if f.format.changed(frame) {
+		f.r = math.Exp(-2 * math.Pi * dcCutoffHz / float64(max(frame.SampleRate, 1)))
+		f.x1 = make([]float64, f.format.channels)
+		f.y1 = make([]float64, f.format.channels)
+	}
+	for i, v := range frame.Samples {
+		c := i % f.format.channels
+		x := float64(v)
+		y := x - f.x1[c] + f.r*f.y1[c]
+		f.x1[c], f.y1[c] = x, y
+		frame.Samples[i] = float32(y)
+	}
+}
+
+// HighPass is a second-order Butterworth high-pass filter, for rumble,
+// handling noise and mains hum below speech.
+type HighPass struct {
+	cutoff float64
+	format streamFormat
+
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     []float64 // Per channel
+}
+
+// NewHighPass creates a high-pass filter that cuts below cutoffHz.
+func NewHighPass(cutoffHz float64) *HighPass {
+	return &HighPass{cutoff: cutoffHz}
+}
+
+func (f *HighPass) Name() string { return fmt.Sprintf("%s %.0f Hz", FilterHighPass, f.cutoff) }
+
+func (f *HighPass) Process(frame *types.AudioFrame) {
+	if f.format.changed(frame) {
+		f.design(float64(max(frame.SampleRate, 1)))
+		n := f.format.channels
+		f.x1, f.x2, f.y1, f.y2 = make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	}
+	for i, v := range frame.Samples {
+		c := i % f.format.channels
+		x := float64(v)
+		y := f.b0*x + f.b1*f.x1[c] + f.b2*f.x2[c] - f.a1*f.y1[c] - f.a2*f.y2[c]
+		f.x2[c], f.x1[c] = f.x1[c], x
+		f.y2[c], f.y1[c] = f.y1[c], y
+		frame.Samples[i] = float32(y)
+	}
+}
+
+// design computes the coefficients for rate, from the Audio EQ Cookbook.
+func (f *HighPass) design(rate float64) {
+	cutoff := min(f.cutoff, 0.45*rate) // Stay clear of the Nyquist frequency
+	w0 := 2 * math.Pi * cutoff / rate
+	cos := math.Cos(w0)
+	alpha := math.Sin(w0) / math.Sqrt2 // sin(w0) / 2Q with Butterworth Q = 1/sqrt(2)
+	a0 := 1 + alpha
+	f.b0 = (1 + cos) / 2 / a0
+	f.b1 = -(1 + cos) / a0
+	f.b2 = f.b0
+	f.a1 = -2 * cos / a0
+	f.a2 = (1 - alpha) / a0
+}
+
+const (
+	gateFloorDB = -40                    // How far a closed gate turns audio down
+	gateAttack  = 2 * time.Millisecond   // Opening, quick enough not to clip the first syllable
+	gateHold    = 200 * time.Millisecond // Stays open through short pauses between words
+	gateRelease = 150 * time.Millisecond // Closing
+)
+
+// NoiseGate turns down audio quieter than a threshold, such as the hiss and
+// hum of a quiet room between utterances. It doesn't mute entirely, so the
+// model still hears a natural background.
+type NoiseGate struct {
+	threshold float64
+	format    streamFormat
+
+	env       float64 // Peak envelope of the input
+	envDecay  float64
+	gain      float64
+	attack    float64
+	release   float64
+	hold      int // Samples the gate stays open for after the level falls
+	holdLeft  int
+	floorGain float64
+}
+
+// NewNoiseGate creates a gate that closes below thresholdDB dBFS.
+func NewNoiseGate(thresholdDB float64) *NoiseGate {
+	return &NoiseGate{threshold: dbToLinear(thresholdDB), gain: 1, floorGain: dbToLinear(gateFloorDB)}
+}
+
+func (f *NoiseGate) Name() string {
+	return fmt.Sprintf("%s %.0f dBFS", FilterGate, 20*math.Log10(f.threshold))
+}
+
+func (f *NoiseGate) Process(frame *types.AudioFrame) {
+	if f.format.changed(frame) {
+		step := time.Second / time.Duration(max(frame.SampleRate, 1))
+		f.envDecay = 1 - smoothing(20*time.Millisecond, step)
+		f.attack = smoothing(gateAttack, step)
+		f.release = smoothing(gateRelease, step)
+		f.hold = int(gateHold / step)
+	}
+	ch := f.format.channels
+	for i := 0; i+ch <= len(frame.Samples); i += ch {
+		// One gain for all channels, driven by the loudest
+		peak := 0.0
+		for _, v := range frame.Samples[i : i+ch] {
+			peak = max(peak, math.Abs(float64(v)))
+		}
+		f.env = max(peak, f.env*f.envDecay)
+
+		target, rate := f.floorGain, f.release
+		if f.env >= f.threshold {
+			f.holdLeft = f.hold
+		}
+		if f.holdLeft > 0 {
+			f.holdLeft--
+			target, rate = 1, f.attack
+		}
+		f.gain += (target - f.gain) * rate
+
+		for j := i; j < i+ch; j++ {
+			frame.Samples[j] = float32(float64(frame.Samples[j]) * f.gain)
+		}
+	}
+}
+
+const (
+	agcSilenceDB = -60                    // Frames quieter than this leave the gain alone
+	agcMinGainDB = -20                    // Most the AGC turns loud audio down
+	agcRise      = 2 * time.Second        // Gain increases slowly, so pauses don't pump noise up
+	agcFall      = 200 * time.Millisecond // and decreases quickly, so a loud voice doesn't clip
+)
+
+// AGC is an automatic gain control that brings speech to a steady level, for
+// microphones that are far too quiet or too hot for the model.
+type AGC struct {
+	target  float64
+	maxGain float64
+	minGain float64
+	gain    float64
+}
+
+// NewAGC creates an AGC aiming for an RMS level of targetDB dBFS, amplifying
+// by at most maxGainDB.
+func NewAGC(targetDB, maxGainDB float64) *AGC {
+	return &AGC{target: dbToLinear(targetDB), maxGain: dbToLinear(maxGainDB), minGain: dbToLinear(agcMinGainDB), gain: 1}
+}
+
+func (f *AGC) Name() string {
+	return fmt.Sprintf("%s %.0f dBFS", FilterAGC, 20*math.Log10(f.target))
+}
+
+// Gain returns the gain the AGC currently applies.
+func (f *AGC) Gain() float64 {
+	return f.gain
+}
+
+func (f *AGC) Process(frame *types.AudioFrame) {
+	if len(frame.Samples) == 0 {
+		return
+	}
+	from := f.gain
+	if level := dsp.RMS(frame.Samples); level > dbToLinear(agcSilenceDB) {
+		want := min(max(f.target/level, f.minGain), f.maxGain)
+		tau := agcRise
+		if want < f.gain {
+			tau = agcFall
+		}
+		f.gain += (want - f.gain) * smoothing(tau, frame.Duration())
+	}
+
+	// Ramp across the frame so gain changes don't click
+	n := float64(len(frame.Samples))
+	for i, v := range frame.Samples {
+		g := from + (f.gain-from)*float64(i+1)/n
+		frame.Samples[i] = clip(float64(v) * g)
+	}
+}
+
+// Gain multiplies the signal by a fixed amount, clipping what overflows.
+type Gain struct {
+	db, gain float64
+}
+
+// NewGain creates a fixed gain of db decibels.
+func NewGain(db float64) *Gain {
+	return &Gain{db: db, gain: dbToLinear(db)}
+}
+
+func (f *Gain) Name() string { return fmt.Sprintf("%s %+.1f dB", FilterGain, f.db) }
+
+func (f *Gain) Process(frame *types.AudioFrame) {
+	for i, v := range frame.Samples {
+		frame.Samples[i] = clip(float64(v) * f.gain)
+	}
+}
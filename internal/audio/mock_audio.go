@@ -8,7 +8,7 @@ import (
 	"sync"
 	"time"
 
-	// "livelylivecaptions/internal/types"
+	"livelylivecaptions/internal/types"
 )
 
 const (
@@ -30,6 +30,8 @@ type MockAudioDevice struct {
 	sampleRate  int
 	numChannels int
 	bitDepth    int
+	seq         uint64            // Sequence number of the next frame
+	pool        *types.SamplePool // Recycles the samples of released frames
 }
 
 // NewMockAudioDevice creates a new MockAudioDevice instance.
@@ -107,6 +109,11 @@ func (m *MockAudioDevice) ID() interface{} {
 	return m.id
 }
 
+// Info describes the mock device's audio.
+func (m *MockAudioDevice) Info() types.DeviceInfo {
+	return types.DeviceInfo{HostAPI: "mock", Index: -1, MaxChannels: m.numChannels, DefaultSampleRate: float64(m.sampleRate)}
+}
+
 // Start prepares the mock device for reading.
 func (m *MockAudioDevice) Start() error {
 	m.mu.Lock()
@@ -119,38 +126,65 @@ func (m *MockAudioDevice) Start() error {
 
 // Read reads a chunk of audio data from the pre-loaded WAV file.
 // It simulates real-time audio by introducing a delay.
-func (m *MockAudioDevice) Read() ([]byte, error) {
+func (m *MockAudioDevice) Read() (types.AudioFrame, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if m.closed {
-		return nil, os.ErrClosed
+		return types.AudioFrame{}, os.ErrClosed
 	}
 
 	// Calculate how many bytes correspond to MockChunkSize in the WAV file
 	bytesPerSample := m.bitDepth / 8
 	samplesPerChunk := MockChunkSize / bytesPerSample
+	if m.pool == nil {
+		m.pool = types.NewSamplePool(samplesPerChunk, 8)
+	}
+	samples := m.pool.Get()
 
 	// Ensure we don't read beyond the end of the data, looping if necessary
 	endPos := m.currentPos + samplesPerChunk*bytesPerSample
 	if endPos > len(m.audioData) {
 		// Loop back to the beginning if we've reached the end
-		remaining := len(m.audioData) - m.currentPos
-		chunk := make([]byte, samplesPerChunk*bytesPerSample)
-		copy(chunk, m.audioData[m.currentPos:])
-		copy(chunk[remaining:], m.audioData[0:samplesPerChunk*bytesPerSample-remaining])
-		m.currentPos = samplesPerChunk*bytesPerSample - remaining
-		return chunk, nil
+		n := int16ToFloat32(samples, m.audioData[m.currentPos:])
+		m.currentPos = (samplesPerChunk - n) * bytesPerSample
+		int16ToFloat32(samples[n:], m.audioData[:m.currentPos])
+		return m.frame(samples), nil
 	}
 
-	chunk := m.audioData[m.currentPos:endPos]
+	int16ToFloat32(samples, m.audioData[m.currentPos:endPos])
 	m.currentPos = endPos
 
 	// Simulate real-time delay
 	// Assuming 16kHz, 16-bit mono. MockChunkSize is 100ms of audio.
 	time.Sleep(100 * time.Millisecond)
 
-	return chunk, nil
+	return m.frame(samples), nil
+}
+
+// frame wraps pooled samples as the next frame, as if it had just finished
+// recording.
+func (m *MockAudioDevice) frame(samples []float32) types.AudioFrame {
+	f := types.AudioFrame{
+		Seq:        m.seq,
+		SampleRate: m.sampleRate,
+		Channels:   m.numChannels,
+		Samples:    samples,
+		Pool:       m.pool,
+	}
+	f.CaptureTime = time.Now().Add(-f.Duration())
+	m.seq++
+	return f
+}
+
+// int16ToFloat32 converts int16 LE bytes from src into dst and returns how
+// many samples it converted.
+func int16ToFloat32(dst []float32, src []byte) int {
+	n := min(len(dst), len(src)/2)
+	for i := 0; i < n; i++ {
+		dst[i] = float32(int16(binary.LittleEndian.Uint16(src[2*i:]))) / 32768.0
+	}
+	return n
 }
 
 // Close stops the mock device.
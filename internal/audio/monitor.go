@@ -0,0 +1,61 @@
+package audio
+
+import (
+	"bufio"
+	"strings"
+)
+
+// MonitorProvider lists monitor sources: inputs that carry what the machine is
+// playing, so calls and videos can be captioned rather than the microphone.
+// Each is captured by a recorder subprocess; see CommandDevice.
+type MonitorProvider struct{}
+
+// monitorRecorders are the recorder programs MonitorProvider can capture
+// with, in order of preference.
+var monitorRecorders = []string{"parec", "pw-record"}
+
+// pulseSource is a PulseAudio source, as PipeWire's pipewire-pulse reports too.
+type pulseSource struct {
+	Name        string
+	Description string
+}
+
+// monitorSink returns the name of the sink a monitor source listens to, and
+// whether the source is a monitor at all.
+func (s pulseSource) monitorSink() (string, bool) {
+	return strings.CutSuffix(s.Name, ".monitor")
+}
+
+// parsePulseSources reads the output of "pactl list sources" in the C locale.
+func parsePulseSources(output string) []pulseSource {
+	var sources []pulseSource
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "Source #"):
+			sources = append(sources, pulseSource{})
+		case len(sources) == 0:
+		case strings.HasPrefix(line, "Name: "):
+			sources[len(sources)-1].Name = strings.TrimPrefix(line, "Name: ")
+		case strings.HasPrefix(line, "Description: "):
+			sources[len(sources)-1].Description = strings.TrimPrefix(line, "Description: ")
+		}
+	}
+	return sources
+}
+
+// recorderArgs returns the command line that makes recorder write source as
+// captureSampleRate, captureChannels float32 samples to its standard output.
+func recorderArgs(recorder string, source pulseSource) []string {
+	switch recorder {
+	case "pw-record":
+		// PipeWire has no separate monitor node: record the sink itself.
+		sink, _ := source.monitorSink()
+		return []string{"pw-record", "--target", sink, "-P", "{ stream.capture.sink=true }",
+			"--format", "f32", "--rate", "16000", "--channels", "1", "-"}
+	default:
+		return []string{"parec", "--device=" + source.Name, "--raw",
+			"--format=float32le", "--rate=16000", "--channels=1", "--latency-msec=50"}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package audio
+
+import (
+	"fmt"
+	"livelylivecaptions/internal/types"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// GetDevices lists the monitor sources of PulseAudio, or of PipeWire through
+// pipewire-pulse, with the default output's monitor first.
+func (p MonitorProvider) GetDevices() ([]types.AudioDevice, error) {
+	recorder := ""
+	for _, r := range monitorRecorders {
+		if _, err := exec.LookPath(r); err == nil {
+			recorder = r
+			break
+		}
+	}
+	if recorder == "" {
+		return nil, fmt.Errorf("monitor mode needs %s to record system audio", strings.Join(monitorRecorders, " or "))
+	}
+
+	output, err := pactl("list", "sources")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PulseAudio/PipeWire sources: %w", err)
+	}
+	defaultSink, _ := pactl("get-default-sink")
+	defaultSink = strings.TrimSpace(defaultSink)
+
+	var devices []types.AudioDevice
+	for _, source := range parsePulseSources(output) {
+		sink, ok := source.monitorSink()
+		if !ok {
+			continue
+		}
+		name := source.Description
+		if name == "" {
+			name = source.Name
+		}
+		device := NewCommandDevice(name, source.Name, recorderArgs(recorder, source)...)
+		device.info.HostAPI = "PulseAudio monitor"
+		device.info.IsDefault = sink == defaultSink
+		if sink == defaultSink {
+			devices = append([]types.AudioDevice{device}, devices...)
+		} else {
+			devices = append(devices, device)
+		}
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no monitor sources found")
+	}
+	return devices, nil
+}
+
+// pactl runs pactl in the C locale, so its output can be parsed.
+func pactl(args ...string) (string, error) {
+	cmd := exec.Command("pactl", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return string(output), nil
+}
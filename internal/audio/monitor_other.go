@@ -0,0 +1,13 @@
+//go:build !linux
+
+package audio
+
+import (
+	"fmt"
+	"livelylivecaptions/internal/types"
+)
+
+// GetDevices reports that monitor capture is not available on this platform.
+func (p MonitorProvider) GetDevices() ([]types.AudioDevice, error) {
+	return nil, fmt.Errorf("monitor mode is only supported on Linux with PulseAudio or PipeWire")
+}
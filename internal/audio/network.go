@@ -0,0 +1,351 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/types"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// senderGapTolerance is how long a NetworkAudioDevice waits for a late
+// sender before filling the gap with silence.
+const senderGapTolerance = 300 * time.Millisecond
+
+// IsNetworkDeviceID reports whether a device ID names a network listener:
+// tcp://host:port, udp://host:port or ws://host:port/path.
+func IsNetworkDeviceID(id string) bool {
+	for _, scheme := range []string{"tcp://", "udp://", "ws://"} {
+		if strings.HasPrefix(id, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkAudioDevice listens for a remote sender of raw PCM: over a TCP
+// connection, as UDP datagrams, or as binary WebSocket messages. One sender is
+// heard at a time; a new connection replaces the previous one, so a sender
+// can reconnect at will. Once a sender has been heard, time it falls silent
+// for longer than senderGapTolerance is filled with silence, so captions keep
+// their timing and the last words are finalized.
+type NetworkAudioDevice struct {
+	id     string
+	scheme string // tcp, udp or ws
+	addr   string // Address to listen on
+	path   string // WebSocket endpoint
+	format PCMFormat
+
+	frames   *ringFrames
+	listener io.Closer
+	bound    net.Addr
+
+	mu         sync.Mutex // Guards sender, senderID, closed and pushing to frames
+	sender     io.Closer  // Current TCP or WebSocket connection
+	senderID   uint64     // Increases with every new sender
+	heardFrom  bool       // A sender has delivered audio
+	closed     bool
+	wg         sync.WaitGroup
+	nextDue    time.Time // When Read's next frame is due, for gap filling
+	gapStarted time.Time // Start of the current gap, if silence is being filled in
+}
+
+// NewNetworkAudioDevice creates a listener for the device ID id, which
+// IsNetworkDeviceID accepts, receiving audio in format.
+func NewNetworkAudioDevice(id string, format PCMFormat) (*NetworkAudioDevice, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network device %q: %w", id, err)
+	}
+	d := &NetworkAudioDevice{id: id, scheme: u.Scheme, addr: u.Host, path: u.Path, format: format}
+	switch d.scheme {
+	case "tcp", "udp":
+	case "ws":
+		if d.path == "" {
+			d.path = "/"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported network device scheme %q (want tcp, udp or ws)", u.Scheme)
+	}
+	if d.addr == "" {
+		return nil, fmt.Errorf("network device %q has no address to listen on", id)
+	}
+	return d, nil
+}
+
+func (d *NetworkAudioDevice) Name() string {
+	return fmt.Sprintf("%s listener on %s", strings.ToUpper(d.scheme), d.Addr())
+}
+
+func (d *NetworkAudioDevice) ID() interface{} {
+	return d.id
+}
+
+func (d *NetworkAudioDevice) Info() types.DeviceInfo {
+	return types.DeviceInfo{HostAPI: d.scheme, Index: -1, MaxChannels: d.format.Channels, DefaultSampleRate: float64(d.format.SampleRate)}
+}
+
+// Addr returns the address the device listens on, with the port it was
+// given once started.
+func (d *NetworkAudioDevice) Addr() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.bound != nil {
+		return d.bound.String()
+	}
+	return d.addr
+}
+
+func (d *NetworkAudioDevice) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.frames != nil {
+		return fmt.Errorf("%s already started", d.id)
+	}
+
+	d.frames = newRingFrames(d.id, d.format.SampleRate, d.format.Channels)
+	switch d.scheme {
+	case "udp":
+		conn, err := net.ListenPacket("udp", d.addr)
+		if err != nil {
+			return err
+		}
+		d.listener, d.bound = conn, conn.LocalAddr()
+		d.serve(func() { d.receiveUDP(conn) })
+	case "tcp":
+		ln, err := net.Listen("tcp", d.addr)
+		if err != nil {
+			return err
+		}
+		d.listener, d.bound = ln, ln.Addr()
+		d.serve(func() { d.acceptTCP(ln) })
+	case "ws":
+		ln, err := net.Listen("tcp", d.addr)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc(d.path, d.handleWebSocket)
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		d.listener, d.bound = server, ln.Addr()
+		d.serve(func() { server.Serve(ln) })
+	}
+
+	logger.Info("Waiting for %s audio (%s) on %s", strings.ToUpper(d.scheme), d.format, d.bound)
+	return nil
+}
+
+func (d *NetworkAudioDevice) serve(loop func()) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		loop()
+	}()
+}
+
+func (d *NetworkAudioDevice) acceptTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn("Accepting an audio sender on %s: %v", ln.Addr(), err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		id := d.attach(conn, conn.RemoteAddr())
+		d.serve(func() {
+			defer conn.Close()
+			dec := pcmDecoder{format: d.format}
+			buf := make([]byte, 16*1024)
+			for {
+				n, err := conn.Read(buf)
+				if n > 0 && !d.push(id, dec.decode(buf[:n])) {
+					return // Replaced by a newer sender
+				}
+				if err != nil {
+					d.detach(id, conn.RemoteAddr(), err)
+					return
+				}
+			}
+		})
+	}
+}
+
+func (d *NetworkAudioDevice) receiveUDP(conn net.PacketConn) {
+	var from string
+	var id uint64
+	dec := pcmDecoder{format: d.format}
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn("Receiving audio on %s: %v", conn.LocalAddr(), err)
+			continue
+		}
+		if addr.String() != from {
+			// Datagrams carry no connection, so a new address is a new sender.
+			from = addr.String()
+			id = d.attach(nil, addr)
+			dec = pcmDecoder{format: d.format}
+		}
+		d.push(id, dec.decode(buf[:n]))
+	}
+}
+
+// attach makes a new sender current, dropping the previous one, and returns
+// its ID for push.
+func (d *NetworkAudioDevice) attach(conn io.Closer, addr net.Addr) uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		if conn != nil {
+			conn.Close()
+		}
+		return 0
+	}
+	if d.sender != nil {
+		logger.Info("Audio sender %s replaces the previous one", addr)
+		d.sender.Close()
+	} else {
+		logger.Info("Audio sender connected from %s", addr)
+	}
+	d.sender = conn
+	d.senderID++
+	return d.senderID
+}
+
+// detach forgets sender id once its connection ends.
+func (d *NetworkAudioDevice) detach(id uint64, addr net.Addr, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id != d.senderID || d.closed {
+		return
+	}
+	d.sender = nil
+	if errors.Is(err, io.EOF) {
+		logger.Info("Audio sender %s disconnected", addr)
+	} else {
+		logger.Warn("Audio sender %s lost: %v", addr, err)
+	}
+}
+
+// push stores samples from sender id. It reports false if id is no longer
+// the current sender, whose audio is then ignored.
+func (d *NetworkAudioDevice) push(id uint64, samples []float32) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id != d.senderID || d.closed {
+		return false
+	}
+	if len(samples) > 0 {
+		d.heardFrom = true
+		d.frames.push(samples)
+	}
+	return true
+}
+
+// Read returns the next 100ms of audio received, silence if the sender has
+// fallen silent, or an empty frame if nothing is due yet. Its samples come
+// from a pool, so the last holder of the frame should call Release on it.
+func (d *NetworkAudioDevice) Read() (types.AudioFrame, error) {
+	d.mu.Lock()
+	frames, heardFrom := d.frames, d.heardFrom
+	d.mu.Unlock()
+	if frames == nil {
+		return types.AudioFrame{}, fmt.Errorf("%s not started", d.id)
+	}
+
+	// Catch up on a gap without waiting for audio that isn't coming.
+	if heardFrom && !d.nextDue.IsZero() && time.Since(d.nextDue) > senderGapTolerance {
+		return d.fillGap(frames), nil
+	}
+
+	frame, err := frames.read()
+	if err != nil {
+		return frame, err
+	}
+	if len(frame.Samples) > 0 {
+		if !d.gapStarted.IsZero() {
+			logger.Info("Audio resumed on %s after %s of silence", d.Name(), time.Since(d.gapStarted).Round(100*time.Millisecond))
+			d.gapStarted = time.Time{}
+		}
+		d.nextDue = time.Now().Add(frame.Duration())
+	}
+	return frame, nil
+}
+
+// fillGap returns a frame of silence in place of audio the sender didn't send.
+func (d *NetworkAudioDevice) fillGap(frames *ringFrames) types.AudioFrame {
+	if d.gapStarted.IsZero() {
+		d.gapStarted = d.nextDue
+		logger.Warn("No audio from the sender on %s for %s, filling in silence", d.Name(), senderGapTolerance)
+	}
+	frame := frames.silence()
+	d.nextDue = d.nextDue.Add(frame.Duration())
+	return frame
+}
+
+func (d *NetworkAudioDevice) Close() error {
+	d.mu.Lock()
+	if d.frames == nil || d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	if d.sender != nil {
+		d.sender.Close()
+	}
+	listener := d.listener
+	d.mu.Unlock()
+
+	err := listener.Close()
+	d.wg.Wait()
+	d.frames.stop(fmt.Errorf("%s closed", d.Name()))
+	logger.Info("Stopped listening for audio on %s", d.Addr())
+	return err
+}
+
+// pcmDecoder converts a byte stream of raw PCM into samples, carrying
+// incomplete samples over to the next call.
+type pcmDecoder struct {
+	format  PCMFormat
+	pending []byte
+	samples []float32
+}
+
+// decode returns the samples completed by p, which stay valid until the next call.
+func (dec *pcmDecoder) decode(p []byte) []float32 {
+	data := p
+	if len(dec.pending) > 0 {
+		dec.pending = append(dec.pending, p...)
+		data = dec.pending
+	}
+	width := dec.format.sampleWidth()
+	n := len(data) / width
+	if cap(dec.samples) < n {
+		dec.samples = make([]float32, n)
+	}
+	samples := dec.samples[:n]
+	if dec.format.Encoding == PCMF32LE {
+		for i := range samples {
+			samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+		}
+	} else {
+		int16ToFloat32(samples, data[:2*n])
+	}
+	dec.pending = append(dec.pending[:0], data[n*width:]...)
+	return samples
+}
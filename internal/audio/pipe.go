@@ -0,0 +1,268 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/types"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StdinDeviceID selects a PipeAudioDevice reading standard input.
+	StdinDeviceID = "-"
+	// PipeDevicePrefix marks a device ID naming a named pipe or file of raw
+	// PCM, as in "pipe:/tmp/audio.fifo".
+	PipeDevicePrefix = "pipe:"
+)
+
+// PCMEncoding is the sample encoding of raw PCM input.
+type PCMEncoding string
+
+const (
+	PCMS16LE PCMEncoding = "s16le" // Signed 16-bit little-endian integers
+	PCMF32LE PCMEncoding = "f32le" // 32-bit little-endian floats
+)
+
+// PCMFormat describes raw PCM input, which has no header to describe itself.
+type PCMFormat struct {
+	Encoding   PCMEncoding
+	SampleRate int
+	Channels   int
+}
+
+// ParsePCMFormat validates a raw PCM format from the configuration.
+func ParsePCMFormat(encoding string, sampleRate, channels int) (PCMFormat, error) {
+	f := PCMFormat{Encoding: PCMEncoding(encoding), SampleRate: sampleRate, Channels: channels}
+	switch f.Encoding {
+	case PCMS16LE, PCMF32LE:
+	case "":
+		f.Encoding = PCMS16LE
+	default:
+		return f, fmt.Errorf("unknown PCM encoding %q (want %s or %s)", encoding, PCMS16LE, PCMF32LE)
+	}
+	if f.SampleRate < 1000 || f.SampleRate > 384000 {
+		return f, fmt.Errorf("invalid PCM sample rate %d", f.SampleRate)
+	}
+	if f.Channels < 1 || f.Channels > 32 {
+		return f, fmt.Errorf("invalid PCM channel count %d", f.Channels)
+	}
+	return f, nil
+}
+
+func (f PCMFormat) String() string {
+	return fmt.Sprintf("%s %d Hz, %d channels", f.Encoding, f.SampleRate, f.Channels)
+}
+
+func (f PCMFormat) sampleWidth() int {
+	if f.Encoding == PCMF32LE {
+		return 4
+	}
+	return 2
+}
+
+// PipeDevicePath returns the file a device ID names for a PipeAudioDevice,
+// StdinDeviceID for standard input, and whether it names one at all.
+func PipeDevicePath(id string) (string, bool) {
+	if id == StdinDeviceID {
+		return id, true
+	}
+	return strings.CutPrefix(id, PipeDevicePrefix)
+}
+
+// pipeQueueFrames is how many frames a PipeAudioDevice reads ahead. When the
+// queue is full it stops reading, so the writer is held up rather than audio
+// dropped.
+const pipeQueueFrames = 20
+
+// PipeAudioDevice reads raw PCM from standard input or a named pipe, for
+// example from "ffmpeg -i input -f s16le -ac 1 -ar 16000 -". Frames keep the
+// input's rate and channels; the transcriber converts them. Once the input
+// ends Read returns io.EOF.
+type PipeAudioDevice struct {
+	path   string // StdinDeviceID or a file path
+	format PCMFormat
+
+	frames chan types.AudioFrame
+	quit   chan struct{}
+	err    error // Why reading stopped; set before frames is closed
+	input  io.ReadCloser
+	timer  *time.Timer // Read's timeout, reused between calls
+	mu     sync.Mutex  // Guards frames, input and closed
+	closed bool
+}
+
+// NewPipeAudioDevice creates a device reading format from path, which is
+// StdinDeviceID for standard input.
+func NewPipeAudioDevice(path string, format PCMFormat) *PipeAudioDevice {
+	return &PipeAudioDevice{path: path, format: format}
+}
+
+func (d *PipeAudioDevice) Name() string {
+	if d.path == StdinDeviceID {
+		return "standard input"
+	}
+	return d.path
+}
+
+func (d *PipeAudioDevice) ID() interface{} {
+	if d.path == StdinDeviceID {
+		return StdinDeviceID
+	}
+	return PipeDevicePrefix + d.path
+}
+
+func (d *PipeAudioDevice) Info() types.DeviceInfo {
+	return types.DeviceInfo{HostAPI: "pipe", Index: -1, MaxChannels: d.format.Channels, DefaultSampleRate: float64(d.format.SampleRate)}
+}
+
+// ReadsStdin reports whether the device consumes standard input, which then
+// can't be used for the keyboard.
+func (d *PipeAudioDevice) ReadsStdin() bool {
+	return d.path == StdinDeviceID
+}
+
+func (d *PipeAudioDevice) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.frames != nil {
+		return fmt.Errorf("%s already started", d.Name())
+	}
+	d.frames = make(chan types.AudioFrame, pipeQueueFrames)
+	d.quit = make(chan struct{})
+	d.closed = false
+
+	go func() {
+		defer close(d.frames)
+		d.err = d.capture()
+	}()
+
+	logger.Info("Reading raw PCM (%s) from %s", d.format, d.Name())
+	return nil
+}
+
+// capture opens the input and reads frames from it until it ends or the
+// device is closed. Opening a named pipe waits for a writer, so it happens
+// here rather than in Start.
+func (d *PipeAudioDevice) capture() error {
+	var input io.ReadCloser = io.NopCloser(os.Stdin)
+	if d.path != StdinDeviceID {
+		f, err := os.Open(d.path)
+		if err != nil {
+			return err
+		}
+		input = f
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		input.Close()
+		return io.ErrClosedPipe
+	}
+	d.input = input
+	d.mu.Unlock()
+	defer input.Close()
+
+	frameLen := d.format.SampleRate / 10 * d.format.Channels // 100ms
+	pool := types.NewSamplePool(frameLen, framePoolSize)
+	buf := make([]byte, frameLen*d.format.sampleWidth())
+	for seq := uint64(0); ; seq++ {
+		n, err := io.ReadFull(input, buf)
+		samples := n / d.format.sampleWidth() / d.format.Channels * d.format.Channels
+		if samples > 0 {
+			frame := types.AudioFrame{
+				Seq: seq,
+				// The last sample was read just now.
+				CaptureTime: time.Now().Add(-time.Duration(samples/d.format.Channels) * time.Second / time.Duration(d.format.SampleRate)),
+				SampleRate:  d.format.SampleRate,
+				Channels:    d.format.Channels,
+				Samples:     pool.Get()[:samples],
+				Pool:        pool,
+			}
+			d.decode(frame.Samples, buf)
+			select {
+			case d.frames <- frame:
+			case <-d.quit:
+				frame.Release()
+				return io.ErrClosedPipe
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return io.EOF
+		}
+		if err != nil {
+			select {
+			case <-d.quit:
+				return io.ErrClosedPipe
+			default:
+				return fmt.Errorf("reading %s: %w", d.Name(), err)
+			}
+		}
+	}
+}
+
+// decode converts raw samples in src to dst.
+func (d *PipeAudioDevice) decode(dst []float32, src []byte) {
+	switch d.format.Encoding {
+	case PCMF32LE:
+		for i := range dst {
+			dst[i] = math.Float32frombits(binary.LittleEndian.Uint32(src[4*i:]))
+		}
+	default:
+		int16ToFloat32(dst, src[:2*len(dst)])
+	}
+}
+
+// Read returns the next 100ms of input, or an empty frame if none arrived in
+// time. Its samples come from a pool, so the last holder of the frame should
+// call Release on it.
+func (d *PipeAudioDevice) Read() (types.AudioFrame, error) {
+	d.mu.Lock()
+	frames := d.frames
+	d.mu.Unlock()
+	if frames == nil {
+		return types.AudioFrame{}, fmt.Errorf("%s not started", d.Name())
+	}
+
+	if d.timer == nil {
+		d.timer = time.NewTimer(readTimeout)
+	} else {
+		d.timer.Reset(readTimeout)
+	}
+	defer d.timer.Stop()
+
+	select {
+	case frame, ok := <-frames:
+		if !ok {
+			return types.AudioFrame{}, d.err
+		}
+		return frame, nil
+	case <-d.timer.C:
+		return types.AudioFrame{}, nil // Indicate no data yet, but still reading
+	}
+}
+
+// Close stops reading. It doesn't wait for a read in progress: reads of
+// standard input, or an open still waiting for a pipe's writer, can't be
+// interrupted, and they finish on their own once data or a writer arrives.
+func (d *PipeAudioDevice) Close() error {
+	d.mu.Lock()
+	if d.frames == nil || d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	close(d.quit)
+	if d.input != nil {
+		// Unblocks a read in progress where the platform allows it.
+		d.input.Close()
+	}
+	d.mu.Unlock()
+	return nil
+}
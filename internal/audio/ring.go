@@ -0,0 +1,192 @@
+package audio
+
+import (
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/types"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sampleRing is a fixed-size ring buffer of samples for exactly one writer and
+// one reader, such as PortAudio's callback thread and Read. Neither side locks
+// or allocates, so the callback can never be held up by the reader.
+type sampleRing struct {
+	buf  []float32
+	mask uint64
+
+	head    atomic.Uint64 // Total samples written; only the writer stores it
+	tail    atomic.Uint64 // Total samples read; only the reader stores it
+	dropped atomic.Uint64 // Samples discarded because the ring was full
+}
+
+// newSampleRing creates a ring holding at least size samples.
+func newSampleRing(size int) *sampleRing {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return &sampleRing{buf: make([]float32, n), mask: uint64(n - 1)}
+}
+
+// write appends samples, or drops all of them if they don't fit, so the reader
+// never sees a block of audio with a hole in it. It reports whether they fit.
+func (r *sampleRing) write(samples []float32) bool {
+	head := r.head.Load()
+	if int(head-r.tail.Load())+len(samples) > len(r.buf) {
+		r.dropped.Add(uint64(len(samples)))
+		return false
+	}
+	start := int(head & r.mask)
+	n := copy(r.buf[start:], samples)
+	copy(r.buf, samples[n:])
+	r.head.Store(head + uint64(len(samples)))
+	return true
+}
+
+// buffered returns how many samples are waiting to be read.
+func (r *sampleRing) buffered() int {
+	return int(r.head.Load() - r.tail.Load())
+}
+
+// readFull fills dst if enough samples are buffered and reports whether it did.
+func (r *sampleRing) readFull(dst []float32) bool {
+	tail := r.tail.Load()
+	if int(r.head.Load()-tail) < len(dst) {
+		return false
+	}
+	start := int(tail & r.mask)
+	n := copy(dst, r.buf[start:])
+	copy(dst[n:], r.buf)
+	r.tail.Store(tail + uint64(len(dst)))
+	return true
+}
+
+// ringFrames turns audio a capture thread pushes into a sampleRing into
+// sequenced, pooled 100ms frames for Read.
+type ringFrames struct {
+	name     string // Device name for logs
+	rate     int
+	channels int // Samples pushed are interleaved
+	frameLen int // Samples per frame, across all channels
+	ring     *sampleRing
+	pool     *types.SamplePool
+	ready    chan struct{} // Poked by push when Read may have a frame
+	timer    *time.Timer   // Read's timeout, reused between calls
+
+	seq         uint64 // Sequence number of the next frame; only used by read
+	droppedSeen uint64 // Dropped samples already accounted for in seq
+
+	stopped  chan struct{} // Closed by stop
+	stopErr  error         // What read returns once stopped and drained
+	stopOnce sync.Once
+}
+
+func newRingFrames(name string, rate, channels int) *ringFrames {
+	frameLen := rate / 10 * channels
+	return &ringFrames{
+		name:     name,
+		rate:     rate,
+		channels: channels,
+		frameLen: frameLen,
+		ring:     newSampleRing(ringSeconds * rate * channels),
+		pool:     types.NewSamplePool(frameLen, framePoolSize),
+		ready:    make(chan struct{}, 1),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// push stores samples from the capture thread. A full ring drops them rather
+// than hold that thread up; read notices from the ring's drop count and skips
+// sequence numbers.
+func (r *ringFrames) push(samples []float32) {
+	if r.ring.write(samples) {
+		select {
+		case r.ready <- struct{}{}:
+		default: // Read has already been told
+		}
+	}
+}
+
+// stop ends capture. read still returns the complete frames left in the ring,
+// then err.
+func (r *ringFrames) stop(err error) {
+	r.stopOnce.Do(func() {
+		r.stopErr = err
+		close(r.stopped)
+	})
+}
+
+// read returns the next frame, or an empty frame if none arrived within
+// readTimeout.
+func (r *ringFrames) read() (types.AudioFrame, error) {
+	if r.timer == nil {
+		r.timer = time.NewTimer(readTimeout)
+	} else {
+		r.timer.Reset(readTimeout)
+	}
+	defer r.timer.Stop()
+
+	for {
+		if frame, ok := r.next(); ok {
+			return frame, nil
+		}
+		select {
+		case <-r.stopped:
+			return types.AudioFrame{}, r.stopErr
+		case <-r.ready:
+		case <-r.timer.C:
+			return types.AudioFrame{}, nil // Indicate no data yet, but still capturing
+		}
+	}
+}
+
+// next takes one frame's worth of samples from the ring, if it holds that many.
+func (r *ringFrames) next() (types.AudioFrame, bool) {
+	frameLen := r.frameLen
+	buffered := r.ring.buffered()
+	if buffered < frameLen {
+		return types.AudioFrame{}, false
+	}
+
+	// Skip the sequence numbers of frames the capture thread couldn't store,
+	// so consumers can tell audio was lost.
+	if dropped := r.ring.dropped.Load(); dropped > r.droppedSeen {
+		lost := (dropped - r.droppedSeen + uint64(frameLen) - 1) / uint64(frameLen)
+		logger.Warn("Capture buffer full on %s: dropped %d samples (about %d frames)", r.name, dropped-r.droppedSeen, lost)
+		r.seq += lost
+		r.droppedSeen = dropped
+	}
+
+	samples := r.pool.Get()
+	r.ring.readFull(samples)
+	frame := types.AudioFrame{
+		Seq: r.seq,
+		// The newest buffered sample was captured just now, and this frame
+		// starts with the oldest.
+		CaptureTime: time.Now().Add(-time.Duration(buffered/r.channels) * time.Second / time.Duration(r.rate)),
+		SampleRate:  r.rate,
+		Channels:    r.channels,
+		Samples:     samples,
+		Pool:        r.pool,
+	}
+	r.seq++
+	return frame, true
+}
+
+// silence returns a frame of silence that takes the next sequence number, to
+// stand in for audio that never arrived.
+func (r *ringFrames) silence() types.AudioFrame {
+	samples := r.pool.Get()
+	clear(samples)
+	frame := types.AudioFrame{
+		Seq:         r.seq,
+		CaptureTime: time.Now().Add(-100 * time.Millisecond),
+		SampleRate:  r.rate,
+		Channels:    r.channels,
+		Samples:     samples,
+		Pool:        r.pool,
+	}
+	r.seq++
+	return frame
+}
@@ -0,0 +1,643 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/types"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RTPDevicePrefix marks a device ID naming a UDP address to receive RTP on,
+// as in "rtp://:5004".
+const RTPDevicePrefix = "rtp://"
+
+// Static RTP payload types (RFC 3551) an RTPAudioDevice understands.
+const (
+	rtpPayloadPCMU      = 0  // G.711 µ-law, 8 kHz mono
+	rtpPayloadPCMA      = 8  // G.711 A-law, 8 kHz mono
+	rtpPayloadL16Stereo = 10 // 16-bit big-endian PCM, 44.1 kHz stereo
+	rtpPayloadL16Mono   = 11 // 16-bit big-endian PCM, 44.1 kHz mono
+)
+
+const (
+	// DefaultRTPJitterDelay is how long a missing packet is waited for, once
+	// later ones have arrived, before it is given up as lost.
+	DefaultRTPJitterDelay = 60 * time.Millisecond
+	// rtpMaxGap is the longest stretch of missing audio, in seconds, that is
+	// filled in; a longer jump in timestamps means the sender started over.
+	rtpMaxGap = 1
+	// rtpConcealPackets is how many lost packets in a row are concealed by
+	// repeating the last one, fading out, before falling back to silence.
+	rtpConcealPackets = 3
+	// rtpMaxBuffered bounds the packets held waiting for a missing one.
+	rtpMaxBuffered = 256
+)
+
+// RTPConfig describes the audio an RTPAudioDevice accepts.
+type RTPConfig struct {
+	// L16PayloadType is the dynamic payload type (96-127) carrying L16 audio
+	// at L16Rate with L16Channels, as negotiated in SDP with "L16/<rate>". Zero
+	// accepts only the static payload types.
+	L16PayloadType int
+	L16Rate        int
+	L16Channels    int
+	// JitterDelay is how long a missing packet is waited for; 0 means
+	// DefaultRTPJitterDelay.
+	JitterDelay time.Duration
+	// SampleRate is the rate frames are resampled to, normally the rate the
+	// model hears. Zero keeps the stream's rate.
+	SampleRate int
+}
+
+// RTPDevicePath returns the address a device ID names for an RTPAudioDevice
+// and whether it names one at all.
+func RTPDevicePath(id string) (string, bool) {
+	return strings.CutPrefix(id, RTPDevicePrefix)
+}
+
+// RTPAudioDevice receives an RTP stream of L16, PCMU or PCMA audio over UDP,
+// for example a SIP call forked off a PBX. Packets go through a jitter buffer
+// that puts reordered packets back in order and conceals lost ones. Frames
+// are resampled to RTPConfig.SampleRate, so filters, the segmenter and every
+// engine see the call as they would any other device; an 8 kHz telephony
+// model sets that to 8000 and hears G.711 unchanged.
+//
+// One stream is heard at a time. Another sender (SSRC) takes over once the
+// current one has been quiet for senderGapTolerance, and silence is filled in
+// while no stream plays.
+type RTPAudioDevice struct {
+	addr string
+	cfg  RTPConfig
+
+	conn  net.PacketConn
+	wake  chan struct{} // Poked when a packet arrives
+	timer *time.Timer   // Read's timeout, reused between calls
+	wg    sync.WaitGroup
+
+	mu          sync.Mutex // Guards everything below
+	jitter      *jitterBuffer
+	ssrc        uint32
+	format      rtpFormat // Format of the current stream
+	lastArrival time.Time
+	resamplers  []*resampler // One per channel, from the stream's rate to the frames'
+	channel     []float32    // One channel of played-out audio, for its resampler
+	converted   [][]float32  // Each channel resampled
+	pending     []float32    // Samples played out and resampled but not yet returned in a frame
+	pool        *types.SamplePool
+	seq         uint64    // Sequence number of the next frame
+	nextDue     time.Time // When the next frame is due, for filling in silence
+	gapStarted  time.Time
+	received    uint64
+	ignored     uint64 // Packets of other senders or unknown payload types
+	closed      bool
+}
+
+// rtpFormat is the audio an RTP payload type carries.
+type rtpFormat struct {
+	rate, channels int
+	decode         func(dst []float32, payload []byte) []float32
+}
+
+// NewRTPAudioDevice creates a device receiving RTP on the UDP address addr.
+func NewRTPAudioDevice(addr string, cfg RTPConfig) *RTPAudioDevice {
+	if cfg.JitterDelay <= 0 {
+		cfg.JitterDelay = DefaultRTPJitterDelay
+	}
+	return &RTPAudioDevice{addr: addr, cfg: cfg}
+}
+
+func (d *RTPAudioDevice) Name() string {
+	return "RTP receiver on " + d.Addr()
+}
+
+func (d *RTPAudioDevice) ID() interface{} {
+	return RTPDevicePrefix + d.addr
+}
+
+// Info describes the audio as Read returns it. Channels, and the rate if
+// frames keep the stream's, are 0 until a stream arrives.
+func (d *RTPAudioDevice) Info() types.DeviceInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return types.DeviceInfo{HostAPI: "rtp", Index: -1, MaxChannels: d.format.channels, DefaultSampleRate: float64(d.frameRate(d.format.rate)),
+		DefaultLowLatency: d.cfg.JitterDelay, DefaultHighLatency: d.cfg.JitterDelay}
+}
+
+// Addr returns the address the device listens on, with the port it was
+// given once started.
+func (d *RTPAudioDevice) Addr() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		return d.conn.LocalAddr().String()
+	}
+	return d.addr
+}
+
+func (d *RTPAudioDevice) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		return fmt.Errorf("%s already started", RTPDevicePrefix+d.addr)
+	}
+	conn, err := net.ListenPacket("udp", d.addr)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	d.wake = make(chan struct{}, 1)
+	d.jitter = nil
+	d.closed = false
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.receive(conn)
+	}()
+	logger.Info("Waiting for RTP audio on %s", conn.LocalAddr())
+	return nil
+}
+
+func (d *RTPAudioDevice) receive(conn net.PacketConn) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn("Receiving RTP on %s: %v", conn.LocalAddr(), err)
+			continue
+		}
+		p, err := parseRTP(buf[:n])
+		if err != nil {
+			logger.Debug("Ignoring packet from %s: %v", from, err)
+			continue
+		}
+		d.put(p, from, time.Now())
+	}
+}
+
+// put hands a received packet to the jitter buffer of its stream.
+func (d *RTPAudioDevice) put(p rtpPacket, from net.Addr, now time.Time) {
+	format, ok := d.payloadFormat(p.payloadType)
+	if !ok {
+		// Comfort noise, DTMF and the like: the gap in timestamps they leave
+		// is filled with silence.
+		d.mu.Lock()
+		d.ignored++
+		d.mu.Unlock()
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	switch {
+	case d.jitter == nil || p.ssrc != d.ssrc && now.Sub(d.lastArrival) > senderGapTolerance:
+		if d.jitter == nil {
+			logger.Info("RTP stream %08x from %s: %d Hz, %d channels", p.ssrc, from, format.rate, format.channels)
+		} else {
+			logger.Info("RTP stream %08x from %s replaces %08x", p.ssrc, from, d.ssrc)
+		}
+		d.ssrc = p.ssrc
+		d.startStream(format)
+	case p.ssrc != d.ssrc:
+		d.ignored++
+		return
+	case format.rate != d.format.rate || format.channels != d.format.channels:
+		logger.Warn("RTP stream %08x switched to %d Hz, %d channels", p.ssrc, format.rate, format.channels)
+		d.startStream(format)
+	}
+
+	d.received++
+	d.lastArrival = now
+	d.jitter.put(p.seq, p.timestamp, format.decode(nil, p.payload), now)
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startStream resets playout for a stream in format. Audio already played
+// out but not returned is kept if the format allows.
+func (d *RTPAudioDevice) startStream(format rtpFormat) {
+	if d.jitter == nil || format.rate != d.format.rate || format.channels != d.format.channels {
+		d.pending = d.pending[:0]
+		d.pool = types.NewSamplePool(d.frameRate(format.rate)/10*format.channels, framePoolSize)
+		d.resamplers = make([]*resampler, format.channels)
+		for c := range d.resamplers {
+			d.resamplers[c] = newResampler(format.rate, d.frameRate(format.rate))
+		}
+		d.converted = make([][]float32, format.channels)
+	}
+	d.format = format
+	d.jitter = newJitterBuffer(d.cfg.JitterDelay, format.rate, format.channels)
+}
+
+// frameRate returns the rate of frames for a stream at rate.
+func (d *RTPAudioDevice) frameRate(rate int) int {
+	if d.cfg.SampleRate > 0 {
+		return d.cfg.SampleRate
+	}
+	return rate
+}
+
+// convert resamples interleaved samples played out of the jitter buffer to
+// the frames' rate and adds them to pending.
+func (d *RTPAudioDevice) convert(samples []float32) {
+	channels := d.format.channels
+	if channels == 1 {
+		d.pending = d.resamplers[0].process(d.pending, samples, false)
+		return
+	}
+	for c, r := range d.resamplers {
+		d.channel = d.channel[:0]
+		for i := c; i < len(samples); i += channels {
+			d.channel = append(d.channel, samples[i])
+		}
+		d.converted[c] = r.process(d.converted[c][:0], d.channel, false)
+	}
+	// Every channel got the same number of samples, so they produce the same.
+	for i := range d.converted[0] {
+		for c := range channels {
+			d.pending = append(d.pending, d.converted[c][i])
+		}
+	}
+}
+
+// payloadFormat returns how to decode payload type pt.
+func (d *RTPAudioDevice) payloadFormat(pt uint8) (rtpFormat, bool) {
+	switch {
+	case pt == rtpPayloadPCMU:
+		return rtpFormat{8000, 1, decodePCMU}, true
+	case pt == rtpPayloadPCMA:
+		return rtpFormat{8000, 1, decodePCMA}, true
+	case pt == rtpPayloadL16Mono:
+		return rtpFormat{44100, 1, decodeL16}, true
+	case pt == rtpPayloadL16Stereo:
+		return rtpFormat{44100, 2, decodeL16}, true
+	case d.cfg.L16PayloadType != 0 && int(pt) == d.cfg.L16PayloadType:
+		return rtpFormat{d.cfg.L16Rate, d.cfg.L16Channels, decodeL16}, true
+	}
+	return rtpFormat{}, false
+}
+
+// Read returns the next 100ms of the stream, silence if no stream is
+// playing, or an empty frame if nothing is due yet. Its samples come from a
+// pool, so the last holder of the frame should call Release on it.
+func (d *RTPAudioDevice) Read() (types.AudioFrame, error) {
+	if d.timer == nil {
+		d.timer = time.NewTimer(readTimeout)
+	} else {
+		d.timer.Reset(readTimeout)
+	}
+	defer d.timer.Stop()
+
+	for {
+		frame, ok, err := d.nextFrame(time.Now())
+		if ok || err != nil {
+			return frame, err
+		}
+		// A lost packet is given up after JitterDelay even if nothing else
+		// arrives, so check again by then.
+		retry := time.NewTimer(d.cfg.JitterDelay / 2)
+		select {
+		case <-d.wake:
+			retry.Stop()
+		case <-retry.C:
+		case <-d.timer.C:
+			retry.Stop()
+			return types.AudioFrame{}, nil // Indicate no data yet, but still receiving
+		}
+	}
+}
+
+// nextFrame plays out the jitter buffer and returns a frame once it has one.
+func (d *RTPAudioDevice) nextFrame(now time.Time) (types.AudioFrame, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || d.conn == nil {
+		return types.AudioFrame{}, false, fmt.Errorf("%s not started", RTPDevicePrefix+d.addr)
+	}
+	if d.jitter == nil {
+		return types.AudioFrame{}, false, nil // No stream yet
+	}
+
+	rate := d.frameRate(d.format.rate)
+	frameLen := rate / 10 * d.format.channels
+	for len(d.pending) < frameLen {
+		samples, ok := d.jitter.pull(now)
+		if !ok {
+			break
+		}
+		d.convert(samples)
+	}
+
+	if len(d.pending) < frameLen {
+		// Fill in silence while the sender is quiet, at the pace audio would
+		// have arrived.
+		if d.jitter.buffered() > 0 || d.nextDue.IsZero() || now.Sub(d.nextDue) <= senderGapTolerance {
+			return types.AudioFrame{}, false, nil
+		}
+		if d.gapStarted.IsZero() {
+			d.gapStarted = d.nextDue
+			logger.Warn("No RTP audio on %s for %s, filling in silence", d.conn.LocalAddr(), senderGapTolerance)
+		}
+		for len(d.pending) < frameLen {
+			// Silence goes through the resampler too, so the audio around it
+			// stays in step.
+			missing := max(1, (frameLen-len(d.pending))/d.format.channels*d.format.rate/rate)
+			d.convert(make([]float32, missing*d.format.channels))
+			d.jitter.skip(missing)
+		}
+	} else if !d.gapStarted.IsZero() {
+		logger.Info("RTP audio resumed on %s after %s", d.conn.LocalAddr(), now.Sub(d.gapStarted).Round(100*time.Millisecond))
+		d.gapStarted = time.Time{}
+	}
+
+	samples := d.pool.Get()
+	n := copy(samples, d.pending[:frameLen])
+	d.pending = append(d.pending[:0], d.pending[frameLen:]...)
+	frame := types.AudioFrame{
+		Seq:        d.seq,
+		SampleRate: rate,
+		Channels:   d.format.channels,
+		Samples:    samples[:n],
+		Pool:       d.pool,
+	}
+	// The last sample arrived about a jitter delay ago.
+	frame.CaptureTime = now.Add(-d.cfg.JitterDelay - frame.Duration())
+	d.seq++
+	if d.nextDue.IsZero() || d.gapStarted.IsZero() {
+		d.nextDue = now.Add(frame.Duration())
+	} else {
+		d.nextDue = d.nextDue.Add(frame.Duration())
+	}
+	return frame, true, nil
+}
+
+func (d *RTPAudioDevice) Close() error {
+	d.mu.Lock()
+	if d.conn == nil || d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	conn := d.conn
+	var stats jitterStats
+	if d.jitter != nil {
+		stats = d.jitter.stats
+	}
+	received, ignored := d.received, d.ignored
+	d.mu.Unlock()
+
+	err := conn.Close()
+	d.wg.Wait()
+	logger.Info("Stopped receiving RTP on %s: %d packets, %d lost, %d late, %d ignored",
+		conn.LocalAddr(), received, stats.lost, stats.late, ignored)
+	return err
+}
+
+// rtpPacket is the part of an RTP packet (RFC 3550) the device uses.
+type rtpPacket struct {
+	payloadType uint8
+	seq         uint16
+	timestamp   uint32
+	ssrc        uint32
+	payload     []byte
+}
+
+// parseRTP parses an RTP packet, skipping CSRCs, header extensions and padding.
+func parseRTP(b []byte) (rtpPacket, error) {
+	if len(b) < 12 {
+		return rtpPacket{}, fmt.Errorf("RTP packet too short: %d bytes", len(b))
+	}
+	if version := b[0] >> 6; version != 2 {
+		return rtpPacket{}, fmt.Errorf("not RTP version 2: version %d", version)
+	}
+	p := rtpPacket{
+		payloadType: b[1] & 0x7F,
+		seq:         binary.BigEndian.Uint16(b[2:4]),
+		timestamp:   binary.BigEndian.Uint32(b[4:8]),
+		ssrc:        binary.BigEndian.Uint32(b[8:12]),
+	}
+	if p.payloadType >= 72 && p.payloadType <= 76 {
+		return rtpPacket{}, fmt.Errorf("RTCP packet")
+	}
+	end := len(b)
+	if b[0]&0x20 != 0 {
+		// The last byte counts the padding, itself included.
+		end -= int(b[end-1])
+	}
+	offset := 12 + 4*int(b[0]&0x0F)
+	if b[0]&0x10 != 0 && offset+4 <= end {
+		offset += 4 + 4*int(binary.BigEndian.Uint16(b[offset+2:]))
+	}
+	if offset > end {
+		return rtpPacket{}, fmt.Errorf("malformed RTP header")
+	}
+	p.payload = b[offset:end]
+	return p, nil
+}
+
+// G.711 lookup tables, built once.
+var pcmuTable, pcmaTable = g711Tables()
+
+func g711Tables() (mu, a [256]float32) {
+	for i := range 256 {
+		// µ-law: inverted bits, then sign, 3-bit exponent, 4-bit mantissa.
+		u := ^byte(i)
+		t := (int(u&0x0F)<<3 + 0x84) << ((u & 0x70) >> 4)
+		if u&0x80 != 0 {
+			mu[i] = float32(0x84-t) / 32768
+		} else {
+			mu[i] = float32(t-0x84) / 32768
+		}
+
+		// A-law: even bits inverted, a set sign bit means positive.
+		v := byte(i) ^ 0x55
+		m := int(v&0x0F)<<4 + 8
+		if seg := (v & 0x70) >> 4; seg > 0 {
+			m = (m + 0x100) << (seg - 1)
+		}
+		if v&0x80 != 0 {
+			a[i] = float32(m) / 32768
+		} else {
+			a[i] = float32(-m) / 32768
+		}
+	}
+	return mu, a
+}
+
+func decodePCMU(dst []float32, payload []byte) []float32 {
+	for _, b := range payload {
+		dst = append(dst, pcmuTable[b])
+	}
+	return dst
+}
+
+func decodePCMA(dst []float32, payload []byte) []float32 {
+	for _, b := range payload {
+		dst = append(dst, pcmaTable[b])
+	}
+	return dst
+}
+
+// decodeL16 decodes 16-bit PCM, which RTP carries in network byte order.
+func decodeL16(dst []float32, payload []byte) []float32 {
+	for i := 0; i+1 < len(payload); i += 2 {
+		dst = append(dst, float32(int16(binary.BigEndian.Uint16(payload[i:])))/32768)
+	}
+	return dst
+}
+
+// jitterStats counts what the jitter buffer had to repair.
+type jitterStats struct {
+	lost uint64 // Packets never received
+	late uint64 // Packets that arrived after they were given up
+}
+
+// jitterBuffer puts one RTP stream's packets back in sequence order and plays
+// them out, concealing the ones that don't arrive within delay of a later one.
+type jitterBuffer struct {
+	delay    time.Duration
+	rate     int
+	channels int
+
+	packets   map[uint16]jitterPacket
+	nextSeq   uint16
+	nextTS    uint32    // Timestamp the next played sample should have
+	started   bool      // A packet has arrived
+	playing   bool      // A packet has been played
+	last      []float32 // Last packet played, repeated to conceal a loss
+	concealed int       // Lost packets concealed in a row
+	stats     jitterStats
+}
+
+type jitterPacket struct {
+	timestamp uint32
+	samples   []float32
+	arrived   time.Time
+}
+
+func newJitterBuffer(delay time.Duration, rate, channels int) *jitterBuffer {
+	return &jitterBuffer{delay: delay, rate: rate, channels: channels, packets: make(map[uint16]jitterPacket)}
+}
+
+// seqAfter returns how many packets seq is after the next one to play, which
+// is negative for packets already played or given up.
+func (j *jitterBuffer) seqAfter(seq uint16) int {
+	return int(int16(seq - j.nextSeq))
+}
+
+// put stores a packet that arrived at now.
+func (j *jitterBuffer) put(seq uint16, ts uint32, samples []float32, now time.Time) {
+	if !j.started {
+		j.started = true
+		j.nextSeq, j.nextTS = seq, ts
+	}
+	switch after := j.seqAfter(seq); {
+	case after < 0 && !j.playing:
+		// Reordered before playout began: start from this packet instead.
+		j.nextSeq, j.nextTS = seq, ts
+	case after < 0:
+		j.stats.late++
+		return
+	case after >= rtpMaxBuffered:
+		// Too far ahead to be reordering: the sender jumped, so start over.
+		clear(j.packets)
+		j.nextSeq, j.nextTS = seq, ts
+	}
+	if _, dup := j.packets[seq]; !dup {
+		j.packets[seq] = jitterPacket{timestamp: ts, samples: samples, arrived: now}
+	}
+}
+
+// buffered returns how many packets are waiting to be played.
+func (j *jitterBuffer) buffered() int {
+	return len(j.packets)
+}
+
+// skip accounts for frames of silence played in place of the stream, so the
+// pause isn't filled in again when the stream resumes.
+func (j *jitterBuffer) skip(frames int) {
+	j.nextTS += uint32(frames)
+}
+
+// pull returns the next samples to play: a packet, silence for a pause in
+// the stream, or concealment for lost packets. It reports false if the next
+// packet may still arrive.
+func (j *jitterBuffer) pull(now time.Time) ([]float32, bool) {
+	if !j.playing {
+		// Hold the first packet for the jitter delay, so packets sent
+		// before it can still arrive.
+		if p, ok := j.packets[j.nextSeq]; !ok || now.Sub(p.arrived) < j.delay {
+			return nil, false
+		}
+		j.playing = true
+	}
+	if p, ok := j.packets[j.nextSeq]; ok {
+		// A timestamp ahead of the sequence means the sender paused
+		// (discontinuous transmission); play the pause as silence first.
+		if gap := int32(p.timestamp - j.nextTS); gap > 0 && int(gap) <= rtpMaxGap*j.rate {
+			j.nextTS = p.timestamp
+			return make([]float32, int(gap)*j.channels), true
+		}
+		delete(j.packets, j.nextSeq)
+		j.nextSeq++
+		j.nextTS = p.timestamp + uint32(len(p.samples)/j.channels)
+		j.last, j.concealed = p.samples, 0
+		return p.samples, true
+	}
+
+	// The next packet is missing. Wait for it until the earliest later
+	// packet has been held for the jitter delay.
+	var first uint16
+	var earliest jitterPacket
+	found := false
+	for seq, p := range j.packets {
+		if !found || j.seqAfter(seq) < j.seqAfter(first) {
+			first, earliest, found = seq, p, true
+		}
+	}
+	if !found || now.Sub(earliest.arrived) < j.delay {
+		return nil, false
+	}
+
+	lost := j.seqAfter(first)
+	j.stats.lost += uint64(lost)
+	j.nextSeq = first
+	gap := int32(earliest.timestamp - j.nextTS)
+	if gap <= 0 || int(gap) > rtpMaxGap*j.rate {
+		// No sensible duration to fill; carry on with the later packet.
+		j.nextTS = earliest.timestamp
+		return nil, true
+	}
+	j.nextTS = earliest.timestamp
+	return j.conceal(int(gap)*j.channels, lost), true
+}
+
+// conceal returns n samples standing in for lost packets: the last packet
+// repeated at falling volume, then silence.
+func (j *jitterBuffer) conceal(n, lost int) []float32 {
+	out := make([]float32, n)
+	if len(j.last) > 0 {
+		per := n / lost
+		for p := 0; p < lost && j.concealed < rtpConcealPackets; p++ {
+			j.concealed++
+			gain := 1 - float32(j.concealed)/float32(rtpConcealPackets+1)
+			for i := p * per; i < (p+1)*per && i < n; i++ {
+				out[i] = j.last[(i-p*per)%len(j.last)] * gain
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/types"
+	"regexp"
+	"strings"
+)
+
+// DefaultDevicePattern selects the system's default input device.
+const DefaultDevicePattern = "default"
+
+// ErrNoMatchingDevice is returned by SelectDevice when no pattern matches.
+var ErrNoMatchingDevice = errors.New("no audio device matches")
+
+// SelectDevice returns the device matched by the first of patterns that
+// matches any, together with that pattern. A pattern is tried as, in order:
+//
+//   - "default", the device marked as the system default, else the first one;
+//   - a device ID or name, exactly;
+//   - a regular expression between slashes, e.g. "/^USB.*Mic/", against the
+//     name and ID;
+//   - a case-insensitive substring of the name or ID.
+//
+// Where several devices match a pattern, the first listed is chosen. Empty
+// patterns are skipped.
+func SelectDevice(devices []types.AudioDevice, patterns []string) (types.AudioDevice, string, error) {
+	tried := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		tried = append(tried, pattern)
+		matches, err := matchDevices(devices, pattern)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(matches) == 0 {
+			logger.Debug("No audio device matches %q", pattern)
+			continue
+		}
+		if len(matches) > 1 {
+			logger.Warn("%d audio devices match %q; using %v", len(matches), pattern, matches[0].ID())
+		}
+		return matches[0], pattern, nil
+	}
+	if len(tried) == 0 {
+		return nil, "", fmt.Errorf("%w: no device given", ErrNoMatchingDevice)
+	}
+	return nil, "", fmt.Errorf("%w %s", ErrNoMatchingDevice, strings.Join(quoteAll(tried), ", "))
+}
+
+// matchDevices returns the devices pattern matches by the first rule of
+// SelectDevice that matches any.
+func matchDevices(devices []types.AudioDevice, pattern string) ([]types.AudioDevice, error) {
+	if pattern == DefaultDevicePattern {
+		for _, device := range devices {
+			if device.Info().IsDefault {
+				return []types.AudioDevice{device}, nil
+			}
+		}
+		// The host may not say which is the default; it usually lists it first.
+		if len(devices) > 0 {
+			return devices[:1], nil
+		}
+		return nil, nil
+	}
+
+	if device := findDevice(devices, pattern); device != nil {
+		matches := []types.AudioDevice{device}
+		if fmt.Sprint(device.ID()) != pattern {
+			// Matched by name, which other devices may share
+			for _, other := range devices {
+				if other != device && other.Name() == pattern {
+					matches = append(matches, other)
+				}
+			}
+		}
+		return matches, nil
+	}
+
+	match := func(s string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(pattern))
+	}
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid audio device pattern %q: %w", pattern, err)
+		}
+		match = re.MatchString
+	}
+	var matches []types.AudioDevice
+	for _, device := range devices {
+		if match(device.Name()) || match(fmt.Sprint(device.ID())) {
+			matches = append(matches, device)
+		}
+	}
+	return matches, nil
+}
+
+func quoteAll(s []string) []string {
+	quoted := make([]string, len(s))
+	for i, v := range s {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return quoted
+}
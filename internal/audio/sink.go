@@ -0,0 +1,116 @@
+package audio
+
+import (
+	"fmt"
+	"livelylivecaptions/internal/types"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy decides what happens to captured audio when the consumer
+// falls behind and its channel is full.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits for the consumer. Nothing is lost between the sink
+	// and the consumer, but the device may drop audio while capture is held up.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest discards the oldest queued frame to make room, which
+	// keeps captions close to real time.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNewest discards the frame that doesn't fit.
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+)
+
+// ParseOverflowPolicy validates a policy name from the configuration.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(name); p {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+		return p, nil
+	case "":
+		return OverflowBlock, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q (want %s, %s or %s)", name, OverflowBlock, OverflowDropOldest, OverflowDropNewest)
+	}
+}
+
+// LateThreshold is how long after capture a frame may reach the consumer
+// before it is counted as late.
+const LateThreshold = 500 * time.Millisecond
+
+// FrameSink hands frames from a capture loop to a consumer channel according
+// to an OverflowPolicy, and counts frames lost along the way. Deliver must
+// only be called from one goroutine; Stats may be called from any.
+type FrameSink struct {
+	out    chan types.AudioFrame
+	policy OverflowPolicy
+
+	nextSeq  uint64 // Sequence number expected from the device next
+	captured atomic.Uint64
+	lost     atomic.Uint64
+	dropped  atomic.Uint64
+	late     atomic.Uint64
+}
+
+// NewFrameSink creates a FrameSink that delivers to out.
+func NewFrameSink(out chan types.AudioFrame, policy OverflowPolicy) *FrameSink {
+	return &FrameSink{out: out, policy: policy}
+}
+
+// Deliver passes frame on to the consumer. With OverflowBlock it gives up
+// when quit is closed, releases the frame and returns false.
+func (s *FrameSink) Deliver(frame types.AudioFrame, quit <-chan struct{}) bool {
+	s.captured.Add(1)
+	// Frames the device dropped show up as skipped sequence numbers.
+	if frame.Seq > s.nextSeq {
+		s.lost.Add(frame.Seq - s.nextSeq)
+	}
+	s.nextSeq = frame.Seq + 1
+
+	switch s.policy {
+	case OverflowDropNewest:
+		select {
+		case s.out <- frame:
+		default:
+			frame.Release()
+			s.dropped.Add(1)
+			return true
+		}
+	case OverflowDropOldest:
+		for sent := false; !sent; {
+			select {
+			case s.out <- frame:
+				sent = true
+			default:
+				select {
+				case old := <-s.out:
+					old.Release()
+					s.dropped.Add(1)
+				default: // The consumer made room in the meantime
+				}
+			}
+		}
+	default:
+		select {
+		case s.out <- frame:
+		case <-quit:
+			frame.Release()
+			return false
+		}
+	}
+
+	if !frame.CaptureTime.IsZero() && time.Since(frame.CaptureTime) > LateThreshold {
+		s.late.Add(1)
+	}
+	return true
+}
+
+// Stats returns the counts so far.
+func (s *FrameSink) Stats() types.AudioStatsMsg {
+	return types.AudioStatsMsg{
+		Captured: s.captured.Load(),
+		Lost:     s.lost.Load(),
+		Dropped:  s.dropped.Load(),
+		Late:     s.late.Load(),
+	}
+}
@@ -0,0 +1,239 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/logger"
+	"livelylivecaptions/internal/types"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReconnectInterval is how often a Supervisor looks for its lost
+	// device.
+	DefaultReconnectInterval = time.Second
+	// DefaultStallTimeout is how long a Supervisor accepts no audio at all
+	// from a capture device before treating it as lost. An unplugged USB
+	// microphone often goes quiet rather than failing.
+	DefaultStallTimeout = 3 * time.Second
+)
+
+// SupervisorConfig says where a Supervisor finds a replacement for a lost
+// device and whom it tells.
+type SupervisorConfig struct {
+	// Provider lists the devices to reopen from.
+	Provider types.AudioProvider
+	// Fallbacks are device patterns, as SelectDevice takes, to use in order
+	// while the original device is missing.
+	Fallbacks []string
+	// Interval between looks for a device; 0 means DefaultReconnectInterval.
+	Interval time.Duration
+	// StallTimeout is how long no audio means the device is lost; 0 means
+	// DefaultStallTimeout and a negative value disables the check.
+	StallTimeout time.Duration
+	// OnStatus, if set, is called whenever the device is lost or reopened.
+	OnStatus func(types.DeviceStatusMsg)
+}
+
+// Supervisor is an AudioDevice that keeps capture going across device loss.
+// When the device it wraps fails or stalls, Read closes it and, while
+// returning empty frames, polls the provider for the same device or a
+// fallback and reopens it. Frame sequence numbers carry on across devices, so
+// the transcriber downstream never notices more than a pause.
+type Supervisor struct {
+	cfg  SupervisorConfig
+	name string // The original device, looked for first
+	id   string
+	info types.DeviceInfo
+
+	mu        sync.Mutex // Guards device and closed against Close
+	device    types.AudioDevice
+	closed    bool
+	lastAudio time.Time // When the device last delivered samples
+	nextPoll  time.Time // When to look for a device again
+	seqBase   uint64    // Added to the device's sequence numbers
+	nextSeq   uint64    // Sequence number after the last frame returned
+}
+
+// NewSupervisor wraps device, which Start will start.
+func NewSupervisor(device types.AudioDevice, cfg SupervisorConfig) *Supervisor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultReconnectInterval
+	}
+	if cfg.StallTimeout == 0 {
+		cfg.StallTimeout = DefaultStallTimeout
+	}
+	return &Supervisor{cfg: cfg, name: device.Name(), id: fmt.Sprint(device.ID()), info: device.Info(), device: device}
+}
+
+// Name returns the name of the device in use, or of the original one while
+// reconnecting.
+func (s *Supervisor) Name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.device != nil {
+		return s.device.Name()
+	}
+	return s.name
+}
+
+func (s *Supervisor) ID() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.device != nil {
+		return s.device.ID()
+	}
+	return s.id
+}
+
+func (s *Supervisor) Info() types.DeviceInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.device != nil {
+		return s.device.Info()
+	}
+	return s.info
+}
+
+func (s *Supervisor) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.device.Start(); err != nil {
+		return err
+	}
+	s.lastAudio = time.Now()
+	return nil
+}
+
+// Read returns the device's next frame, or an empty one while the device is
+// being reopened. Errors other than io.EOF, which ends the input, are
+// handled by reconnecting rather than returned, unless Close caused them.
+func (s *Supervisor) Read() (types.AudioFrame, error) {
+	s.mu.Lock()
+	device, closed := s.device, s.closed
+	s.mu.Unlock()
+	if closed {
+		return types.AudioFrame{}, fmt.Errorf("%s closed", s.name)
+	}
+	if device == nil {
+		return types.AudioFrame{}, s.reconnect()
+	}
+
+	frame, err := device.Read()
+	if errors.Is(err, io.EOF) {
+		return frame, err
+	}
+	if err == nil && len(frame.Samples) == 0 && s.cfg.StallTimeout > 0 && time.Since(s.lastAudio) > s.cfg.StallTimeout {
+		err = fmt.Errorf("no audio for %s", s.cfg.StallTimeout)
+	}
+	if err != nil {
+		return types.AudioFrame{}, s.lose(device, err)
+	}
+	if len(frame.Samples) > 0 {
+		s.lastAudio = time.Now()
+		frame.Seq += s.seqBase
+		s.nextSeq = frame.Seq + 1
+	}
+	return frame, nil
+}
+
+// lose closes a failed device and starts looking for one to replace it. If
+// the Supervisor was closed meanwhile, which is what failed the read, it
+// returns the closed error instead.
+func (s *Supervisor) lose(device types.AudioDevice, err error) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("%s closed", s.name)
+	}
+	if s.device == device {
+		s.device = nil
+	}
+	s.mu.Unlock()
+	logger.Warn("Lost audio device %s: %v. Reconnecting...", device.Name(), err)
+	device.Close()
+	s.nextPoll = time.Now() // Look right away; a quick replug may already be back
+	s.status(types.DeviceStatusMsg{State: types.DeviceReconnecting, Device: device.Name(), Reason: err.Error()})
+	return nil
+}
+
+// reconnect looks for a device once the poll interval has passed, starting
+// the first that matches. Otherwise it waits as long as a device read would.
+func (s *Supervisor) reconnect() error {
+	if wait := time.Until(s.nextPoll); wait > 0 {
+		time.Sleep(min(wait, readTimeout))
+		return nil
+	}
+	s.nextPoll = time.Now().Add(s.cfg.Interval)
+	if s.cfg.Provider == nil {
+		return nil
+	}
+
+	devices, err := s.cfg.Provider.GetDevices()
+	if err != nil {
+		logger.Debug("Looking for audio device %s: %v", s.name, err)
+		return nil
+	}
+	for i, want := range append([]string{s.id, s.name}, s.cfg.Fallbacks...) {
+		var device types.AudioDevice
+		if i < 2 {
+			// Only the original device itself, not a lookalike
+			device = findDevice(devices, want)
+		} else if matches, _ := matchDevices(devices, want); len(matches) > 0 {
+			device = matches[0]
+		}
+		if device == nil {
+			continue
+		}
+		if err := device.Start(); err != nil {
+			logger.Debug("Reopening audio device %s: %v", device.Name(), err)
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			device.Close()
+			return fmt.Errorf("%s closed", s.name)
+		}
+		s.device = device
+		s.mu.Unlock()
+		s.seqBase = s.nextSeq
+		s.lastAudio = time.Now()
+		logger.Info("Audio device %s reconnected", device.Name())
+		s.status(types.DeviceStatusMsg{State: types.DeviceConnected, Device: device.Name()})
+		return nil
+	}
+	return nil
+}
+
+// findDevice returns the device in devices whose ID or name is want.
+func findDevice(devices []types.AudioDevice, want string) types.AudioDevice {
+	if want == "" {
+		return nil
+	}
+	for _, device := range devices {
+		if fmt.Sprint(device.ID()) == want || device.Name() == want {
+			return device
+		}
+	}
+	return nil
+}
+
+func (s *Supervisor) status(msg types.DeviceStatusMsg) {
+	if s.cfg.OnStatus != nil {
+		s.cfg.OnStatus(msg)
+	}
+}
+
+func (s *Supervisor) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.device == nil {
+		return nil
+	}
+	return s.device.Close()
+}
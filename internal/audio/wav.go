@@ -0,0 +1,328 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// WAV format tags from the fmt chunk.
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+// WAV is decoded audio from a WAV file.
+type WAV struct {
+	SampleRate int
+	Channels   int
+	Samples    []float32 // Interleaved, in the range [-1, 1]
+}
+
+// Frames returns the number of samples per channel.
+func (w *WAV) Frames() int {
+	return len(w.Samples) / w.Channels
+}
+
+// ReadWAVFile decodes the WAV file at path; see ParseWAV.
+func ReadWAVFile(path string) (*WAV, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := ParseWAV(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return w, nil
+}
+
+// ParseWAV decodes 8, 16, 24 or 32-bit integer PCM and 32 or 64-bit float WAV
+// data, including WAVE_FORMAT_EXTENSIBLE headers, with any number of channels.
+func ParseWAV(data []byte) (*WAV, error) {
+	r, err := newWAVReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]float32, min(r.remaining, int64(len(data)))/int64(r.width))
+	n := 0
+	for {
+		m, err := r.read(samples[n:])
+		n += m
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &WAV{SampleRate: r.sampleRate, Channels: r.channels, Samples: samples[:n]}, nil
+}
+
+// wavReader decodes a WAV file's samples a block at a time, so a long
+// recording never has to be held in memory.
+type wavReader struct {
+	sampleRate int
+	channels   int
+	frames     int64 // Samples per channel the data chunk claims to hold
+
+	r         io.Reader
+	width     int   // Bytes per sample
+	remaining int64 // Bytes of the data chunk not read yet
+	decode    func([]byte) float32
+	buf       []byte
+}
+
+// newWAVReader reads the header of the WAV file in r, up to the start of its
+// data chunk. It accepts what ParseWAV does.
+func newWAVReader(r io.Reader) (*wavReader, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+
+	var format, channels, bits, blockAlign int
+	var rate int
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return nil, err
+			}
+			if format == 0 {
+				return nil, fmt.Errorf("missing fmt chunk")
+			}
+			return nil, fmt.Errorf("missing data chunk")
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch id {
+		case "fmt ":
+			body, err := io.ReadAll(io.LimitReader(r, size))
+			if err != nil {
+				return nil, err
+			}
+			if len(body) < 16 {
+				return nil, fmt.Errorf("truncated fmt chunk")
+			}
+			format = int(binary.LittleEndian.Uint16(body[0:2]))
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			rate = int(binary.LittleEndian.Uint32(body[4:8]))
+			blockAlign = int(binary.LittleEndian.Uint16(body[12:14]))
+			bits = int(binary.LittleEndian.Uint16(body[14:16]))
+			if format == wavFormatExtensible {
+				// The real format is the first two bytes of the sub-format GUID.
+				if len(body) < 26 {
+					return nil, fmt.Errorf("truncated WAVE_FORMAT_EXTENSIBLE header")
+				}
+				format = int(binary.LittleEndian.Uint16(body[24:26]))
+			}
+		case "data":
+			if format == 0 {
+				return nil, fmt.Errorf("missing fmt chunk")
+			}
+			if channels < 1 || rate < 1 {
+				return nil, fmt.Errorf("invalid format: %d channels at %d Hz", channels, rate)
+			}
+			width := bits / 8
+			if bits%8 != 0 || width == 0 || blockAlign != width*channels {
+				return nil, fmt.Errorf("unsupported sample layout: %d bits, %d bytes per frame for %d channels", bits, blockAlign, channels)
+			}
+			decode, err := sampleDecoder(format, bits)
+			if err != nil {
+				return nil, err
+			}
+			return &wavReader{
+				sampleRate: rate,
+				channels:   channels,
+				frames:     size / int64(blockAlign),
+				r:          r,
+				width:      width,
+				remaining:  size,
+				decode:     decode,
+			}, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+		if size%2 == 1 {
+			// Chunks are padded to an even size
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+	}
+}
+
+// read decodes whole frames into dst, as many as fit, and returns how many
+// samples it wrote. After the last frame it returns io.EOF; a data chunk cut
+// short by the end of the file just ends early.
+func (w *wavReader) read(dst []float32) (int, error) {
+	frame := int64(w.width * w.channels)
+	n := min(int64(len(dst)/w.channels)*frame, w.remaining/frame*frame)
+	if n == 0 {
+		return 0, io.EOF
+	}
+	if int64(cap(w.buf)) < n {
+		w.buf = make([]byte, n)
+	}
+	buf := w.buf[:n]
+	got, err := io.ReadFull(w.r, buf)
+	got -= got % int(frame)
+	w.remaining -= int64(got)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		w.remaining = 0
+		err = nil
+	}
+	for i := range got / w.width {
+		dst[i] = w.decode(buf[i*w.width:])
+	}
+	if got == 0 && err == nil {
+		return 0, io.EOF
+	}
+	return got / w.width, err
+}
+
+// sampleDecoder returns a function converting one little-endian sample to float32.
+func sampleDecoder(format, bits int) (func([]byte) float32, error) {
+	switch {
+	case format == wavFormatPCM && bits == 8:
+		// 8-bit WAV is unsigned
+		return func(b []byte) float32 { return (float32(b[0]) - 128) / 128 }, nil
+	case format == wavFormatPCM && bits == 16:
+		return func(b []byte) float32 { return float32(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }, nil
+	case format == wavFormatPCM && bits == 24:
+		return func(b []byte) float32 {
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			return float32(v) / (1 << 23)
+		}, nil
+	case format == wavFormatPCM && bits == 32:
+		return func(b []byte) float32 { return float32(float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)) }, nil
+	case format == wavFormatFloat && bits == 32:
+		return func(b []byte) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(b)) }, nil
+	case format == wavFormatFloat && bits == 64:
+		return func(b []byte) float32 { return float32(math.Float64frombits(binary.LittleEndian.Uint64(b))) }, nil
+	}
+	return nil, fmt.Errorf("unsupported WAV encoding: format %#x with %d bits", format, bits)
+}
+
+// Downmix averages interleaved samples of channels channels into mono. Mono
+// input is returned as is.
+func Downmix(samples []float32, channels int) []float32 {
+	if channels <= 1 {
+		return samples
+	}
+	return downmixInto(make([]float32, len(samples)/channels), samples, channels)
+}
+
+// downmixInto is Downmix writing to dst, which must hold len(samples)/channels
+// samples. It returns dst, or samples when they are already mono.
+func downmixInto(dst, samples []float32, channels int) []float32 {
+	if channels <= 1 {
+		return samples
+	}
+	dst = dst[:len(samples)/channels]
+	for i := range dst {
+		var sum float32
+		for _, s := range samples[i*channels : (i+1)*channels] {
+			sum += s
+		}
+		dst[i] = sum / float32(channels)
+	}
+	return dst
+}
+
+// resampleTaps is how many input samples either side of an output sample
+// Resample weighs, at the lower of the two rates.
+const resampleTaps = 16
+
+// Resample converts mono samples from rate from to rate to with a
+// Hann-windowed sinc filter, which also removes frequencies the lower rate
+// can't represent.
+func Resample(samples []float32, from, to int) []float32 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+	r := newResampler(from, to)
+	return r.process(make([]float32, 0, int(float64(len(samples))*r.ratio)), samples, true)
+}
+
+// resampler is Resample for audio that arrives a block at a time. It keeps
+// only the input later output still needs, and its output matches Resample's
+// on all of the input at once.
+type resampler struct {
+	same   bool    // The rates match, so input passes through
+	ratio  float64 // Output samples per input sample
+	cutoff float64 // Filter cutoff relative to the input's Nyquist frequency
+	half   float64 // Filter half-width in input samples
+
+	history []float32 // Input from index base on
+	base    int
+	next    int // Index of the next output sample
+}
+
+func newResampler(from, to int) *resampler {
+	ratio := float64(to) / float64(from)
+	cutoff := math.Min(1, ratio) // Filter at the lower Nyquist frequency
+	return &resampler{same: from == to, ratio: ratio, cutoff: cutoff, half: resampleTaps / cutoff}
+}
+
+// process appends to dst the output that in completes. Once final is set the
+// input has ended, and the rest of the output is appended too.
+func (r *resampler) process(dst, in []float32, final bool) []float32 {
+	if r.same {
+		return append(dst, in...)
+	}
+	src := in
+	if len(r.history) > 0 {
+		r.history = append(r.history, in...)
+		src = r.history
+	}
+	total := r.base + len(src)
+	end := math.MaxInt
+	if final {
+		end = int(float64(total) * r.ratio)
+	}
+	for ; r.next < end; r.next++ {
+		center := float64(r.next) / r.ratio
+		hi := int(math.Floor(center + r.half))
+		if hi >= total {
+			if !final {
+				break // Wait for the input the filter reaches
+			}
+			hi = total - 1
+		}
+		lo := max(0, int(math.Ceil(center-r.half)))
+		var sum, weights float64
+		for j := lo; j <= hi; j++ {
+			x := float64(j) - center
+			w := r.cutoff * sinc(r.cutoff*x) * 0.5 * (1 + math.Cos(math.Pi*x/r.half))
+			sum += float64(src[j-r.base]) * w
+			weights += w
+		}
+		var out float32
+		if weights != 0 {
+			// Normalizing keeps the gain at 1 near the edges, where taps are missing.
+			out = float32(sum / weights)
+		}
+		dst = append(dst, out)
+	}
+
+	// Keep the input from the first tap of the next output on.
+	keep := min(max(0, int(math.Ceil(float64(r.next)/r.ratio-r.half))-r.base), len(src))
+	r.history = append(r.history[:0], src[keep:]...)
+	r.base += keep
+	return dst
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
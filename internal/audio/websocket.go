@@ -0,0 +1,52 @@
+package audio
+
+import (
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// websocketMaxMessage bounds the size of one message, so a misbehaving
+// sender can't make the device allocate without limit.
+const websocketMaxMessage = 1 << 20
+
+// handleWebSocket upgrades a request to a WebSocket and treats every binary
+// message on it as raw PCM. The library answers pings and closes the
+// connection on protocol errors.
+func (d *NetworkAudioDevice) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return // Accept has already answered the request
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(websocketMaxMessage)
+
+	addr, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	id := d.attach(websocketSender{conn}, addr)
+	dec := pcmDecoder{format: d.format}
+	for {
+		typ, payload, err := conn.Read(r.Context())
+		if err != nil {
+			if websocket.CloseStatus(err) != -1 {
+				err = io.EOF // The sender closed the connection
+			}
+			d.detach(id, addr, err)
+			return
+		}
+		if typ == websocket.MessageBinary && !d.push(id, dec.decode(payload)) {
+			return // Replaced by a newer sender
+		}
+	}
+}
+
+// websocketSender lets attach drop a WebSocket sender. It closes at once
+// rather than wait for the close handshake, as attach holds the device's lock.
+type websocketSender struct {
+	conn *websocket.Conn
+}
+
+func (s websocketSender) Close() error {
+	return s.conn.CloseNow()
+}
@@ -0,0 +1,17 @@
+// Package dsp holds signal measurements shared by capture, segmentation and
+// the speech engines.
+package dsp
+
+import "math"
+
+// RMS computes the RMS level of float32 samples in the range [-1, 1].
+func RMS(samples []float32) float64 {
+	if len(samples) == 0 {
+		return 0.0
+	}
+	var sumSquares float64
+	for _, v := range samples {
+		sumSquares += float64(v) * float64(v)
+	}
+	return math.Sqrt(sumSquares / float64(len(samples)))
+}
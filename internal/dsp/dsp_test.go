@@ -0,0 +1,15 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRMS(t *testing.T) {
+	if got := RMS(nil); got != 0 {
+		t.Errorf("RMS(nil) = %f, want 0", got)
+	}
+	if got := RMS([]float32{-0.5, 0.5, -0.5, 0.5}); math.Abs(got-0.5) > 0.0001 {
+		t.Errorf("RMS() = %f, want 0.5", got)
+	}
+}
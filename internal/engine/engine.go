@@ -0,0 +1,48 @@
+// Package engine defines the speech recognition backends the transcriber can
+// drive, independently of any particular library's types.
+package engine
+
+// SpeechEngine is a loaded speech recognition model that can decode any number
+// of independent audio streams.
+type SpeechEngine interface {
+	// Name describes the engine for logs, e.g. "sherpa-onnx (cuda)".
+	Name() string
+	// SampleRate is the rate the model was trained on. Streams accept other
+	// rates, but audio captured at this rate avoids resampling.
+	SampleRate() int
+	// NewStream starts decoding a new audio stream.
+	NewStream() (Stream, error)
+	// Close releases the model. Streams must be closed first.
+	Close()
+}
+
+// Stream is one audio stream being decoded. Streams are not safe for
+// concurrent use.
+type Stream interface {
+	// AcceptWaveform queues samples in the range [-1, 1] for decoding. The
+	// caller may reuse samples once it returns, so they must be copied if kept.
+	AcceptWaveform(sampleRate int, samples []float32)
+	// InputFinished signals that no more audio will be accepted, so the engine
+	// can decode what it was holding back for context.
+	InputFinished()
+	// IsReady reports whether enough audio is queued for Decode to make progress.
+	IsReady() bool
+	// Decode advances recognition over the queued audio.
+	Decode() error
+	// Result returns the current hypothesis for the segment being decoded.
+	Result() string
+	// IsEndpoint reports whether the engine detected the end of an utterance.
+	IsEndpoint() bool
+	// Reset starts a new segment, discarding the current hypothesis.
+	Reset()
+	// Close releases the stream.
+	Close()
+}
+
+// Interrupter is implemented by streams whose Decode may wait on work outside
+// the process, such as a request to a server. Interrupt abandons that work so
+// Decode returns promptly. Unlike the Stream methods, it may be called from
+// any goroutine.
+type Interrupter interface {
+	Interrupt()
+}
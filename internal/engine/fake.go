@@ -0,0 +1,130 @@
+package engine
+
+import "sync"
+
+// FakeStep is one scripted change in what a FakeEngine stream recognizes.
+type FakeStep struct {
+	// At is how many samples the stream must have accepted in total for this
+	// step to take effect. A negative value means "once input has finished".
+	At       int
+	Text     string // Hypothesis for the current segment from this step on
+	Endpoint bool   // Report an endpoint once this step takes effect
+	Err      error  // Decode returns this error when the step takes effect
+}
+
+// FakeEngine is a pure-Go SpeechEngine that replays a script of hypotheses as
+// audio arrives. It ignores the audio itself, which makes transcriber behaviour
+// testable without CGO or model files.
+type FakeEngine struct {
+	Script []FakeStep
+	Rate   int // Reported by SampleRate; defaults to 16000
+
+	mu     sync.Mutex
+	open   int
+	closed bool
+}
+
+// NewFakeEngine creates a FakeEngine that plays script on every new stream.
+func NewFakeEngine(script ...FakeStep) *FakeEngine {
+	return &FakeEngine{Script: script}
+}
+
+func (e *FakeEngine) Name() string {
+	return "fake"
+}
+
+func (e *FakeEngine) SampleRate() int {
+	if e.Rate == 0 {
+		return 16000
+	}
+	return e.Rate
+}
+
+func (e *FakeEngine) NewStream() (Stream, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.open++
+	return &fakeStream{engine: e, script: e.Script}, nil
+}
+
+func (e *FakeEngine) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+}
+
+// Released reports whether the engine and every stream it created were closed.
+func (e *FakeEngine) Released() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closed && e.open == 0
+}
+
+type fakeStream struct {
+	engine   *FakeEngine
+	script   []FakeStep
+	next     int // Index of the first step not yet applied
+	accepted int
+	decoded  int
+	finished bool
+	text     string
+	endpoint bool
+	closed   bool
+}
+
+func (s *fakeStream) AcceptWaveform(sampleRate int, samples []float32) {
+	if s.finished {
+		return
+	}
+	s.accepted += len(samples)
+}
+
+func (s *fakeStream) InputFinished() {
+	s.finished = true
+}
+
+func (s *fakeStream) IsReady() bool {
+	return s.decoded < s.accepted || (s.finished && s.next < len(s.script))
+}
+
+func (s *fakeStream) Decode() error {
+	s.decoded = s.accepted
+	var err error
+	for s.next < len(s.script) {
+		step := s.script[s.next]
+		due := step.At >= 0 && step.At <= s.decoded
+		if !due && !s.finished {
+			break
+		}
+		s.next++
+		s.text = step.Text
+		s.endpoint = step.Endpoint
+		if step.Err != nil && err == nil {
+			err = step.Err
+		}
+	}
+	return err
+}
+
+func (s *fakeStream) Result() string {
+	return s.text
+}
+
+func (s *fakeStream) IsEndpoint() bool {
+	return s.endpoint
+}
+
+func (s *fakeStream) Reset() {
+	s.text = ""
+	s.endpoint = false
+}
+
+func (s *fakeStream) Close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.engine.mu.Lock()
+	s.engine.open--
+	s.engine.mu.Unlock()
+}
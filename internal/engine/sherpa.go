@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"fmt"
+
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+)
+
+// SherpaConfig selects a streaming transducer model for Sherpa-ONNX.
+type SherpaConfig struct {
+	Name           string // Human-readable model name used in errors, e.g. "Nemotron"
+	Encoder        string
+	Decoder        string
+	Joiner         string
+	Tokens         string
+	Provider       string // Execution provider: cpu or cuda
+	NumThreads     int
+	SampleRate     int    // Defaults to 16000
+	DecodingMethod string // greedy_search or modified_beam_search
+	MaxActivePaths int
+}
+
+// SherpaEngine is a SpeechEngine backed by a Sherpa-ONNX online recognizer.
+type SherpaEngine struct {
+	recognizer *sherpa.OnlineRecognizer
+	cfg        SherpaConfig
+}
+
+// NewSherpaEngine loads the model described by cfg.
+// It includes a panic-recovery mechanism to handle CGO errors safely.
+func NewSherpaEngine(cfg SherpaConfig) (e *SherpaEngine, err error) {
+	if cfg.SampleRate == 0 {
+		cfg.SampleRate = 16000
+	}
+	if cfg.NumThreads == 0 {
+		cfg.NumThreads = 1
+	}
+
+	// Defer a function to recover from panics, which can happen with CGO calls
+	// if libraries are missing or there's a hardware mismatch.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occurred during %s model initialization with provider '%s': %v", cfg.Name, cfg.Provider, r)
+		}
+	}()
+
+	config := sherpa.OnlineRecognizerConfig{
+		FeatConfig: sherpa.FeatureConfig{
+			SampleRate: cfg.SampleRate,
+			FeatureDim: 80,
+		},
+		ModelConfig: sherpa.OnlineModelConfig{
+			Transducer: sherpa.OnlineTransducerModelConfig{
+				Encoder: cfg.Encoder,
+				Decoder: cfg.Decoder,
+				Joiner:  cfg.Joiner,
+			},
+			Tokens:     cfg.Tokens,
+			NumThreads: cfg.NumThreads,
+			Provider:   cfg.Provider,
+			Debug:      0,
+		},
+		DecodingMethod: cfg.DecodingMethod,
+		MaxActivePaths: cfg.MaxActivePaths,
+		EnableEndpoint: 1, // Enable endpoint detection
+	}
+
+	recognizer := sherpa.NewOnlineRecognizer(&config)
+	if recognizer == nil {
+		// This path is taken if Sherpa-ONNX returns nil without panicking.
+		return nil, fmt.Errorf("failed to create recognizer with %s model and provider %s (returned nil)", cfg.Name, cfg.Provider)
+	}
+
+	return &SherpaEngine{recognizer: recognizer, cfg: cfg}, nil
+}
+
+func (e *SherpaEngine) Name() string {
+	return fmt.Sprintf("%s sherpa-onnx (%s)", e.cfg.Name, e.cfg.Provider)
+}
+
+func (e *SherpaEngine) SampleRate() int {
+	return e.cfg.SampleRate
+}
+
+func (e *SherpaEngine) NewStream() (Stream, error) {
+	stream := sherpa.NewOnlineStream(e.recognizer)
+	if stream == nil {
+		return nil, fmt.Errorf("failed to create stream for %s model", e.cfg.Name)
+	}
+	return &sherpaStream{recognizer: e.recognizer, stream: stream}, nil
+}
+
+func (e *SherpaEngine) Close() {
+	if e.recognizer != nil {
+		sherpa.DeleteOnlineRecognizer(e.recognizer)
+		e.recognizer = nil
+	}
+}
+
+// sherpaStream adapts a Sherpa-ONNX online stream to the Stream interface.
+type sherpaStream struct {
+	recognizer *sherpa.OnlineRecognizer
+	stream     *sherpa.OnlineStream
+}
+
+func (s *sherpaStream) AcceptWaveform(sampleRate int, samples []float32) {
+	s.stream.AcceptWaveform(sampleRate, samples)
+}
+
+func (s *sherpaStream) InputFinished() {
+	s.stream.InputFinished()
+}
+
+func (s *sherpaStream) IsReady() bool {
+	return s.recognizer.IsReady(s.stream)
+}
+
+func (s *sherpaStream) Decode() error {
+	s.recognizer.Decode(s.stream)
+	return nil
+}
+
+func (s *sherpaStream) Result() string {
+	if result := s.recognizer.GetResult(s.stream); result != nil {
+		return result.Text
+	}
+	return ""
+}
+
+func (s *sherpaStream) IsEndpoint() bool {
+	return s.recognizer.IsEndpoint(s.stream)
+}
+
+func (s *sherpaStream) Reset() {
+	s.recognizer.Reset(s.stream)
+}
+
+func (s *sherpaStream) Close() {
+	if s.stream != nil {
+		sherpa.DeleteOnlineStream(s.stream)
+		s.stream = nil
+	}
+}
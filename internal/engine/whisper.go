@@ -0,0 +1,335 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"livelylivecaptions/internal/dsp"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// transcriptionsPath is the OpenAI-compatible endpoint served by whisper.cpp's
+// server and the faster-whisper wrappers.
+const transcriptionsPath = "/v1/audio/transcriptions"
+
+// WhisperHTTPConfig configures a WhisperHTTPEngine.
+type WhisperHTTPConfig struct {
+	// URL of the server. If it has no path, transcriptionsPath is used.
+	URL string
+	// Model is sent as the "model" form field. whisper.cpp ignores it, but
+	// OpenAI-compatible wrappers require it. Defaults to "whisper-1".
+	Model    string
+	Language string // Optional ISO-639-1 hint, e.g. "en"
+
+	// Utterances are cut by an energy-based voice activity detector: speech
+	// ends after MinSilence below SilenceRMS, or after MaxUtterance regardless.
+	SilenceRMS   float64       // Defaults to 0.01
+	MinSilence   time.Duration // Defaults to 600ms
+	MaxUtterance time.Duration // Defaults to 30s, Whisper's window
+
+	Timeout time.Duration // Per request; defaults to 30s
+	Client  *http.Client  // Defaults to a client with Timeout
+}
+
+// WhisperHTTPEngine is a SpeechEngine that sends each buffered utterance to a
+// local speech-to-text server and reports the reply as a final result. It
+// produces no partials; text appears once per utterance.
+type WhisperHTTPEngine struct {
+	cfg      WhisperHTTPConfig
+	endpoint string
+}
+
+// NewWhisperHTTPEngine validates cfg and fills in defaults. It does not
+// contact the server.
+func NewWhisperHTTPEngine(cfg WhisperHTTPConfig) (*WhisperHTTPEngine, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid speech-to-text server URL %q", cfg.URL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = transcriptionsPath
+	}
+	if cfg.Model == "" {
+		cfg.Model = "whisper-1"
+	}
+	if cfg.SilenceRMS == 0 {
+		cfg.SilenceRMS = 0.01
+	}
+	if cfg.MinSilence == 0 {
+		cfg.MinSilence = 600 * time.Millisecond
+	}
+	if cfg.MaxUtterance == 0 {
+		cfg.MaxUtterance = 30 * time.Second
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+	}
+	return &WhisperHTTPEngine{cfg: cfg, endpoint: u.String()}, nil
+}
+
+func (e *WhisperHTTPEngine) Name() string {
+	return fmt.Sprintf("whisper-http (%s)", e.endpoint)
+}
+
+func (e *WhisperHTTPEngine) SampleRate() int {
+	return 16000
+}
+
+func (e *WhisperHTTPEngine) NewStream() (Stream, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &whisperStream{engine: e, ctx: ctx, cancel: cancel}, nil
+}
+
+func (e *WhisperHTTPEngine) Close() {}
+
+// whisperRequest is one utterance being transcribed.
+type whisperRequest struct {
+	done chan struct{} // Closed when text and err are set
+	text string
+	err  error
+}
+
+func (r *whisperRequest) completed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// whisperStream buffers audio into utterances and transcribes them in the
+// background, so a slow server never holds up audio capture.
+type whisperStream struct {
+	engine *WhisperHTTPEngine
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	rate     int
+	buf      []float32 // Audio of the current utterance, plus a little lead-in
+	voiced   bool      // Whether buf contains speech
+	silence  int       // Consecutive quiet samples at the end of buf
+	finished bool
+
+	queue []*whisperRequest // Requests not yet collected, in the order they were sent
+
+	text     string
+	endpoint bool
+}
+
+func (s *whisperStream) AcceptWaveform(sampleRate int, samples []float32) {
+	if s.finished || len(samples) == 0 {
+		return
+	}
+	if s.rate == 0 {
+		s.rate = sampleRate
+	}
+	s.buf = append(s.buf, samples...)
+
+	if dsp.RMS(samples) >= s.engine.cfg.SilenceRMS {
+		s.voiced = true
+		s.silence = 0
+	} else {
+		s.silence += len(samples)
+	}
+
+	// Before speech starts, keep only a short lead-in so onsets aren't clipped.
+	if !s.voiced {
+		if leadIn := s.rate / 5; len(s.buf) > leadIn {
+			s.buf = append(s.buf[:0], s.buf[len(s.buf)-leadIn:]...)
+		}
+		return
+	}
+
+	if s.silence >= s.samples(s.engine.cfg.MinSilence) || len(s.buf) >= s.samples(s.engine.cfg.MaxUtterance) {
+		s.send()
+	}
+}
+
+func (s *whisperStream) InputFinished() {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	if s.voiced {
+		s.send()
+	}
+}
+
+// IsReady reports whether a transcription can be collected. Only one result
+// is surfaced at a time; the next becomes ready after Reset. Once input has
+// finished, outstanding requests are waited for.
+func (s *whisperStream) IsReady() bool {
+	if s.endpoint || len(s.queue) == 0 {
+		return false
+	}
+	return s.finished || s.queue[0].completed()
+}
+
+// Decode collects the next completed transcription. After input has finished
+// it blocks until every outstanding request is done, or the stream is
+// interrupted, and joins their text.
+func (s *whisperStream) Decode() error {
+	var texts []string
+	var firstErr error
+	for len(s.queue) > 0 {
+		req := s.queue[0]
+		select {
+		case <-req.done:
+		case <-s.ctx.Done():
+			// Interrupted: the utterances still being transcribed are abandoned.
+			s.queue = nil
+			continue
+		}
+		s.queue = s.queue[1:]
+		if req.err != nil && firstErr == nil && s.ctx.Err() == nil {
+			firstErr = req.err
+		}
+		if req.text != "" {
+			texts = append(texts, req.text)
+		}
+		if !s.finished {
+			break
+		}
+	}
+	s.text = strings.Join(texts, " ")
+	s.endpoint = true
+	return firstErr
+}
+
+func (s *whisperStream) Result() string {
+	return s.text
+}
+
+func (s *whisperStream) IsEndpoint() bool {
+	return s.endpoint
+}
+
+// Reset clears the delivered result. Audio that is buffered or being
+// transcribed is kept.
+func (s *whisperStream) Reset() {
+	s.text = ""
+	s.endpoint = false
+}
+
+// Interrupt cancels the requests in flight, so Decode stops waiting for them.
+func (s *whisperStream) Interrupt() {
+	s.cancel()
+}
+
+func (s *whisperStream) Close() {
+	s.cancel()
+}
+
+// send starts transcribing the buffered utterance and starts a new one.
+func (s *whisperStream) send() {
+	audio := s.buf
+	s.buf = nil
+	s.voiced = false
+	s.silence = 0
+
+	req := &whisperRequest{done: make(chan struct{})}
+	s.queue = append(s.queue, req)
+	go func(rate int) {
+		defer close(req.done)
+		req.text, req.err = s.engine.transcribe(s.ctx, audio, rate)
+	}(s.rate)
+}
+
+func (s *whisperStream) samples(d time.Duration) int {
+	return int(d.Seconds() * float64(s.rate))
+}
+
+// transcribe posts one utterance as a WAV file and returns the recognized text.
+func (e *WhisperHTTPEngine) transcribe(ctx context.Context, samples []float32, rate int) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "utterance.wav")
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(encodeWAV(samples, rate)); err != nil {
+		return "", err
+	}
+	fields := map[string]string{
+		"model":           e.cfg.Model,
+		"response_format": "json",
+		"language":        e.cfg.Language,
+	}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return "", err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := e.cfg.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("speech-to-text request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read speech-to-text response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("speech-to-text server returned %s: %s", resp.Status, strings.TrimSpace(string(payload)))
+	}
+
+	var reply struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(payload, &reply); err != nil {
+		return "", fmt.Errorf("invalid speech-to-text response: %w", err)
+	}
+	return strings.TrimSpace(reply.Text), nil
+}
+
+// encodeWAV wraps float32 samples in a mono 16-bit PCM WAV container.
+func encodeWAV(samples []float32, rate int) []byte {
+	const headerSize = 44
+	dataSize := len(samples) * 2
+	buf := make([]byte, headerSize+dataSize)
+
+	copy(buf[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(headerSize-8+dataSize))
+	copy(buf[8:], "WAVE")
+	copy(buf[12:], "fmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)             // fmt chunk size
+	binary.LittleEndian.PutUint16(buf[20:], 1)              // PCM
+	binary.LittleEndian.PutUint16(buf[22:], 1)              // Mono
+	binary.LittleEndian.PutUint32(buf[24:], uint32(rate))   // Sample rate
+	binary.LittleEndian.PutUint32(buf[28:], uint32(rate*2)) // Byte rate
+	binary.LittleEndian.PutUint16(buf[32:], 2)              // Block align
+	binary.LittleEndian.PutUint16(buf[34:], 16)             // Bits per sample
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(dataSize))
+
+	for i, v := range samples {
+		v = max(-1, min(1, v))
+		binary.LittleEndian.PutUint16(buf[headerSize+2*i:], uint16(int16(v*32767)))
+	}
+	return buf
+}
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWhisperServer answers transcription requests with a numbered reply and
+// records the audio length of each request.
+func fakeWhisperServer(t *testing.T) (*httptest.Server, func() []int) {
+	t.Helper()
+	var mu sync.Mutex
+	var lengths []int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != transcriptionsPath {
+			http.NotFound(w, r)
+			return
+		}
+		if r.FormValue("model") != "whisper-1" {
+			http.Error(w, "model is required", http.StatusBadRequest)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wav, _ := io.ReadAll(file)
+		if len(wav) < 44 || string(wav[0:4]) != "RIFF" || binary.LittleEndian.Uint32(wav[24:28]) != 16000 {
+			http.Error(w, "expected a 16kHz WAV file", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		lengths = append(lengths, len(wav)-44)
+		n := len(lengths)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"text": " utterance `+string(rune('0'+n))+` "}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), lengths...)
+	}
+}
+
+func tone(n int, level float32) []float32 {
+	samples := make([]float32, n)
+	for i := range samples {
+		samples[i] = level
+	}
+	return samples
+}
+
+// collect waits for the stream's next result.
+func collect(t *testing.T, s Stream) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.IsReady() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a transcription")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := s.Decode(); err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	if !s.IsEndpoint() {
+		t.Fatal("a collected transcription should be an endpoint")
+	}
+	text := s.Result()
+	s.Reset()
+	return text
+}
+
+func TestWhisperHTTPEngine(t *testing.T) {
+	srv, lengths := fakeWhisperServer(t)
+
+	e, err := NewWhisperHTTPEngine(WhisperHTTPConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := e.NewStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Leading silence is trimmed, speech followed by 600ms of quiet is one utterance.
+	s.AcceptWaveform(16000, tone(16000, 0))
+	if s.IsReady() {
+		t.Fatal("silence alone should not produce an utterance")
+	}
+	s.AcceptWaveform(16000, tone(8000, 0.2))
+	s.AcceptWaveform(16000, tone(9600, 0))
+	if got := collect(t, s); got != "utterance 1" {
+		t.Errorf("first result = %q", got)
+	}
+	// 200ms lead-in + 500ms speech + 600ms silence, as 16-bit samples.
+	if got := lengths(); len(got) != 1 || got[0] != (3200+8000+9600)*2 {
+		t.Errorf("sent audio bytes = %v", got)
+	}
+
+	// Speech still going when input ends is sent by InputFinished.
+	s.AcceptWaveform(16000, tone(4000, 0.2))
+	s.InputFinished()
+	if got := collect(t, s); got != "utterance 2" {
+		t.Errorf("result after InputFinished = %q", got)
+	}
+	if s.IsReady() {
+		t.Error("nothing should be left after the final result")
+	}
+}
+
+func TestWhisperHTTPEngineServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	e, err := NewWhisperHTTPEngine(WhisperHTTPConfig{URL: srv.URL + "/inference"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := e.NewStream()
+	defer s.Close()
+
+	s.AcceptWaveform(16000, tone(4000, 0.2))
+	s.InputFinished()
+	if !s.IsReady() {
+		t.Fatal("a finished stream with a pending request should be ready")
+	}
+	err = s.Decode()
+	if err == nil || !strings.Contains(err.Error(), "model not loaded") {
+		t.Errorf("Decode() = %v, want the server's error", err)
+	}
+}
+
+func TestWhisperHTTPEngineInterrupt(t *testing.T) {
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	e, err := NewWhisperHTTPEngine(WhisperHTTPConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := e.NewStream()
+	defer s.Close()
+
+	s.AcceptWaveform(16000, tone(4000, 0.2))
+	s.InputFinished()
+	time.AfterFunc(50*time.Millisecond, s.(Interrupter).Interrupt)
+
+	start := time.Now()
+	if err := s.Decode(); err != nil {
+		t.Errorf("Decode() after Interrupt = %v, want nil", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("Decode() waited %v for an interrupted request", waited)
+	}
+	if s.IsReady() {
+		t.Error("an interrupted stream should have nothing left to decode")
+	}
+}
+
+func TestNewWhisperHTTPEngineRejectsBadURL(t *testing.T) {
+	for _, u := range []string{"", "localhost:8080", "ftp://host/"} {
+		if _, err := NewWhisperHTTPEngine(WhisperHTTPConfig{URL: u}); err == nil {
+			t.Errorf("NewWhisperHTTPEngine(%q) succeeded, want an error", u)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package eval scores transcripts against references: word and character
+// error rates with substitution, insertion and deletion counts, and a diff
+// showing where they occurred.
+package eval
+
+import "strings"
+
+// OpKind is how one position of an alignment relates the hypothesis to the reference.
+type OpKind int
+
+const (
+	OpEqual      OpKind = iota // Reference and hypothesis agree
+	OpSubstitute               // The hypothesis has a different token
+	OpInsert                   // The hypothesis has an extra token
+	OpDelete                   // The hypothesis is missing a reference token
+)
+
+// Op is one step of an alignment. Ref is empty for insertions and Hyp for deletions.
+type Op struct {
+	Kind OpKind
+	Ref  string
+	Hyp  string
+}
+
+// Score counts the edits that turn a reference into a hypothesis.
+type Score struct {
+	Hits          int `json:"hits"`
+	Substitutions int `json:"substitutions"`
+	Insertions    int `json:"insertions"`
+	Deletions     int `json:"deletions"`
+}
+
+// RefLen returns the number of reference tokens.
+func (s Score) RefLen() int {
+	return s.Hits + s.Substitutions + s.Deletions
+}
+
+// Errors returns the number of edits.
+func (s Score) Errors() int {
+	return s.Substitutions + s.Insertions + s.Deletions
+}
+
+// Rate returns the error rate: edits per reference token. An empty reference
+// scores 0 against an empty hypothesis and 1 against anything else.
+func (s Score) Rate() float64 {
+	if s.RefLen() == 0 {
+		if s.Insertions > 0 {
+			return 1
+		}
+		return 0
+	}
+	return float64(s.Errors()) / float64(s.RefLen())
+}
+
+// Add returns the combined counts of s and o, for scoring a corpus.
+func (s Score) Add(o Score) Score {
+	return Score{
+		Hits:          s.Hits + o.Hits,
+		Substitutions: s.Substitutions + o.Substitutions,
+		Insertions:    s.Insertions + o.Insertions,
+		Deletions:     s.Deletions + o.Deletions,
+	}
+}
+
+// Moves through the edit-distance matrix, recorded by Align for walking back.
+const (
+	moveDiagonal byte = iota // Match or substitution
+	moveDelete
+	moveInsert
+)
+
+// Align finds a minimum-edit alignment of hyp against ref. Where several
+// alignments are equally short, it pairs tokens up as matches or
+// substitutions rather than leaving them unaligned. It keeps one byte per
+// pair of tokens to walk the alignment back; Compare only counts, in memory
+// proportional to len(hyp).
+func Align(ref, hyp []string) []Op {
+	// prev and cur are rows of the edit distances between prefixes of ref
+	// and hyp; moves[i*cols+j] is how the best alignment of ref[:i] and
+	// hyp[:j] ends.
+	cols := len(hyp) + 1
+	moves := make([]byte, (len(ref)+1)*cols)
+	prev, cur := make([]int, cols), make([]int, cols)
+	for j := range prev {
+		prev[j] = j
+		moves[j] = moveInsert
+	}
+	for i := 1; i <= len(ref); i++ {
+		cur[0] = i
+		moves[i*cols] = moveDelete
+		for j := 1; j < cols; j++ {
+			cur[j], moves[i*cols+j] = step(prev[j-1], prev[j], cur[j-1], ref[i-1] != hyp[j-1])
+		}
+		prev, cur = cur, prev
+	}
+
+	// Walk back from the end, then reverse.
+	var ops []Op
+	for i, j := len(ref), len(hyp); i > 0 || j > 0; {
+		switch moves[i*cols+j] {
+		case moveDiagonal:
+			kind := OpEqual
+			if ref[i-1] != hyp[j-1] {
+				kind = OpSubstitute
+			}
+			ops = append(ops, Op{Kind: kind, Ref: ref[i-1], Hyp: hyp[j-1]})
+			i, j = i-1, j-1
+		case moveDelete:
+			ops = append(ops, Op{Kind: OpDelete, Ref: ref[i-1]})
+			i--
+		default:
+			ops = append(ops, Op{Kind: OpInsert, Hyp: hyp[j-1]})
+			j--
+		}
+	}
+	for l, r := 0, len(ops)-1; l < r; l, r = l+1, r-1 {
+		ops[l], ops[r] = ops[r], ops[l]
+	}
+	return ops
+}
+
+// step picks the cheapest way to extend an alignment from the distances
+// diagonally, above and to the left, preferring the diagonal, then a deletion.
+func step(diagonal, above, left int, differ bool) (int, byte) {
+	if differ {
+		diagonal++
+	}
+	switch {
+	case diagonal <= above+1 && diagonal <= left+1:
+		return diagonal, moveDiagonal
+	case above <= left:
+		return above + 1, moveDelete
+	default:
+		return left + 1, moveInsert
+	}
+}
+
+// Compare counts the edits of the alignment Align would find, without
+// building it, so long transcripts can be scored in little memory.
+func Compare(ref, hyp []string) Score {
+	prev, cur := make([]Score, len(hyp)+1), make([]Score, len(hyp)+1)
+	for j := range prev {
+		prev[j] = Score{Insertions: j}
+	}
+	for i := 1; i <= len(ref); i++ {
+		cur[0] = Score{Deletions: i}
+		for j := 1; j <= len(hyp); j++ {
+			differ := ref[i-1] != hyp[j-1]
+			_, move := step(prev[j-1].Errors(), prev[j].Errors(), cur[j-1].Errors(), differ)
+			switch {
+			case move == moveDiagonal && differ:
+				cur[j] = prev[j-1]
+				cur[j].Substitutions++
+			case move == moveDiagonal:
+				cur[j] = prev[j-1]
+				cur[j].Hits++
+			case move == moveDelete:
+				cur[j] = prev[j]
+				cur[j].Deletions++
+			default:
+				cur[j] = cur[j-1]
+				cur[j].Insertions++
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(hyp)]
+}
+
+// Count tallies an alignment.
+func Count(ops []Op) Score {
+	var s Score
+	for _, op := range ops {
+		switch op.Kind {
+		case OpEqual:
+			s.Hits++
+		case OpSubstitute:
+			s.Substitutions++
+		case OpInsert:
+			s.Insertions++
+		case OpDelete:
+			s.Deletions++
+		}
+	}
+	return s
+}
+
+// Words aligns the words of hyp against those of ref.
+func Words(ref, hyp string) []Op {
+	return Align(strings.Fields(ref), strings.Fields(hyp))
+}
+
+// WordErrors counts the word edits between ref and hyp.
+func WordErrors(ref, hyp string) Score {
+	return Compare(strings.Fields(ref), strings.Fields(hyp))
+}
+
+// CharErrors counts the character edits between ref and hyp, with runs of
+// whitespace counted as a single space.
+func CharErrors(ref, hyp string) Score {
+	return Compare(chars(ref), chars(hyp))
+}
+
+func chars(text string) []string {
+	text = strings.Join(strings.Fields(text), " ")
+	out := make([]string, 0, len(text))
+	for _, r := range text {
+		out = append(out, string(r))
+	}
+	return out
+}
+
+// Diff renders a word alignment in the style of git's word diff: deleted
+// reference words as [-word-], inserted hypothesis words as {+word+}, and a
+// substitution as both.
+func Diff(ops []Op) string {
+	parts := make([]string, 0, len(ops))
+	for _, op := range ops {
+		switch op.Kind {
+		case OpEqual:
+			parts = append(parts, op.Ref)
+		case OpSubstitute:
+			parts = append(parts, "[-"+op.Ref+"-]{+"+op.Hyp+"+}")
+		case OpInsert:
+			parts = append(parts, "{+"+op.Hyp+"+}")
+		case OpDelete:
+			parts = append(parts, "[-"+op.Ref+"-]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,126 @@
+package eval
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name       string
+		ref, hyp   string
+		want       Score
+		wantRate   float64
+		wantDiffed string
+	}{
+		{
+			name:       "exact match",
+			ref:        "the cat sat",
+			hyp:        "the cat sat",
+			want:       Score{Hits: 3},
+			wantDiffed: "the cat sat",
+		},
+		{
+			name:       "substitution",
+			ref:        "the cat sat",
+			hyp:        "the hat sat",
+			want:       Score{Hits: 2, Substitutions: 1},
+			wantRate:   1.0 / 3,
+			wantDiffed: "the [-cat-]{+hat+} sat",
+		},
+		{
+			name:       "insertion and deletion",
+			ref:        "the cat sat on the mat",
+			hyp:        "uh the cat sat on mat",
+			want:       Score{Hits: 5, Insertions: 1, Deletions: 1},
+			wantRate:   2.0 / 6,
+			wantDiffed: "{+uh+} the cat sat on [-the-] mat",
+		},
+		{
+			name:       "empty hypothesis",
+			ref:        "hello world",
+			hyp:        "",
+			want:       Score{Deletions: 2},
+			wantRate:   1,
+			wantDiffed: "[-hello-] [-world-]",
+		},
+		{
+			name:       "empty reference",
+			ref:        "",
+			hyp:        "noise",
+			want:       Score{Insertions: 1},
+			wantRate:   1,
+			wantDiffed: "{+noise+}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := Words(tt.ref, tt.hyp)
+			got := Count(ops)
+			if got != tt.want {
+				t.Errorf("Count() = %+v, want %+v", got, tt.want)
+			}
+			if fast := WordErrors(tt.ref, tt.hyp); fast != got {
+				t.Errorf("WordErrors() = %+v, want %+v as aligned", fast, got)
+			}
+			if math.Abs(got.Rate()-tt.wantRate) > 1e-9 {
+				t.Errorf("Rate() = %f, want %f", got.Rate(), tt.wantRate)
+			}
+			if diff := Diff(ops); diff != tt.wantDiffed {
+				t.Errorf("Diff() = %q, want %q", diff, tt.wantDiffed)
+			}
+		})
+	}
+}
+
+func TestChars(t *testing.T) {
+	got := CharErrors("kitten", "sitting")
+	// The classic example: two substitutions and one insertion.
+	if want := (Score{Hits: 4, Substitutions: 2, Insertions: 1}); got != want {
+		t.Errorf("CharErrors() = %+v, want %+v", got, want)
+	}
+	if got := CharErrors("a  b", "a b"); got.Errors() != 0 {
+		t.Errorf("whitespace runs should compare equal, got %+v", got)
+	}
+}
+
+func TestCompareMatchesAlign(t *testing.T) {
+	// Compare must count what Align lines up, ties included.
+	rng := rand.New(rand.NewPCG(1, 2))
+	words := func() []string {
+		out := make([]string, rng.IntN(12))
+		for i := range out {
+			out[i] = string(rune('a' + rng.IntN(3)))
+		}
+		return out
+	}
+	for range 500 {
+		ref, hyp := words(), words()
+		if got, want := Compare(ref, hyp), Count(Align(ref, hyp)); got != want {
+			t.Fatalf("Compare(%q, %q) = %+v, want %+v", ref, hyp, got, want)
+		}
+	}
+}
+
+func TestScoreAdd(t *testing.T) {
+	corpus := Score{Hits: 3, Substitutions: 1}.Add(Score{Hits: 1, Deletions: 1, Insertions: 2})
+	if corpus.RefLen() != 6 || corpus.Errors() != 4 {
+		t.Errorf("combined score = %+v", corpus)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"Hello, World!":              "hello world",
+		"  Don't   STOP\tbelieving ": "don't stop believing",
+		"'quoted' text-with-dashes":  "quoted text with dashes",
+		"It costs 5 dollars.":        "it costs 5 dollars",
+		"":                           "",
+	}
+	for in, want := range tests {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
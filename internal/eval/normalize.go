@@ -0,0 +1,21 @@
+package eval
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Normalize prepares text for scoring so that formatting differences aren't
+// counted as recognition errors: it lowercases, replaces punctuation with
+// spaces (keeping apostrophes inside words, as in "don't") and collapses
+// whitespace.
+func Normalize(text string) string {
+	text = strings.ToLower(text)
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+	for i, w := range words {
+		words[i] = strings.Trim(w, "'")
+	}
+	return strings.Join(strings.Fields(strings.Join(words, " ")), " ")
+}
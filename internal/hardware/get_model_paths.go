@@ -52,6 +52,13 @@ func GetModelPaths(p Provider) (encoder, decoder, joiner, tokens string) {
 		decoder = filepath.Join(modelDir, "decoder-epoch-99-avg-1-chunk-16-left-128.int8.onnx")
 		joiner = filepath.Join(modelDir, "joiner-epoch-99-avg-1-chunk-16-left-128.int8.onnx")
 		tokens = filepath.Join(modelDir, "tokens.txt")
+	case ProviderTelephony:
+		// A streaming transducer trained on 8 kHz narrowband speech
+		modelDir := filepath.Join(projectRoot, "models", "telephony")
+		encoder = filepath.Join(modelDir, "encoder.onnx")
+		decoder = filepath.Join(modelDir, "decoder.onnx")
+		joiner = filepath.Join(modelDir, "joiner.onnx")
+		tokens = filepath.Join(modelDir, "tokens.txt")
 	default:
 		panic(fmt.Sprintf("unsupported provider: %s", p))
 	}
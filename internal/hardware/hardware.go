@@ -8,6 +8,8 @@ const (
 	ProviderCUDA    Provider = "cuda"
 	ProviderNemotron Provider = "nemotron"
 	ProviderSherpaJune2023 Provider = "sherpa_june_2023"  // For the 2023-06-26 model
+	ProviderWhisperHTTP Provider = "whisper_http" // Remote OpenAI-compatible speech-to-text server
+	ProviderTelephony Provider = "telephony" // 8 kHz model for phone calls
 	// ProviderCoreML Provider = "coreml" // For future use on macOS
 	ProviderMock Provider = "mock" // For testing purposes
 )
@@ -0,0 +1,126 @@
+// Package metrics measures how quickly captions follow speech: latency from
+// capture to caption events, and how fast the decoder runs relative to real time.
+package metrics
+
+import (
+	"livelylivecaptions/internal/types"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWindow is how many recent events the latency percentiles cover.
+const DefaultWindow = 200
+
+// Window keeps the most recent durations and reports percentiles over them.
+type Window struct {
+	samples []time.Duration
+	next    int  // Where the next sample goes
+	full    bool // Whether samples has wrapped around
+}
+
+// NewWindow creates a Window over the last size durations.
+func NewWindow(size int) *Window {
+	return &Window{samples: make([]time.Duration, size)}
+}
+
+// Add records d, replacing the oldest duration once the window is full.
+func (w *Window) Add(d time.Duration) {
+	w.samples[w.next] = d
+	w.next++
+	if w.next == len(w.samples) {
+		w.next = 0
+		w.full = true
+	}
+}
+
+// Len returns how many durations the window holds.
+func (w *Window) Len() int {
+	if w.full {
+		return len(w.samples)
+	}
+	return w.next
+}
+
+// Percentile returns the nearest-rank p-th percentile (0-100) of the window,
+// or 0 if it is empty.
+func (w *Window) Percentile(p float64) time.Duration {
+	n := w.Len()
+	if n == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(p/100*float64(n)+0.5) - 1
+	return sorted[max(0, min(rank, n-1))]
+}
+
+// Recorder collects the latency and real-time-factor figures of one
+// captioning session. It is safe for concurrent use.
+type Recorder struct {
+	mu       sync.Mutex
+	partial  *Window
+	final    *Window
+	partials int
+	finals   int
+	audio    time.Duration // Audio decoded so far
+	decoding time.Duration // Time spent decoding it
+}
+
+// NewRecorder creates a Recorder whose percentiles cover DefaultWindow events.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		partial: NewWindow(DefaultWindow),
+		final:   NewWindow(DefaultWindow),
+	}
+}
+
+// ObserveEvent records that ev was delivered at delivered. Its latency runs
+// from ev.EndTime, when the newest audio it reflects was captured. Events
+// without an EndTime, from audio that carried no capture time, are ignored,
+// as are kinds other than partials and finals.
+func (r *Recorder) ObserveEvent(ev types.TranscriptionEvent, delivered time.Time) {
+	if ev.EndTime.IsZero() {
+		return
+	}
+	latency := delivered.Sub(ev.EndTime)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch ev.Kind {
+	case types.EventPartial:
+		r.partial.Add(latency)
+		r.partials++
+	case types.EventFinal:
+		r.final.Add(latency)
+		r.finals++
+	}
+}
+
+// ObserveDecode records that decoding a chunk of audio lasting audio took elapsed.
+func (r *Recorder) ObserveDecode(audio, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.audio += audio
+	r.decoding += elapsed
+}
+
+// Snapshot returns the current figures.
+func (r *Recorder) Snapshot() types.MetricsMsg {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := types.MetricsMsg{
+		PartialP50: r.partial.Percentile(50),
+		PartialP95: r.partial.Percentile(95),
+		FinalP50:   r.final.Percentile(50),
+		FinalP95:   r.final.Percentile(95),
+		Partials:   r.partials,
+		Finals:     r.finals,
+		Audio:      r.audio,
+	}
+	if r.audio > 0 {
+		m.RTF = r.decoding.Seconds() / r.audio.Seconds()
+	}
+	return m
+}
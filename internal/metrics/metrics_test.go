@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"livelylivecaptions/internal/types"
+	"testing"
+	"time"
+)
+
+func TestWindowPercentile(t *testing.T) {
+	w := NewWindow(10)
+	if got := w.Percentile(50); got != 0 {
+		t.Errorf("empty window p50 = %v, want 0", got)
+	}
+	for i := 1; i <= 10; i++ {
+		w.Add(time.Duration(i) * time.Millisecond)
+	}
+	if got := w.Percentile(50); got != 5*time.Millisecond {
+		t.Errorf("p50 = %v, want 5ms", got)
+	}
+	if got := w.Percentile(95); got != 10*time.Millisecond {
+		t.Errorf("p95 = %v, want 10ms", got)
+	}
+
+	// Newer samples push out the oldest ones.
+	for i := 0; i < 10; i++ {
+		w.Add(100 * time.Millisecond)
+	}
+	if got, n := w.Percentile(50), w.Len(); got != 100*time.Millisecond || n != 10 {
+		t.Errorf("after wrapping p50 = %v over %d samples, want 100ms over 10", got, n)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	if got := r.Snapshot(); got.RTF != 0 || got.Partials != 0 {
+		t.Errorf("empty snapshot = %+v", got)
+	}
+
+	captured := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	observe := func(kind types.EventKind, end time.Time, latency time.Duration) {
+		r.ObserveEvent(types.TranscriptionEvent{Kind: kind, EndTime: end}, captured.Add(latency))
+	}
+	observe(types.EventPartial, captured, 100*time.Millisecond)
+	observe(types.EventPartial, captured, 300*time.Millisecond)
+	observe(types.EventFinal, captured, 200*time.Millisecond)
+	observe(types.EventRetract, captured, time.Hour)         // Not measured
+	observe(types.EventFinal, time.Time{}, time.Millisecond) // No capture time, not measured
+	r.ObserveDecode(time.Second, 250*time.Millisecond)
+	r.ObserveDecode(time.Second, 250*time.Millisecond)
+
+	got := r.Snapshot()
+	want := types.MetricsMsg{
+		PartialP50: 100 * time.Millisecond,
+		PartialP95: 300 * time.Millisecond,
+		FinalP50:   200 * time.Millisecond,
+		FinalP95:   200 * time.Millisecond,
+		Partials:   2,
+		Finals:     1,
+		RTF:        0.25,
+		Audio:      2 * time.Second,
+	}
+	if got != want {
+		t.Errorf("Snapshot() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,351 @@
+package transcriber
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"livelylivecaptions/internal/engine"
+	"livelylivecaptions/internal/hardware"
+	"livelylivecaptions/internal/logger"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeClip is one second of 16 kHz mono 16-bit PCM that the probe decodes:
+// synthesized speech-like syllables, made by probe_gen.go.
+//
+//go:generate go run probe_gen.go
+//go:embed probe_16k.pcm
+var probeClip []byte
+
+// probeProviders are the execution providers the probe tries, in order of
+// preference when they are equally fast.
+var probeProviders = []hardware.Provider{hardware.ProviderCPU, hardware.ProviderCUDA}
+
+// probeMargin is how much lower a later provider's RTF must be to be picked
+// over an earlier one. Sherpa-ONNX silently runs on the CPU when a provider's
+// libraries are missing, so a "cuda" that merely ties the CPU isn't a GPU.
+const probeMargin = 0.8
+
+// probeRuns is how many timed decodes the probe takes the fastest of, after
+// an untimed one that absorbs graph optimization and kernel loading.
+const probeRuns = 3
+
+// probeCacheVersion changes whenever ProviderProbe changes meaning, so
+// results from older versions are probed again.
+const probeCacheVersion = 2
+
+// ProbeResult is how one execution provider did in the probe.
+type ProbeResult struct {
+	Provider hardware.Provider `json:"provider"`
+	RTF      float64           `json:"rtf,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// ProviderProbe records which execution providers could decode the probe clip
+// and how fast.
+type ProviderProbe struct {
+	Version  int               `json:"version"`
+	Key      string            `json:"key"` // Identifies the binary, models and machine probed
+	ProbedAt time.Time         `json:"probed_at"`
+	Model    hardware.Provider `json:"model"`
+	Results  []ProbeResult     `json:"results"`
+	Best     hardware.Provider `json:"best,omitempty"` // Empty if no provider worked
+}
+
+// Ranked returns the providers that worked, best first.
+func (p ProviderProbe) Ranked() []hardware.Provider {
+	var ranked []hardware.Provider
+	if p.Best != "" {
+		ranked = append(ranked, p.Best)
+	}
+	var rest []ProbeResult
+	for _, r := range p.Results {
+		if r.Error == "" && r.Provider != p.Best {
+			rest = append(rest, r)
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool { return rest[i].RTF < rest[j].RTF })
+	for _, r := range rest {
+		ranked = append(ranked, r.Provider)
+	}
+	return ranked
+}
+
+var (
+	probeMu     sync.Mutex
+	probeResult *ProviderProbe
+)
+
+// DetectBestProvider returns the execution provider that decoded a second of
+// audio fastest, probing each provider the first time it is called and
+// reusing the result from the user's cache directory on later runs. refresh
+// forces a new probe. Without model files to probe with, it falls back to
+// hardware.DetectBestProvider.
+func DetectBestProvider(refresh bool) hardware.Provider {
+	if probe, ok := probeProviderOnce(refresh); ok && probe.Best != "" {
+		return probe.Best
+	}
+	return hardware.DetectBestProvider()
+}
+
+// providerOrder lists the execution providers the fallback constructors try
+// each model on, best first.
+func providerOrder() []hardware.Provider {
+	if probe, ok := probeProviderOnce(false); ok && probe.Best != "" {
+		return probe.Ranked()
+	}
+	// Nothing to go on: let model loading find out
+	return []hardware.Provider{hardware.ProviderCUDA, hardware.ProviderCPU}
+}
+
+// probeProviderOnce probes at most once per process unless refresh is set.
+// It reports false if there are no model files to probe with.
+func probeProviderOnce(refresh bool) (ProviderProbe, bool) {
+	probeMu.Lock()
+	defer probeMu.Unlock()
+	if probeResult != nil && !refresh {
+		return *probeResult, true
+	}
+
+	model, ok := probeModel()
+	if !ok {
+		logger.Debug("No model files to probe execution providers with")
+		return ProviderProbe{}, false
+	}
+	key := probeKey(model)
+	cachePath, err := probeCachePath()
+	if err != nil {
+		logger.Debug("Not caching the provider probe: %v", err)
+	}
+
+	if !refresh && cachePath != "" {
+		if probe, err := loadProbe(cachePath, key); err == nil {
+			logger.Info("Using cached provider probe from %s: %s", probe.ProbedAt.Format(time.DateOnly), probe)
+			probeResult = &probe
+			return probe, true
+		}
+	}
+
+	logger.Info("Probing execution providers with the %s model...", model)
+	probe := ProbeProviders(model, probeProviders)
+	probe.Key = key
+	logger.Info("Provider probe: %s", probe)
+	if cachePath != "" {
+		if err := saveProbe(cachePath, probe); err != nil {
+			logger.Warn("Failed to cache the provider probe: %v", err)
+		}
+	}
+	probeResult = &probe
+	return probe, true
+}
+
+// ProbeProviders loads model on each of providers, decodes the embedded clip
+// with it and picks the fastest that worked.
+func ProbeProviders(model hardware.Provider, providers []hardware.Provider) ProviderProbe {
+	probe := ProviderProbe{Version: probeCacheVersion, ProbedAt: time.Now(), Model: model}
+	samples := BytesToSamples(probeClip)
+	for _, p := range providers {
+		result := ProbeResult{Provider: p}
+		choice := ModelChoice{
+			Name:           "Probe",
+			Model:          model,
+			Provider:       p,
+			DecodingMethod: "greedy_search",
+			MaxActivePaths: 1,
+		}
+		e, err := NewEngineForChoice(choice, 0)
+		if err == nil {
+			result.RTF, err = measureRTF(e, samples, 16000)
+			e.Close()
+		}
+		if err != nil {
+			result.Error = err.Error()
+			logger.Debug("Provider %s failed the probe: %v", p, err)
+		}
+		probe.Results = append(probe.Results, result)
+	}
+	probe.Best = pickProvider(probe.Results)
+	return probe
+}
+
+// measureRTF returns how long e takes to decode samples, divided by the
+// duration of the audio. The first decode is not timed, since it includes
+// setup that WarmUp moves out of the way of captioning; of the probeRuns
+// after it, the fastest counts.
+func measureRTF(e engine.SpeechEngine, samples []float32, rate int) (float64, error) {
+	if _, err := timeDecode(e, samples, rate); err != nil {
+		return 0, err
+	}
+	var fastest time.Duration
+	for i := range probeRuns {
+		elapsed, err := timeDecode(e, samples, rate)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+	audio := time.Duration(len(samples)) * time.Second / time.Duration(rate)
+	return fastest.Seconds() / audio.Seconds(), nil
+}
+
+// timeDecode decodes samples with a new stream of e and returns how long it took.
+func timeDecode(e engine.SpeechEngine, samples []float32, rate int) (time.Duration, error) {
+	stream, err := e.NewStream()
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	start := time.Now()
+	if err := decodeAll(stream, samples, rate); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// decodeAll feeds samples to stream as one finished input and decodes it.
+func decodeAll(stream engine.Stream, samples []float32, rate int) error {
+	stream.AcceptWaveform(rate, samples)
+	stream.InputFinished()
+	for stream.IsReady() {
+		if err := stream.Decode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pickProvider returns the provider with the lowest RTF, preferring earlier
+// results unless a later one beats them by probeMargin.
+func pickProvider(results []ProbeResult) hardware.Provider {
+	var best *ProbeResult
+	for i, r := range results {
+		if r.Error != "" {
+			continue
+		}
+		if best == nil || r.RTF < best.RTF*probeMargin {
+			best = &results[i]
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.Provider
+}
+
+// probeModel returns the smallest model whose files are present.
+func probeModel() (model hardware.Provider, ok bool) {
+	defer func() {
+		// GetModelPaths panics if it can't locate the project root.
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	for _, m := range []hardware.Provider{hardware.ProviderCPU, hardware.ProviderNemotron} {
+		encoder, decoder, joiner, tokens := hardware.GetModelPaths(m)
+		if filesExist(encoder, decoder, joiner, tokens) {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+func filesExist(paths ...string) bool {
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// probeKey identifies what a probe result depends on: the machine, this
+// binary with its linked ONNX Runtime, and the model files. A result is
+// reused only while the key stays the same.
+func probeKey(model hardware.Provider) string {
+	parts := []string{runtime.GOOS, runtime.GOARCH, string(model)}
+	for _, p := range probeProviders {
+		parts = append(parts, string(p))
+	}
+	if exe, err := os.Executable(); err == nil {
+		parts = append(parts, fileStamp(exe))
+	}
+	encoder, decoder, joiner, _ := hardware.GetModelPaths(model)
+	for _, f := range []string{encoder, decoder, joiner} {
+		parts = append(parts, fileStamp(f))
+	}
+	return strings.Join(parts, "|")
+}
+
+func fileStamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	return fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().Unix())
+}
+
+func probeCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "livelylivecaptions", "provider_probe.json"), nil
+}
+
+// loadProbe reads a cached probe, failing if it was made for another key.
+func loadProbe(path, key string) (ProviderProbe, error) {
+	var probe ProviderProbe
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return probe, err
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return probe, err
+	}
+	if probe.Version != probeCacheVersion || probe.Key != key {
+		return probe, errors.New("cached provider probe is stale")
+	}
+	return probe, nil
+}
+
+func saveProbe(path string, probe ProviderProbe) error {
+	data, err := json.MarshalIndent(probe, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write then rename so a concurrent start never reads half a file.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (p ProviderProbe) String() string {
+	var parts []string
+	for _, r := range p.Results {
+		if r.Error != "" {
+			parts = append(parts, fmt.Sprintf("%s unavailable", r.Provider))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s RTF %.3f", r.Provider, r.RTF))
+		}
+	}
+	best := string(p.Best)
+	if best == "" {
+		best = "none"
+	}
+	return fmt.Sprintf("%s; best %s", strings.Join(parts, ", "), best)
+}
@@ -0,0 +1,146 @@
+//go:build ignore
+
+// probe_gen writes probe_16k.pcm, the second of audio the provider probe and
+// WarmUp decode. It is synthesized rather than recorded, so the binary carries
+// no one's voice, but it is built like speech: voiced syllables with a falling
+// pitch and moving formants, fricatives, a plosive and a short pause, at a
+// conversational level. The model decodes it much as it would a speaker, so
+// the probe times real decoding work and not a steady tone.
+//
+// Run it from this directory with: go run probe_gen.go
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+)
+
+const (
+	rate     = 16000
+	level    = 0.1 // RMS of the clip, -20 dBFS
+	smoothMs = 8   // How quickly formants and loudness move between segments
+)
+
+// segment is one sound of the clip.
+type segment struct {
+	ms         int
+	voice      float64    // Loudness of the glottal source
+	noise      float64    // Loudness of the fricative noise
+	formants   [3]float64 // F1-F3 in Hz
+	bandwidths [3]float64
+}
+
+var (
+	vowelO = [3]float64{500, 900, 2400}
+	vowelA = [3]float64{750, 1250, 2550}
+	vowelU = [3]float64{350, 950, 2300}
+	vowelE = [3]float64{480, 1850, 2550}
+	vowelI = [3]float64{300, 2250, 2950}
+	nasalM = [3]float64{250, 1100, 2200}
+	wide   = [3]float64{90, 110, 170}
+	narrow = [3]float64{60, 90, 150}
+)
+
+// script reads roughly "so much to say, it is".
+var script = []segment{
+	{ms: 30, formants: vowelO, bandwidths: wide},
+	{ms: 80, noise: 0.35, formants: vowelO, bandwidths: wide},   // s
+	{ms: 140, voice: 1, formants: vowelO, bandwidths: narrow},   // o
+	{ms: 50, voice: 0.35, formants: nasalM, bandwidths: wide},   // m
+	{ms: 120, voice: 1, formants: vowelA, bandwidths: narrow},   // a
+	{ms: 60, noise: 0.25, formants: vowelA, bandwidths: wide},   // ch
+	{ms: 25, formants: vowelU, bandwidths: wide},                // t closure
+	{ms: 15, noise: 0.5, formants: vowelU, bandwidths: wide},    // t burst
+	{ms: 100, voice: 0.9, formants: vowelU, bandwidths: narrow}, // u
+	{ms: 120, formants: vowelU, bandwidths: wide},               // pause
+	{ms: 70, noise: 0.35, formants: vowelE, bandwidths: wide},   // s
+	{ms: 110, voice: 1, formants: vowelE, bandwidths: narrow},   // ay
+	{ms: 40, voice: 0.8, formants: vowelI, bandwidths: narrow},  // i
+	{ms: 30, noise: 0.3, formants: vowelI, bandwidths: wide},    // s
+	{ms: 10, formants: vowelI, bandwidths: wide},
+}
+
+// resonator is a two-pole formant filter.
+type resonator struct{ y1, y2 float64 }
+
+func (r *resonator) process(x, freq, bandwidth float64) float64 {
+	c := -math.Exp(-2 * math.Pi * bandwidth / rate)
+	b := 2 * math.Exp(-math.Pi*bandwidth/rate) * math.Cos(2*math.Pi*freq/rate)
+	y := (1-b-c)*x + b*r.y1 + c*r.y2
+	r.y2, r.y1 = r.y1, y
+	return y
+}
+
+func main() {
+	rng := rand.New(rand.NewPCG(16, 1000))
+	total := 0
+	for _, s := range script {
+		total += s.ms * rate / 1000
+	}
+	if total != rate {
+		log.Fatalf("script lasts %d samples, want %d", total, rate)
+	}
+
+	alpha := 1 - math.Exp(-1000/(smoothMs*float64(rate)))
+	var (
+		out               []float64
+		voice, noise      float64
+		formants, bws     = script[0].formants, script[0].bandwidths
+		phase, src1, src2 float64
+		lastNoise         float64
+		voiced            [3]resonator
+		fricative         resonator
+	)
+	for _, s := range script {
+		for range s.ms * rate / 1000 {
+			n := len(out)
+			voice += (s.voice - voice) * alpha
+			noise += (s.noise - noise) * alpha
+			for i := range formants {
+				formants[i] += (s.formants[i] - formants[i]) * alpha
+				bws[i] += (s.bandwidths[i] - bws[i]) * alpha
+			}
+
+			// Pitch falls from 140 to 105 Hz over the clip, with a little jitter.
+			f0 := 140 - 35*float64(n)/rate + rng.NormFloat64()
+			phase += f0 / rate
+			pulse := 0.0
+			if phase >= 1 {
+				phase--
+				pulse = 1
+			}
+			// Two one-pole low-passes give the source a speech-like spectral tilt.
+			src1 += (pulse - src1) * 0.1
+			src2 += (src1 - src2) * 0.1
+			x := src2 * voice
+			for i := range voiced {
+				x = voiced[i].process(x, formants[i], bws[i])
+			}
+
+			// Fricatives are high-passed noise shaped by the upper formants.
+			white := rng.NormFloat64()
+			hiss := (white - lastNoise) * noise
+			lastNoise = white
+			y := hiss + fricative.process(hiss, formants[2]+1500, 600)
+
+			out = append(out, x+0.01*y)
+		}
+	}
+
+	var sum float64
+	for _, v := range out {
+		sum += v * v
+	}
+	gain := level / math.Sqrt(sum/float64(len(out)))
+	pcm := make([]byte, 2*len(out))
+	for i, v := range out {
+		v = math.Max(-1, math.Min(1, v*gain))
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(math.Round(v*32767))))
+	}
+	if err := os.WriteFile("probe_16k.pcm", pcm, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
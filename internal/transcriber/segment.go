@@ -0,0 +1,99 @@
+package transcriber
+
+import (
+	"livelylivecaptions/internal/types"
+	"time"
+)
+
+// segmentTracker assigns segment IDs and revision numbers to recognizer output.
+// A segment opens with its first non-empty partial and closes with a final or
+// a retraction; the next partial after that opens a new segment.
+type segmentTracker struct {
+	lastID     uint64 // ID of the most recently opened segment
+	open       bool   // Whether segment lastID is still receiving partials
+	revision   int    // Revision of the last event emitted for segment lastID
+	lastText   string // Text of the last event emitted for segment lastID
+	lastStable string // StableText of the last event emitted for segment lastID
+
+	start time.Time // Capture time of the first audio since the last segment closed
+	end   time.Time // Capture time of the end of the latest audio
+}
+
+// heard records that the audio captured between start and end was decoded, so
+// the events that follow cover it.
+func (s *segmentTracker) heard(start, end time.Time) {
+	if s.start.IsZero() {
+		s.start = start
+	}
+	s.end = end
+}
+
+// partial returns a partial event for text, of which stable is the settled
+// prefix (see Stabilizer). It reports false when text is empty or when neither
+// the text nor its stable prefix changed, since that would not change anything.
+func (s *segmentTracker) partial(text, stable, unstable string) (types.TranscriptionEvent, bool) {
+	if text == "" {
+		return types.TranscriptionEvent{}, false
+	}
+	if !s.open {
+		s.lastID++
+		s.open = true
+		s.revision = 0
+		s.lastText = ""
+		s.lastStable = ""
+	}
+	if text == s.lastText && stable == s.lastStable {
+		return types.TranscriptionEvent{}, false
+	}
+	ev := s.emit(types.EventPartial, text)
+	ev.StableText = stable
+	ev.UnstableText = unstable
+	s.lastStable = stable
+	return ev, true
+}
+
+// final closes the current segment with text. An endpoint that produced no text
+// retracts the segment if a partial was already shown, and reports false otherwise.
+func (s *segmentTracker) final(text string) (types.TranscriptionEvent, bool) {
+	if text == "" {
+		return s.retract()
+	}
+	defer s.closed()
+	if !s.open {
+		s.lastID++
+		s.revision = 0
+	}
+	s.open = false
+	ev := s.emit(types.EventFinal, text)
+	ev.StableText = text
+	return ev, true
+}
+
+// retract withdraws the open segment. It reports false if no segment is open.
+func (s *segmentTracker) retract() (types.TranscriptionEvent, bool) {
+	defer s.closed()
+	if !s.open {
+		return types.TranscriptionEvent{}, false
+	}
+	s.open = false
+	return s.emit(types.EventRetract, ""), true
+}
+
+// closed starts timing the next segment from the next audio heard.
+func (s *segmentTracker) closed() {
+	s.start = time.Time{}
+}
+
+func (s *segmentTracker) emit(kind types.EventKind, text string) types.TranscriptionEvent {
+	s.revision++
+	s.lastText = text
+	return types.TranscriptionEvent{
+		SegmentID: s.lastID,
+		Revision:  s.revision,
+		Kind:      kind,
+		Text:      text,
+		IsFinal:   kind == types.EventFinal,
+		StartTime: s.start,
+		EndTime:   s.end,
+	}
+}
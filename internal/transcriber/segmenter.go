@@ -0,0 +1,195 @@
+package transcriber
+
+import (
+	"livelylivecaptions/internal/dsp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// DefaultMaxSegmentDuration is how much audio a segment may span before it is split.
+	DefaultMaxSegmentDuration = 15 * time.Second
+	// DefaultMaxSegmentChars is how long a segment's text may grow before it is split.
+	DefaultMaxSegmentChars = 160
+	// segmentGrace is how long to wait for a pause once a limit is reached
+	// before splitting at a word boundary regardless.
+	segmentGrace = 1500 * time.Millisecond
+	// lowEnergyRMS is the chunk level below which audio counts as a pause.
+	lowEnergyRMS = 0.01
+	// replayWindow is how much of the latest audio a forced split decodes
+	// again on a fresh stream: enough to hold the word it held back.
+	replayWindow = time.Second
+	// gapWindow is the span over which the quietest point of that audio is
+	// measured, about the shortest pause between words.
+	gapWindow = 20 * time.Millisecond
+)
+
+// splitKind says whether and how the current segment should be cut.
+type splitKind int
+
+const (
+	splitNone  splitKind = iota
+	splitPause           // A quiet chunk arrived after a limit was reached; every word is complete
+	splitForce           // The grace period ran out; the last word may still be growing
+)
+
+// segmenter decides when a segment that never reaches an endpoint must be
+// finalized anyway, so a speaker who never pauses doesn't grow a single
+// partial without bound.
+type segmenter struct {
+	maxSamples   int // Zero disables the duration limit
+	maxChars     int // Zero disables the length limit
+	graceSamples int
+
+	sampleRate int // Rate the sample counts are measured at
+
+	samples   int // Samples accepted since the segment started
+	overSince int // Value of samples when a limit was first reached, or -1
+}
+
+func newSegmenter(maxDuration time.Duration, maxChars, sampleRate int) segmenter {
+	return segmenter{
+		maxSamples:   int(maxDuration.Seconds() * float64(sampleRate)),
+		maxChars:     maxChars,
+		graceSamples: int(segmentGrace.Seconds() * float64(sampleRate)),
+		sampleRate:   sampleRate,
+		overSince:    -1,
+	}
+}
+
+// check accounts for a chunk of audio at rate and the text decoded so far
+// and reports whether the segment should be split now.
+func (s *segmenter) check(text string, chunk []float32, rate int) splitKind {
+	s.samples += len(chunk) * s.sampleRate / rate
+
+	if s.overSince < 0 {
+		overDuration := s.maxSamples > 0 && s.samples >= s.maxSamples
+		overChars := s.maxChars > 0 && len(text) >= s.maxChars
+		if !overDuration && !overChars {
+			return splitNone
+		}
+		s.overSince = s.samples
+	}
+
+	// Prefer cutting where the speaker is quiet, so no word straddles the split.
+	if dsp.RMS(chunk) < lowEnergyRMS {
+		return splitPause
+	}
+	if s.samples-s.overSince >= s.graceSamples {
+		return splitForce
+	}
+	return splitNone
+}
+
+// reset starts counting a new segment.
+func (s *segmenter) reset() {
+	s.samples = 0
+	s.overSince = -1
+}
+
+// splitLastWord separates the final word from the rest of text. The last word
+// of a hypothesis may still be extended by audio that hasn't been decoded, so a
+// forced split leaves it in the next segment instead of finalizing it.
+func splitLastWord(text string) (head, tail string) {
+	words := strings.Fields(text)
+	if len(words) < 2 {
+		return strings.Join(words, " "), ""
+	}
+	return strings.Join(words[:len(words)-1], " "), words[len(words)-1]
+}
+
+// quietestPoint returns the start of the quietest window of samples, most
+// likely a pause between words. The first is returned on a tie.
+func quietestPoint(samples []float32, window int) int {
+	window = max(window, 1)
+	best, quietest := 0, -1.0
+	for i := 0; i+window <= len(samples); i += window {
+		if level := dsp.RMS(samples[i : i+window]); quietest < 0 || level < quietest {
+			best, quietest = i, level
+		}
+	}
+	return best
+}
+
+// overlap returns how many leading words of words repeat the end of
+// committed, the words a forced split finalized. The engine may decode them
+// differently the second time, spelled, split or merged another way, so they
+// are aligned rather than counted: a word, or two run together, scores how
+// alike it is to what it stands for, and a word on one side only scores -1.
+// Where several lengths score the same the shortest wins, so a new word is
+// repeated rather than dropped.
+func overlap(committed, words []string) int {
+	// score[i][j] is the best score of a suffix of committed[:i] against
+	// words[:j]; any number of committed words may be skipped for free.
+	score := make([][]float64, len(committed)+1)
+	for i := range score {
+		score[i] = make([]float64, len(words)+1)
+		for j := 1; j <= len(words); j++ {
+			if i == 0 {
+				score[i][j] = -float64(j)
+				continue
+			}
+			c, w := committed[i-1], words[j-1]
+			best := max(score[i-1][j-1]+wordSimilarity(c, w), score[i-1][j]-1, score[i][j-1]-1)
+			if i > 1 {
+				best = max(best, score[i-2][j-1]+wordSimilarity(committed[i-2]+c, w))
+			}
+			if j > 1 {
+				best = max(best, score[i-1][j-2]+wordSimilarity(c, words[j-2]+w))
+			}
+			score[i][j] = best
+		}
+	}
+	n, best := 0, 0.0
+	for j, s := range score[len(committed)] {
+		if s > best {
+			n, best = j, s
+		}
+	}
+	return n
+}
+
+// wordSimilarity scores two words from 1 when they are the same, ignoring
+// case and punctuation, down to -1 when every character differs.
+func wordSimilarity(a, b string) float64 {
+	x, y := wordRunes(a), wordRunes(b)
+	longest := max(len(x), len(y))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - 2*float64(editDistance(x, y))/float64(longest)
+}
+
+// wordRunes returns the letters and digits of word, lowercased.
+func wordRunes(word string) []rune {
+	var out []rune
+	for _, r := range strings.ToLower(word) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// editDistance returns the number of single-character insertions, deletions
+// and substitutions that turn a into b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range a {
+		cur[0] = i + 1
+		for j := range b {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+			cur[j+1] = min(prev[j]+cost, prev[j+1]+1, cur[j]+1)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,93 @@
+package transcriber
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultStableUpdates is how many consecutive partials a word must survive to be stable.
+	DefaultStableUpdates = 3
+	// DefaultStableAge is how long a word must stay unchanged to be stable.
+	DefaultStableAge = 600 * time.Millisecond
+)
+
+// wordState remembers when a word at a given position was first seen unchanged.
+type wordState struct {
+	text  string
+	since time.Time
+	seen  int
+}
+
+// Stabilizer splits partial hypotheses into a stable prefix and an unstable tail.
+// A word becomes stable once it, and every word before it, has stayed the same
+// for minUpdates consecutive partials or for minAge, whichever comes first.
+// The stable prefix only grows within a segment unless the recognizer rewrites it.
+type Stabilizer struct {
+	minUpdates int
+	minAge     time.Duration
+	words      []wordState
+	stable     int // Number of leading words currently reported as stable
+}
+
+// NewStabilizer creates a Stabilizer. If both thresholds are zero or negative,
+// every word is treated as stable immediately, which disables stabilization.
+func NewStabilizer(minUpdates int, minAge time.Duration) *Stabilizer {
+	return &Stabilizer{
+		minUpdates: minUpdates,
+		minAge:     minAge,
+	}
+}
+
+// Update records a new partial and returns its stable prefix and unstable tail,
+// each as space-separated words.
+func (s *Stabilizer) Update(text string, now time.Time) (stable, unstable string) {
+	words := strings.Fields(text)
+
+	// Carry over the history of the common prefix; everything after it is new.
+	next := make([]wordState, len(words))
+	common := 0
+	for common < len(words) && common < len(s.words) && s.words[common].text == words[common] {
+		common++
+	}
+	for i, w := range words {
+		if i < common {
+			next[i] = s.words[i]
+			next[i].seen++
+		} else {
+			next[i] = wordState{text: w, since: now, seen: 1}
+		}
+	}
+	s.words = next
+
+	disabled := s.minUpdates <= 0 && s.minAge <= 0
+	count := 0
+	for count < len(s.words) {
+		w := s.words[count]
+		if !disabled && !s.isStable(w, now) {
+			break
+		}
+		count++
+	}
+
+	// Words already reported as stable stay that way while the recognizer keeps them.
+	if s.stable > count && s.stable <= common {
+		count = s.stable
+	}
+	s.stable = count
+
+	return strings.Join(words[:count], " "), strings.Join(words[count:], " ")
+}
+
+func (s *Stabilizer) isStable(w wordState, now time.Time) bool {
+	if s.minUpdates > 0 && w.seen >= s.minUpdates {
+		return true
+	}
+	return s.minAge > 0 && now.Sub(w.since) >= s.minAge
+}
+
+// Reset forgets the current hypothesis. Call it when a segment ends.
+func (s *Stabilizer) Reset() {
+	s.words = nil
+	s.stable = 0
+}
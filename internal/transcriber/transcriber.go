@@ -1,258 +1,356 @@
 package transcriber
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"livelylivecaptions/internal/engine"
 	"livelylivecaptions/internal/hardware"
 	"livelylivecaptions/internal/logger" // Added import
+	"livelylivecaptions/internal/metrics"
 	"livelylivecaptions/internal/types"
-	"sync" // Import sync package
+	"strings"
+	"sync"
+	"time"
+)
 
-	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+const (
+	// tailPaddingSamples is the silence (0.5s at 16kHz) appended when input ends.
+	tailPaddingSamples = 8000
+	// flushSendTimeout bounds how long shutdown waits for someone to take the last event.
+	flushSendTimeout = 500 * time.Millisecond
+	// errorBuffer is how many errors Errors() holds before further ones are only logged.
+	errorBuffer = 8
 )
 
-// OnlineRecognizer defines the subset of Sherpa-ONNX methods used by Transcriber
-type OnlineRecognizer interface {
-	IsReady(s *sherpa.OnlineStream) bool
-	Decode(s *sherpa.OnlineStream)
-	GetResult(s *sherpa.OnlineStream) *sherpa.OnlineRecognizerResult
-	IsEndpoint(s *sherpa.OnlineStream) bool
-	Reset(s *sherpa.OnlineStream)
-}
+// inputSampleRate is the rate audio on InputChan is expected to have. Segment
+// limits are measured in samples at this rate, and frames without a rate are
+// assumed to use it.
+const inputSampleRate = 16000
 
 // Transcriber handles speech recognition
 type Transcriber struct {
-	recognizer OnlineRecognizer
-	stream     *sherpa.OnlineStream
-	InputChan  chan []byte
+	engine     engine.SpeechEngine
+	stream     engine.Stream
+	InputChan  chan types.AudioFrame
 	OutputChan chan types.TranscriptionEvent
-	QuitChan   chan struct{}
-	wg         sync.WaitGroup // Add WaitGroup for graceful shutdown
-}
-
-// NewTranscriberWithFallback attempts to initialize the transcriber with a hierarchy of models:
-// 1. Nemotron CUDA model (primary)
-// 2. Nemotron CPU model (fallback)
-// 3. Sherpa GPU model (fallback)
-// 4. Sherpa CPU model (final fallback)
-func NewTranscriberWithFallback() (tr *Transcriber, err error) {
-	logger.Info("Attempting to initialize with Nemotron CUDA model (primary)...")
-
-	// Try Nemotron CUDA model first
-	tr, err = NewNemotronTranscriberWithProvider(hardware.ProviderCUDA)
-	if err == nil {
-		logger.Info("Successfully initialized with Nemotron CUDA model")
-		return tr, nil
-	}
 
-	logger.Warn("Failed to initialize with Nemotron CUDA model: %v", err)
-	logger.Info("Attempting to initialize with Nemotron CPU model (fallback)...")
+	cancel    context.CancelFunc // Stops the processing goroutine; nil until Start
+	done      chan struct{}      // Closed once the processing goroutine has exited
+	errs      chan error         // Every error encountered, for Errors()
+	err       error              // Why processing stopped; read after done is closed
+	mu        sync.Mutex         // Guards cancel and closing
+	closing   bool               // Close was called, so cancellation is not an error
+	closeOnce sync.Once
+
+	segments   segmentTracker // Assigns segment IDs and revisions to results
+	stabilizer *Stabilizer    // Splits partials into stable and unstable words
+	segmenter  segmenter      // Forces a split when a segment runs too long
+	// committed holds the words the last forced split finalized. The stream
+	// starts over on the latest audio after one, so its result may begin by
+	// repeating some of them; currentText skips those.
+	committed []string
+	// recent is the latest audio given to the stream, at recentRate, for a
+	// forced split to replay.
+	recent     []float32
+	recentRate int
+
+	nextSeq    uint64 // Sequence number expected for the next frame
+	lostFrames uint64 // Frames missing from InputChan, judged by sequence gaps
+
+	metrics *metrics.Recorder
+}
 
-	// Fallback to Nemotron CPU model
-	tr, err = NewNemotronTranscriberWithProvider(hardware.ProviderCPU)
-	if err == nil {
-		logger.Info("Successfully initialized with Nemotron CPU model")
-		return tr, nil
+// NewTranscriberWithEngine creates a Transcriber that decodes with e.
+// The Transcriber takes ownership of e and closes it in Close.
+func NewTranscriberWithEngine(e engine.SpeechEngine) (*Transcriber, error) {
+	stream, err := e.NewStream()
+	if err != nil {
+		return nil, err
 	}
+	return &Transcriber{
+		engine:     e,
+		stream:     stream,
+		InputChan:  make(chan types.AudioFrame, 10), // Buffered to prevent blocking audio capture
+		OutputChan: make(chan types.TranscriptionEvent),
+		done:       make(chan struct{}),
+		errs:       make(chan error, errorBuffer),
+		stabilizer: NewStabilizer(DefaultStableUpdates, DefaultStableAge),
+		segmenter:  newSegmenter(DefaultMaxSegmentDuration, DefaultMaxSegmentChars, inputSampleRate),
+		metrics:    metrics.NewRecorder(),
+	}, nil
+}
 
-	logger.Warn("Failed to initialize with Nemotron CPU model: %v", err)
-	logger.Info("Attempting to initialize with Sherpa GPU model (fallback)...")
+// Engine returns the engine this Transcriber decodes with.
+func (t *Transcriber) Engine() engine.SpeechEngine {
+	return t.engine
+}
 
-	// Fallback to Sherpa GPU model
-	if hardware.DetectBestProvider() == hardware.ProviderCUDA {
-		tr, err = NewTranscriber(hardware.ProviderCUDA)
-		if err == nil {
-			logger.Info("Successfully initialized with Sherpa GPU model")
-			return tr, nil
-		}
-		
-		logger.Warn("Failed to initialize with Sherpa GPU model: %v", err)
-		logger.Info("Attempting to initialize with Sherpa CPU model (final fallback)...")
-	}
+// Metrics returns the latency and real-time-factor figures of this session.
+// Latency runs from the capture of the newest audio an event reflects until
+// the event is taken from OutputChan.
+func (t *Transcriber) Metrics() *metrics.Recorder {
+	return t.metrics
+}
 
-	// Final fallback to CPU model
-	tr, err = NewTranscriber(hardware.ProviderCPU)
+// WarmUp decodes a second of embedded audio through a throwaway stream, so
+// the slow first decode, with ONNX graph optimization and CUDA kernel loading,
+// happens now instead of on the first words spoken. Call it before Start. It
+// returns how long warming up took.
+func (t *Transcriber) WarmUp() (time.Duration, error) {
+	start := time.Now()
+	stream, err := t.engine.NewStream()
 	if err != nil {
-		logger.Error("Failed to initialize with any model: %v", err)
-		return nil, err
+		return 0, fmt.Errorf("warm-up: %w", err)
+	}
+	defer stream.Close()
+	if err := decodeAll(stream, BytesToSamples(probeClip), 16000); err != nil {
+		return time.Since(start), fmt.Errorf("warm-up: %w", err)
 	}
+	return time.Since(start), nil
+}
 
-	logger.Info("Successfully initialized with Sherpa CPU model")
-	return tr, nil
+// SetSegmentLimits changes how long a segment may run without an endpoint
+// before it is finalized anyway. It must be called before Start.
+// A zero value disables the corresponding limit.
+func (t *Transcriber) SetSegmentLimits(maxDuration time.Duration, maxChars int) {
+	t.segmenter = newSegmenter(maxDuration, maxChars, inputSampleRate)
 }
 
-// NewNemotronOnlyTranscriberWithFallback attempts to initialize the transcriber with Nemotron models only:
-// 1. Nemotron CUDA model (primary)
-// 2. Nemotron CPU model (fallback)
-// If both fail, it returns an error.
-func NewNemotronOnlyTranscriberWithFallback() (tr *Transcriber, err error) {
-	logger.Info("Attempting to initialize with Nemotron CUDA model (primary)...")
+// SetStabilization changes how long a word of a partial must stay unchanged
+// before it is reported as stable. It must be called before Start.
+// Passing zero for both disables stabilization.
+func (t *Transcriber) SetStabilization(minUpdates int, minAge time.Duration) {
+	t.stabilizer = NewStabilizer(minUpdates, minAge)
+}
 
-	// Try Nemotron CUDA model first
-	tr, err = NewNemotronTranscriberWithProvider(hardware.ProviderCUDA)
-	if err == nil {
-		logger.Info("Successfully initialized with Nemotron CUDA model")
-		return tr, nil
+// NewTranscriberWithFallback attempts to initialize the transcriber with a hierarchy of models:
+// 1. Nemotron model (primary)
+// 2. Sherpa model (fallback)
+// Each model is tried on every execution provider that worked in the
+// provider probe, fastest first; see DetectBestProvider.
+func NewTranscriberWithFallback() (*Transcriber, error) {
+	var choices []ModelChoice
+	for _, p := range providerOrder() {
+		choices = append(choices, NemotronChoice(p))
 	}
-
-	logger.Warn("Failed to initialize with Nemotron CUDA model: %v", err)
-	logger.Info("Attempting to initialize with Nemotron CPU model (fallback)...")
-
-	// Fallback to Nemotron CPU model
-	tr, err = NewNemotronTranscriberWithProvider(hardware.ProviderCPU)
-	if err == nil {
-		logger.Info("Successfully initialized with Nemotron CPU model")
-		return tr, nil
+	for _, p := range providerOrder() {
+		choices = append(choices, SherpaChoice(p))
 	}
+	return newTranscriberFromChoices(choices)
+}
 
-	logger.Warn("Failed to initialize with Nemotron CPU model: %v", err)
-	return nil, fmt.Errorf("failed to initialize with Nemotron CUDA or Nemotron CPU models")
+// NewNemotronOnlyTranscriberWithFallback attempts to initialize the transcriber
+// with the Nemotron model on each execution provider that worked in the
+// provider probe, fastest first. If all fail, it returns an error.
+func NewNemotronOnlyTranscriberWithFallback() (*Transcriber, error) {
+	var choices []ModelChoice
+	for _, p := range providerOrder() {
+		choices = append(choices, NemotronChoice(p))
+	}
+	return newTranscriberFromChoices(choices)
 }
 
+// NewSherpaOnlyTranscriberWithFallback attempts to initialize the transcriber
+// with the Sherpa June 2023 model on each execution provider that worked in
+// the provider probe, fastest first.
+func NewSherpaOnlyTranscriberWithFallback() (*Transcriber, error) {
+	var choices []ModelChoice
+	for _, p := range providerOrder() {
+		choices = append(choices, SpecificModelChoice(hardware.ProviderSherpaJune2023, p))
+	}
+	return newTranscriberFromChoices(choices)
+}
 
-// NewSherpaOnlyTranscriberWithFallback attempts to initialize the transcriber with Sherpa models only:
-// 1. Sherpa June 2023 GPU model (primary for Sherpa-only)
-// 2. Sherpa June 2023 CPU model (fallback for Sherpa-only)
-func NewSherpaOnlyTranscriberWithFallback() (tr *Transcriber, err error) {
-	logger.Info("Attempting to initialize with Sherpa June 2023 GPU model (primary for Sherpa-only)...")
+// NewTelephonyTranscriberWithFallback attempts to initialize the transcriber
+// with the 8 kHz telephony model on each execution provider that worked in
+// the provider probe, fastest first. It suits narrowband audio such as phone
+// calls, which wideband models hear as muffled.
+func NewTelephonyTranscriberWithFallback() (*Transcriber, error) {
+	var choices []ModelChoice
+	for _, p := range providerOrder() {
+		choices = append(choices, TelephonyChoice(p))
+	}
+	return newTranscriberFromChoices(choices)
+}
 
-	// Try Sherpa June 2023 model on GPU first
-	if hardware.DetectBestProvider() == hardware.ProviderCUDA {
-		tr, err = NewTranscriberWithSpecificModel(hardware.ProviderSherpaJune2023, "cuda")
+// newTranscriberFromChoices returns a Transcriber for the first of choices
+// that loads.
+func newTranscriberFromChoices(choices []ModelChoice) (*Transcriber, error) {
+	var errs []error
+	for _, c := range choices {
+		logger.Info("Attempting to initialize with %s model...", c)
+		tr, err := NewTranscriberWithChoice(c, 0)
 		if err == nil {
-			logger.Info("Successfully initialized with Sherpa June 2023 GPU model")
+			logger.Info("Successfully initialized with %s model", c)
 			return tr, nil
 		}
-		
-		logger.Warn("Failed to initialize with Sherpa June 2023 GPU model: %v", err)
-		logger.Info("Attempting to initialize with Sherpa June 2023 CPU model (fallback for Sherpa-only)...")
+		logger.Warn("Failed to initialize with %s model: %v", c, err)
+		errs = append(errs, fmt.Errorf("%s: %w", c, err))
 	}
+	return nil, fmt.Errorf("no model could be loaded: %w", errors.Join(errs...))
+}
 
-	// Fallback to Sherpa June 2023 model on CPU
-	tr, err = NewTranscriberWithSpecificModel(hardware.ProviderSherpaJune2023, "cpu")
-	if err != nil {
-		logger.Error("Failed to initialize with Sherpa June 2023 models: %v", err)
-		return nil, err
+// ModelChoice is one Sherpa-ONNX model and execution provider the fallback
+// constructors can pick.
+type ModelChoice struct {
+	Name           string            // Human-readable model name, e.g. "Nemotron"
+	Model          hardware.Provider // Which model files to load; see hardware.GetModelPaths
+	Provider       hardware.Provider // Execution provider: cpu or cuda
+	DecodingMethod string
+	MaxActivePaths int
+	SampleRate     int // Rate the model was trained on; 0 means 16000
+}
+
+func (c ModelChoice) String() string {
+	return fmt.Sprintf("%s (%s)", c.Name, c.Provider)
+}
+
+// NemotronChoice is the Nemotron model on provider p.
+func NemotronChoice(p hardware.Provider) ModelChoice {
+	return ModelChoice{
+		Name:           "Nemotron",
+		Model:          hardware.ProviderNemotron,
+		Provider:       p,               // Use the specified provider
+		DecodingMethod: "greedy_search", // Use greedy search for better performance
+		MaxActivePaths: 1,               // Only 1 path for greedy search
 	}
+}
 
-	logger.Info("Successfully initialized with Sherpa June 2023 CPU model")
-	return tr, nil
+// SherpaChoice is the streaming zipformer model registered for provider p.
+func SherpaChoice(p hardware.Provider) ModelChoice {
+	return ModelChoice{
+		Name:           "Sherpa",
+		Model:          p,
+		Provider:       p,
+		DecodingMethod: "modified_beam_search",
+		MaxActivePaths: 4,
+	}
 }
 
-// NewNemotronTranscriberWithProvider initializes the Sherpa-ONNX recognizer with the Nemotron model.
-func NewNemotronTranscriberWithProvider(p hardware.Provider) (tr *Transcriber, err error) {
-	// Defer a function to recover from panics, which can happen with CGO calls
-	// if libraries are missing or there's a hardware mismatch.
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("panic occurred during Nemotron model initialization with provider '%s': %v", p, r)
-		}
-	}()
+// TelephonySampleRate is the rate the telephony model was trained on.
+const TelephonySampleRate = 8000
 
-	encoderPath, decoderPath, joinerPath, tokensPath := hardware.GetModelPaths(hardware.ProviderNemotron)
-
-	// Configuration for the Nemotron model
-	config := sherpa.OnlineRecognizerConfig{
-		FeatConfig: sherpa.FeatureConfig{
-			SampleRate: 16000,
-			FeatureDim: 80,
-		},
-		ModelConfig: sherpa.OnlineModelConfig{
-			Transducer: sherpa.OnlineTransducerModelConfig{
-				Encoder: encoderPath,
-				Decoder: decoderPath,
-				Joiner:  joinerPath,
-			},
-			Tokens:     tokensPath,
-			NumThreads: 1,
-			Provider:   string(p), // Use the specified provider
-			Debug:      0,
-		},
-		DecodingMethod: "greedy_search", // Use greedy search for better performance
-		MaxActivePaths: 1, // Only 1 path for greedy search
-		EnableEndpoint: 1, // Enable endpoint detection
+// TelephonyChoice is the 8 kHz telephony model on provider p. Audio at other
+// rates is resampled to 8 kHz by the stream.
+func TelephonyChoice(p hardware.Provider) ModelChoice {
+	return ModelChoice{
+		Name:           "Telephony",
+		Model:          hardware.ProviderTelephony,
+		Provider:       p,
+		DecodingMethod: "modified_beam_search",
+		MaxActivePaths: 4,
+		SampleRate:     TelephonySampleRate,
 	}
+}
 
-	recognizer := sherpa.NewOnlineRecognizer(&config)
-	if recognizer == nil {
-		// This path is taken if Sherpa-ONNX returns nil without panicking.
-		return nil, fmt.Errorf("failed to create recognizer with Nemotron model (returned nil)")
+// SpecificModelChoice is modelProvider's model files on hardwareProvider.
+func SpecificModelChoice(modelProvider, hardwareProvider hardware.Provider) ModelChoice {
+	return ModelChoice{
+		Name:           string(modelProvider),
+		Model:          modelProvider,
+		Provider:       hardwareProvider, // Use the specified hardware provider
+		DecodingMethod: "modified_beam_search",
+		MaxActivePaths: 4,
 	}
+}
 
-	stream := sherpa.NewOnlineStream(recognizer)
-	if stream == nil {
-		// If stream creation fails, we must clean up the successfully created recognizer.
-		sherpa.DeleteOnlineRecognizer(recognizer)
-		return nil, fmt.Errorf("failed to create stream for Nemotron model")
+// FallbackChoices lists every model and provider the fallback constructors
+// may try: the models of NewTranscriberWithFallback followed by the June 2023
+// models of NewSherpaOnlyTranscriberWithFallback and the telephony model, each
+// on CUDA then CPU. The constructors themselves order providers by the
+// provider probe.
+func FallbackChoices() []ModelChoice {
+	return []ModelChoice{
+		NemotronChoice(hardware.ProviderCUDA),
+		NemotronChoice(hardware.ProviderCPU),
+		SherpaChoice(hardware.ProviderCUDA),
+		SherpaChoice(hardware.ProviderCPU),
+		SpecificModelChoice(hardware.ProviderSherpaJune2023, hardware.ProviderCUDA),
+		SpecificModelChoice(hardware.ProviderSherpaJune2023, hardware.ProviderCPU),
+		TelephonyChoice(hardware.ProviderCUDA),
+		TelephonyChoice(hardware.ProviderCPU),
 	}
+}
 
-	logger.Debug("Sherpa-ONNX transcriber resources allocated for Nemotron model")
+// NewTranscriberWithChoice loads the model c describes. numThreads is the
+// number of threads the model runs on; 0 means one.
+func NewTranscriberWithChoice(c ModelChoice, numThreads int) (*Transcriber, error) {
+	return newSherpaTranscriber(c.Model, c.sherpaConfig(numThreads))
+}
 
-	return &Transcriber{
-		recognizer: recognizer,
-		stream:     stream,
-		InputChan:  make(chan []byte, 10), // Buffered to prevent blocking audio capture
-		OutputChan: make(chan types.TranscriptionEvent),
-		QuitChan:   make(chan struct{}),
-	}, nil
+// NewEngineForChoice loads the model c describes without creating a
+// Transcriber, for callers that decode several inputs with one model.
+func NewEngineForChoice(c ModelChoice, numThreads int) (engine.SpeechEngine, error) {
+	e, err := newSherpaEngine(c.Model, c.sherpaConfig(numThreads))
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (c ModelChoice) sherpaConfig(numThreads int) engine.SherpaConfig {
+	return engine.SherpaConfig{
+		Name:           c.Name,
+		Provider:       string(c.Provider),
+		NumThreads:     numThreads,
+		DecodingMethod: c.DecodingMethod,
+		MaxActivePaths: c.MaxActivePaths,
+		SampleRate:     c.SampleRate,
+	}
+}
+
+// NewNemotronTranscriberWithProvider initializes the Sherpa-ONNX recognizer with the Nemotron model.
+func NewNemotronTranscriberWithProvider(p hardware.Provider) (*Transcriber, error) {
+	return NewTranscriberWithChoice(NemotronChoice(p), 0)
 }
 
 // NewTranscriber initializes the Sherpa-ONNX recognizer with hardware-specific configuration.
-// It includes a panic-recovery mechanism to handle CGO errors safely.
-func NewTranscriber(p hardware.Provider) (tr *Transcriber, err error) {
-	// Defer a function to recover from panics, which can happen with CGO calls
-	// if libraries are missing or there's a hardware mismatch.
+func NewTranscriber(p hardware.Provider) (*Transcriber, error) {
+	// Configuration for the streaming zipformer model
+	return NewTranscriberWithChoice(SherpaChoice(p), 0)
+}
+
+// NewWhisperHTTPTranscriber creates a Transcriber that sends utterances to an
+// OpenAI-compatible speech-to-text server instead of loading a model locally.
+func NewWhisperHTTPTranscriber(cfg engine.WhisperHTTPConfig) (*Transcriber, error) {
+	e, err := engine.NewWhisperHTTPEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Transcriber will use speech-to-text server: %s", e.Name())
+	return NewTranscriberWithEngine(e)
+}
+
+// newSherpaEngine fills in the model files registered for model and loads a
+// Sherpa-ONNX engine with cfg.
+func newSherpaEngine(model hardware.Provider, cfg engine.SherpaConfig) (e *engine.SherpaEngine, err error) {
+	// GetModelPaths panics if it can't locate the project root.
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("panic occurred during recognizer initialization with provider '%s': %v", p, r)
+			err = fmt.Errorf("panic occurred while locating %s model files: %v", cfg.Name, r)
 		}
 	}()
+	cfg.Encoder, cfg.Decoder, cfg.Joiner, cfg.Tokens = hardware.GetModelPaths(model)
+	return engine.NewSherpaEngine(cfg)
+}
 
-	encoderPath, decoderPath, joinerPath, tokensPath := hardware.GetModelPaths(p)
-
-	// Configuration for the streaming zipformer model
-	config := sherpa.OnlineRecognizerConfig{
-		FeatConfig: sherpa.FeatureConfig{
-			SampleRate: 16000,
-			FeatureDim: 80,
-		},
-		ModelConfig: sherpa.OnlineModelConfig{
-			Transducer: sherpa.OnlineTransducerModelConfig{
-				Encoder: encoderPath,
-				Decoder: decoderPath,
-				Joiner:  joinerPath,
-			},
-			Tokens:     tokensPath,
-			NumThreads: 1,
-			Provider:   string(p),
-			Debug:      0,
-		},
-		DecodingMethod: "modified_beam_search",
-		MaxActivePaths: 4,
-		EnableEndpoint: 1, // Enable endpoint detection
-	}
-
-	recognizer := sherpa.NewOnlineRecognizer(&config)
-	if recognizer == nil {
-		// This path is taken if Sherpa-ONNX returns nil without panicking.
-		return nil, fmt.Errorf("failed to create recognizer with provider %s (returned nil)", p)
+// newSherpaTranscriber creates a Transcriber backed by a Sherpa-ONNX engine
+// loading model's files with cfg.
+func newSherpaTranscriber(model hardware.Provider, cfg engine.SherpaConfig) (*Transcriber, error) {
+	e, err := newSherpaEngine(model, cfg)
+	if err != nil {
+		return nil, err
 	}
-
-	stream := sherpa.NewOnlineStream(recognizer)
-	if stream == nil {
+	tr, err := NewTranscriberWithEngine(e)
+	if err != nil {
 		// If stream creation fails, we must clean up the successfully created recognizer.
-		sherpa.DeleteOnlineRecognizer(recognizer)
-		return nil, fmt.Errorf("failed to create stream")
+		e.Close()
+		return nil, err
 	}
 
-	logger.Debug("Sherpa-ONNX transcriber resources allocated for provider: %s", p)
-
-	return &Transcriber{
-		recognizer: recognizer,
-		stream:     stream,
-		InputChan:  make(chan []byte, 10), // Buffered to prevent blocking audio capture
-		OutputChan: make(chan types.TranscriptionEvent),
-		QuitChan:   make(chan struct{}),
-	}, nil
+	logger.Debug("Sherpa-ONNX transcriber resources allocated for %s", e.Name())
+	return tr, nil
 }
 
 // BytesToSamples converts raw int16 LE bytes to float32 samples.
@@ -270,132 +368,333 @@ func BytesToSamples(audioData []byte) []float32 {
 	return samples
 }
 
-// Start begins processing audio from the input channel
-func (t *Transcriber) Start() {
-	t.wg.Add(1)
+// Start begins processing audio from the input channel.
+// Processing stops when InputChan is closed, ctx is cancelled or Close is
+// called. In every case whatever audio the engine still holds is decoded
+// and emitted as a final event before OutputChan closes, except that on
+// cancellation an engine waiting on a server gives up on it. Use Wait to
+// learn why processing stopped.
+func (t *Transcriber) Start(ctx context.Context) {
+	t.mu.Lock()
+	if t.cancel != nil || t.closing {
+		t.mu.Unlock()
+		return
+	}
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.mu.Unlock()
+
+	// A stream waiting on a server gives up once processing is cancelled, so
+	// the last flush can't hold up shutdown.
+	if s, ok := t.stream.(engine.Interrupter); ok {
+		context.AfterFunc(ctx, s.Interrupt)
+	}
+
 	go func() {
-		defer t.wg.Done()
+		defer close(t.done)
 		defer close(t.OutputChan)
+		t.err = t.run(ctx)
+		if t.err != nil {
+			logger.Error("Transcriber stopped: %v", t.err)
+		}
+	}()
+}
 
-		for {
-			select {
-			case <-t.QuitChan:
-				return
-			case audioData, ok := <-t.InputChan:
-				if !ok {
-					// InputChan was closed, exit loop
-					return
+// run is the processing loop. It returns the reason processing stopped, or nil
+// if input simply ended or Close was called.
+func (t *Transcriber) run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			// Nobody may be reading any more, so don't wait long to deliver the last words.
+			if event, ok, err := t.safely(t.flush); err != nil {
+				return err
+			} else if ok {
+				select {
+				case t.OutputChan <- event:
+				case <-time.After(flushSendTimeout):
+					logger.Warn("Dropped final transcription on shutdown: %q", event.Text)
 				}
-				samples := BytesToSamples(audioData)
-				if samples == nil {
-					continue
+			}
+			return t.stopReason(ctx)
+		case frame, ok := <-t.InputChan:
+			if !ok {
+				// InputChan was closed: end of input, deliver what's left and exit
+				event, ok, err := t.safely(t.flush)
+				if err != nil {
+					return err
 				}
-
-				// Accept samples
-				t.stream.AcceptWaveform(16000, samples)
-
-				// Decode
-				for t.recognizer.IsReady(t.stream) {
-					t.recognizer.Decode(t.stream)
+				if ok && !t.send(ctx, event) {
+					return t.stopReason(ctx)
 				}
+				return nil
+			}
+			if frame.Frames() == 0 {
+				frame.Release()
+				continue
+			}
 
-				// Get result
-				result := t.recognizer.GetResult(t.stream)
-
-				// Only send if there's text (partial or final)
-				if result != nil && len(result.Text) > 0 {
-					event := types.TranscriptionEvent{
-						Text: result.Text,
-					}
-
-					if t.recognizer.IsEndpoint(t.stream) {
-						t.recognizer.Reset(t.stream)
-						event.IsFinal = true
-					}
-
-					t.OutputChan <- event
-				}
+			event, ok, err := t.safely(func() (types.TranscriptionEvent, bool) {
+				return t.process(frame)
+			})
+			// Engines copy what they accept, so the samples can be reused.
+			frame.Release()
+			if err != nil {
+				return err
+			}
+			if ok && !t.send(ctx, event) {
+				return t.stopReason(ctx)
 			}
 		}
-	}()
+	}
 }
 
-// NewTranscriberWithSpecificModel creates a transcriber with a specific model provider and hardware provider
-func NewTranscriberWithSpecificModel(modelProvider hardware.Provider, hardwareProvider string) (tr *Transcriber, err error) {
-	// Defer a function to recover from panics, which can happen with CGO calls
-	// if libraries are missing or there's a hardware mismatch.
+// send delivers an event, giving up if ctx is cancelled first.
+func (t *Transcriber) send(ctx context.Context, event types.TranscriptionEvent) bool {
+	select {
+	case t.OutputChan <- event:
+		t.metrics.ObserveEvent(event, time.Now())
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// safely runs a decoding step and turns a panic, which is how failures inside
+// CGO calls surface, into an error.
+func (t *Transcriber) safely(step func() (types.TranscriptionEvent, bool)) (event types.TranscriptionEvent, ok bool, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("panic occurred during transcriber initialization with model provider '%s' and hardware provider '%s': %v", modelProvider, hardwareProvider, r)
+			err = fmt.Errorf("decoding failed: %v", r)
+			t.reportError(err)
 		}
 	}()
+	event, ok = step()
+	return event, ok, nil
+}
 
-	encoderPath, decoderPath, joinerPath, tokensPath := hardware.GetModelPaths(modelProvider)
+// reportError makes err available on Errors() without ever blocking decoding.
+func (t *Transcriber) reportError(err error) {
+	select {
+	case t.errs <- err:
+	default:
+		logger.Warn("Transcriber error not delivered, Errors() is full: %v", err)
+	}
+}
 
-	// Configuration for the selected model
-	config := sherpa.OnlineRecognizerConfig{
-		FeatConfig: sherpa.FeatureConfig{
-			SampleRate: 16000,
-			FeatureDim: 80,
-		},
-		ModelConfig: sherpa.OnlineModelConfig{
-			Transducer: sherpa.OnlineTransducerModelConfig{
-				Encoder: encoderPath,
-				Decoder: decoderPath,
-				Joiner:  joinerPath,
-			},
-			Tokens:     tokensPath,
-			NumThreads: 1,
-			Provider:   hardwareProvider, // Use the specified hardware provider
-			Debug:      0,
-		},
-		DecodingMethod: "modified_beam_search",
-		MaxActivePaths: 4,
-		EnableEndpoint: 1, // Enable endpoint detection
+// stopReason explains a cancelled context. Cancellation caused by Close is a
+// normal shutdown and yields nil.
+func (t *Transcriber) stopReason(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return nil
 	}
+	return context.Cause(ctx)
+}
 
-	recognizer := sherpa.NewOnlineRecognizer(&config)
-	if recognizer == nil {
-		// This path is taken if Sherpa-ONNX returns nil without panicking.
-		return nil, fmt.Errorf("failed to create recognizer with model provider %s and hardware provider %s (returned nil)", modelProvider, hardwareProvider)
+// Errors returns a channel that receives errors encountered while decoding.
+// Engine errors are reported here and decoding carries on; a panic inside the
+// engine stops processing and is also returned by Wait. The channel is never
+// closed; use Wait to learn when processing has stopped.
+func (t *Transcriber) Errors() <-chan error {
+	return t.errs
+}
+
+// Wait blocks until processing has stopped and returns the reason: nil if the
+// input ended or Close was called, otherwise the decoding error or the cause
+// of the context's cancellation.
+func (t *Transcriber) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// process feeds one frame of audio to the engine and returns the event it
+// produced, if any.
+func (t *Transcriber) process(frame types.AudioFrame) (types.TranscriptionEvent, bool) {
+	t.checkSequence(frame.Seq)
+	rate := frame.SampleRate
+	if rate == 0 {
+		rate = inputSampleRate
+	}
+	samples := frameSamples(frame)
+	if !frame.CaptureTime.IsZero() {
+		// Without a capture time there is nothing to time the segment by.
+		t.segments.heard(frame.CaptureTime, frame.CaptureTime.Add(frame.Duration()))
 	}
 
-	stream := sherpa.NewOnlineStream(recognizer)
-	if stream == nil {
-		// If stream creation fails, we must clean up the successfully created recognizer.
-		sherpa.DeleteOnlineRecognizer(recognizer)
-		return nil, fmt.Errorf("failed to create stream")
+	// Accept samples
+	decodeStart := time.Now()
+	t.stream.AcceptWaveform(rate, samples)
+	t.remember(rate, samples)
+
+	// Decode. An engine error is reported but doesn't stop captioning; the
+	// next chunk gets another chance.
+	err := t.decode()
+	t.metrics.ObserveDecode(frame.Duration(), time.Since(decodeStart))
+	if err != nil {
+		t.reportError(fmt.Errorf("decoding failed: %w", err))
+		return types.TranscriptionEvent{}, false
 	}
 
-	logger.Debug("Sherpa-ONNX transcriber resources allocated for model provider: %s, hardware provider: %s", modelProvider, hardwareProvider)
+	text := t.currentText()
 
-	return &Transcriber{
-		recognizer: recognizer,
-		stream:     stream,
-		InputChan:  make(chan []byte, 10), // Buffered to prevent blocking audio capture
-		OutputChan: make(chan types.TranscriptionEvent),
-		QuitChan:   make(chan struct{}),
-	}, nil
+	// An endpoint closes the current segment even if it produced no text,
+	// so a partial that turned out to be noise is retracted.
+	if t.stream.IsEndpoint() {
+		t.resetSegment()
+		return t.segments.final(text)
+	}
+
+	switch t.segmenter.check(text, samples, rate) {
+	case splitPause:
+		// The speaker is quiet, so every word is complete.
+		t.resetSegment()
+		return t.segments.final(text)
+	case splitForce:
+		head, tail := splitLastWord(text)
+		if tail != "" {
+			// Hold back the last word, which the next chunk of audio may
+			// still extend, and start a fresh stream on the latest audio so
+			// it is decoded whole and the hypothesis never outgrows a segment.
+			t.resetSegment()
+			t.replay(strings.Fields(head))
+			return t.segments.final(head)
+		}
+		// At most one word, still growing: nothing can be finalized yet, so
+		// the partial stays and the limits start counting again.
+		t.segmenter.reset()
+	}
+	stable, unstable := t.stabilizer.Update(text, time.Now())
+	return t.segments.partial(text, stable, unstable)
 }
 
-// Close releases resources and waits for the processing goroutine to finish.
-func (t *Transcriber) Close() {
-	// Signal the processing goroutine to stop by closing the quit channel.
-	close(t.QuitChan)
+// flush tells the engine no more audio is coming, decodes everything it
+// still buffers and returns the final event for the open segment, if any.
+func (t *Transcriber) flush() (types.TranscriptionEvent, bool) {
+	// Streaming models only emit a word once they have seen some audio after it,
+	// so pad with silence before marking the input finished.
+	t.stream.AcceptWaveform(inputSampleRate, make([]float32, tailPaddingSamples))
+	t.stream.InputFinished()
+	if err := t.decode(); err != nil {
+		// Still emit whatever was recognized before the failure.
+		t.reportError(fmt.Errorf("decoding trailing audio failed: %w", err))
+	}
 
-	// Wait for the goroutine to finish its current work and exit.
-	t.wg.Wait()
+	text := t.currentText()
+	t.resetSegment()
+	return t.segments.final(text)
+}
 
-	// Now that the goroutine is stopped, it's safe to release CGO resources.
-	if t.stream != nil {
-		sherpa.DeleteOnlineStream(t.stream)
+// checkSequence logs frames that went missing between capture and here.
+// Capture reports them as well, so this is only a debug message.
+func (t *Transcriber) checkSequence(seq uint64) {
+	if seq > t.nextSeq {
+		lost := seq - t.nextSeq
+		t.lostFrames += lost
+		logger.Debug("Lost %d audio frame(s) before frame %d (%d lost in total)", lost, seq, t.lostFrames)
+	}
+	// A sequence going backwards means the device restarted its count.
+	t.nextSeq = seq + 1
+}
+
+// frameSamples returns a frame's samples as mono, averaging the channels of
+// multi-channel audio. Mono frames are returned as they are, without copying.
+func frameSamples(frame types.AudioFrame) []float32 {
+	samples := frame.Samples
+	if frame.Channels <= 1 {
+		return samples
+	}
+	mono := make([]float32, len(samples)/frame.Channels)
+	for i := range mono {
+		var sum float32
+		for _, v := range samples[i*frame.Channels : (i+1)*frame.Channels] {
+			sum += v
+		}
+		mono[i] = sum / float32(frame.Channels)
 	}
-	if t.recognizer != nil {
-		// If it's the real recognizer, delete it using the Sherpa-ONNX deleter.
-		// Mocks won't need this step.
-		if r, ok := t.recognizer.(*sherpa.OnlineRecognizer); ok {
-			sherpa.DeleteOnlineRecognizer(r)
+	return mono
+}
+
+// decode runs the engine over all queued audio.
+func (t *Transcriber) decode() error {
+	for t.stream.IsReady() {
+		if err := t.stream.Decode(); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// currentText returns the engine's result minus any words a forced split
+// already finalized.
+func (t *Transcriber) currentText() string {
+	text := t.stream.Result()
+	if len(t.committed) > 0 {
+		words := strings.Fields(text)
+		text = strings.Join(words[overlap(t.committed, words):], " ")
+	}
+	return text
+}
+
+// remember keeps the last replayWindow of audio given to the stream.
+func (t *Transcriber) remember(rate int, samples []float32) {
+	if rate != t.recentRate {
+		t.recent, t.recentRate = t.recent[:0], rate
+	}
+	t.recent = append(t.recent, samples...)
+	// Trim only once twice the window is held, so most frames copy nothing.
+	if keep := int(replayWindow.Seconds() * float64(rate)); len(t.recent) > 2*keep {
+		t.recent = append(t.recent[:0], t.recent[len(t.recent)-keep:]...)
+	}
+}
+
+// replay feeds a freshly reset stream the remembered audio from its quietest
+// point on, most likely the pause before the word a forced split held back.
+// That audio may also hold the end of committed, the words the split
+// finalized, which currentText then skips.
+func (t *Transcriber) replay(committed []string) {
+	t.committed = committed
+	keep := int(replayWindow.Seconds() * float64(t.recentRate))
+	audio := t.recent[max(len(t.recent)-keep, 0):]
+	from := quietestPoint(audio, int(gapWindow.Seconds()*float64(t.recentRate)))
+	t.stream.AcceptWaveform(t.recentRate, audio[from:])
+}
+
+// resetSegment clears all per-segment state, including the engine's.
+func (t *Transcriber) resetSegment() {
+	t.stream.Reset()
+	t.stabilizer.Reset()
+	t.segmenter.reset()
+	t.committed = nil
 }
 
+// NewTranscriberWithSpecificModel creates a transcriber with a specific model provider and hardware provider
+func NewTranscriberWithSpecificModel(modelProvider hardware.Provider, hardwareProvider string) (*Transcriber, error) {
+	// Configuration for the selected model
+	return NewTranscriberWithChoice(SpecificModelChoice(modelProvider, hardware.Provider(hardwareProvider)), 0)
+}
+
+// Close stops processing, waits for the processing goroutine to finish and
+// releases resources. It is safe to call more than once, and without Start.
+func (t *Transcriber) Close() {
+	t.closeOnce.Do(func() {
+		t.mu.Lock()
+		t.closing = true
+		cancel := t.cancel
+		t.mu.Unlock()
+
+		if cancel != nil {
+			// Signal the processing goroutine to stop and wait for it to exit.
+			cancel()
+			<-t.done
+		} else {
+			// Never started, so there is no goroutine to close these.
+			close(t.OutputChan)
+			close(t.done)
+		}
+
+		// Now that the goroutine is stopped, it's safe to release engine resources.
+		t.stream.Close()
+		t.engine.Close()
+	})
+}
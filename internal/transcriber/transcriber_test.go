@@ -1,8 +1,18 @@
 package transcriber
 
 import (
+	"context"
+	"errors"
+	"livelylivecaptions/internal/dsp"
+	"livelylivecaptions/internal/engine"
+	"livelylivecaptions/internal/hardware"
+	"livelylivecaptions/internal/types"
+	"math"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBytesToSamples(t *testing.T) {
@@ -52,3 +62,671 @@ func TestBytesToSamples(t *testing.T) {
 		})
 	}
 }
+
+func TestSegmentTracker(t *testing.T) {
+	var s segmentTracker
+
+	if _, ok := s.partial("", "", ""); ok {
+		t.Fatal("empty partial should not produce an event")
+	}
+
+	ev, ok := s.partial("hello", "", "hello")
+	if !ok || ev.SegmentID != 1 || ev.Revision != 1 || ev.Kind != types.EventPartial {
+		t.Fatalf("first partial = %+v, %v", ev, ok)
+	}
+	if _, ok := s.partial("hello", "", "hello"); ok {
+		t.Error("unchanged partial should not produce an event")
+	}
+	ev, _ = s.partial("hello world", "hello", "world")
+	if ev.SegmentID != 1 || ev.Revision != 2 || ev.StableText != "hello" || ev.UnstableText != "world" {
+		t.Errorf("second partial = %+v, want segment 1 revision 2", ev)
+	}
+	// The same text with a longer stable prefix is still news.
+	if ev, ok = s.partial("hello world", "hello world", ""); !ok || ev.Revision != 3 {
+		t.Errorf("partial with grown stable prefix = %+v, %v", ev, ok)
+	}
+
+	ev, ok = s.final("hello world")
+	if !ok || ev.SegmentID != 1 || ev.Revision != 4 || ev.Kind != types.EventFinal || !ev.IsFinal || ev.StableText != "hello world" {
+		t.Fatalf("final = %+v, %v", ev, ok)
+	}
+
+	// The next partial opens a new segment.
+	ev, _ = s.partial("uh", "", "uh")
+	if ev.SegmentID != 2 || ev.Revision != 1 {
+		t.Errorf("partial after final = %+v, want segment 2 revision 1", ev)
+	}
+
+	// An endpoint with no text retracts the segment that was shown.
+	ev, ok = s.final("")
+	if !ok || ev.SegmentID != 2 || ev.Kind != types.EventRetract {
+		t.Errorf("empty final = %+v, %v, want retract of segment 2", ev, ok)
+	}
+	if _, ok := s.final(""); ok {
+		t.Error("empty final with no open segment should not produce an event")
+	}
+
+	// A final without any preceding partial still gets its own segment.
+	ev, _ = s.final("yes")
+	if ev.SegmentID != 3 || ev.Revision != 1 || ev.Kind != types.EventFinal {
+		t.Errorf("final without partial = %+v, want segment 3 revision 1", ev)
+	}
+}
+
+func TestStabilizer(t *testing.T) {
+	start := time.Unix(0, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	s := NewStabilizer(3, 500*time.Millisecond)
+
+	steps := []struct {
+		ms               int
+		text             string
+		stable, unstable string
+	}{
+		{0, "the cat", "", "the cat"},
+		{100, "the cat sat", "", "the cat sat"},
+		// "the" and "cat" have now been seen three times.
+		{200, "the cat sat on", "the cat", "sat on"},
+		// The recognizer rewrites the tail; the stable prefix is kept.
+		{300, "the cat sad", "the cat", "sad"},
+		// Rewriting a stable word takes the prefix back to what is actually settled.
+		{350, "a cat sad", "", "a cat sad"},
+		// After 500ms unchanged, words are stable even without enough updates.
+		{850, "a cat sad", "a cat sad", ""},
+	}
+	for _, st := range steps {
+		stable, unstable := s.Update(st.text, at(st.ms))
+		if stable != st.stable || unstable != st.unstable {
+			t.Errorf("at %dms Update(%q) = (%q, %q), want (%q, %q)", st.ms, st.text, stable, unstable, st.stable, st.unstable)
+		}
+	}
+
+	s.Reset()
+	if stable, _ := s.Update("a cat", at(900)); stable != "" {
+		t.Errorf("after Reset stable = %q, want empty", stable)
+	}
+}
+
+func TestStabilizerDisabled(t *testing.T) {
+	s := NewStabilizer(0, 0)
+	stable, unstable := s.Update("all of it", time.Now())
+	if stable != "all of it" || unstable != "" {
+		t.Errorf("disabled stabilizer = (%q, %q), want everything stable", stable, unstable)
+	}
+}
+
+func TestSegmenter(t *testing.T) {
+	const rate = 1000 // One sample per millisecond keeps the arithmetic readable
+	loud := make([]float32, 500)
+	for i := range loud {
+		loud[i] = 0.5
+	}
+	quiet := make([]float32, 500)
+
+	// Duration limit: nothing happens until 2s, then the first quiet chunk splits.
+	s := newSegmenter(2*time.Second, 0, rate)
+	for i := 0; i < 4; i++ {
+		if got := s.check("words", loud, rate); got != splitNone {
+			t.Fatalf("chunk %d before limit: got split %d", i, got)
+		}
+	}
+	if got := s.check("words", quiet, rate); got != splitPause {
+		t.Errorf("quiet chunk after limit: got %d, want splitPause", got)
+	}
+
+	// With no pause, the split is forced once the 1.5s grace period runs out.
+	s.reset()
+	var got splitKind
+	chunks := 0
+	for got == splitNone && chunks < 20 {
+		got = s.check("words", loud, rate)
+		chunks++
+	}
+	if got != splitForce || chunks != 7 {
+		t.Errorf("continuous speech: got split %d after %d chunks, want splitForce after 7", got, chunks)
+	}
+
+	// Character limit.
+	s = newSegmenter(0, 10, rate)
+	if got := s.check("short", loud, rate); got != splitNone {
+		t.Errorf("short text: got %d", got)
+	}
+	if got := s.check("this is long enough", quiet, rate); got != splitPause {
+		t.Errorf("long text at a pause: got %d, want splitPause", got)
+	}
+
+	// Audio at half the rate lasts twice as long per sample.
+	s = newSegmenter(2*time.Second, 0, rate)
+	if got := s.check("words", loud, rate/2); got != splitNone {
+		t.Errorf("1s at half rate: got %d", got)
+	}
+	if got := s.check("words", quiet, rate/2); got != splitPause {
+		t.Errorf("2s at half rate: got %d, want splitPause", got)
+	}
+}
+
+func TestSplitLastWord(t *testing.T) {
+	tests := []struct{ text, head, tail string }{
+		{"", "", ""},
+		{"one", "one", ""},
+		{" one two  three", "one two", "three"},
+	}
+	for _, tt := range tests {
+		head, tail := splitLastWord(tt.text)
+		if head != tt.head || tail != tt.tail {
+			t.Errorf("splitLastWord(%q) = (%q, %q), want (%q, %q)", tt.text, head, tail, tt.head, tt.tail)
+		}
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	committed := strings.Fields("one two three four")
+	tests := []struct {
+		words string
+		want  int
+	}{
+		{"", 0},
+		{"one two three four five six", 4},
+		{"three four five", 2},
+		{"four five", 1},
+		{"five six", 0},
+		{"Four, five", 1},
+		{"for five", 1},
+		{"ree four five", 2},
+		{"one two threefour five", 3},
+	}
+	for _, tt := range tests {
+		if got := overlap(committed, strings.Fields(tt.words)); got != tt.want {
+			t.Errorf("overlap(%q) = %d, want %d", tt.words, got, tt.want)
+		}
+	}
+	if got := overlap(nil, strings.Fields("one two")); got != 0 {
+		t.Errorf("overlap with nothing committed = %d, want 0", got)
+	}
+}
+
+func TestQuietestPoint(t *testing.T) {
+	samples := make([]float32, 100)
+	for i := range samples {
+		samples[i] = 0.5
+	}
+	for i := 60; i < 70; i++ {
+		samples[i] = 0.01
+	}
+	if got := quietestPoint(samples, 10); got != 60 {
+		t.Errorf("quietestPoint() = %d, want 60", got)
+	}
+	if got := quietestPoint(samples[:5], 10); got != 0 {
+		t.Errorf("quietestPoint() of less than a window = %d, want 0", got)
+	}
+}
+
+func TestCloseIsIdempotent(t *testing.T) {
+	e := engine.NewFakeEngine()
+	tr, err := NewTranscriberWithEngine(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Close()
+	tr.Close()
+
+	if err := tr.Wait(); err != nil {
+		t.Errorf("Wait() after Close without Start = %v, want nil", err)
+	}
+	if _, ok := <-tr.OutputChan; ok {
+		t.Error("OutputChan should be closed after Close")
+	}
+	if !e.Released() {
+		t.Error("Close should release the engine and its stream")
+	}
+	// Starting a closed transcriber must not resurrect it.
+	tr.Start(context.Background())
+	if err := tr.Wait(); err != nil {
+		t.Errorf("Wait() after Start on a closed transcriber = %v, want nil", err)
+	}
+}
+
+// chunk is 100ms of 16kHz int16 audio at a constant level.
+func chunk(level int16) []byte {
+	buf := make([]byte, 3200)
+	for i := 0; i < len(buf); i += 2 {
+		buf[i] = byte(level)
+		buf[i+1] = byte(level >> 8)
+	}
+	return buf
+}
+
+// frame wraps a chunk as the frame with sequence number seq, captured
+// seq*100ms after start.
+func frame(start time.Time, seq uint64, data []byte) types.AudioFrame {
+	return types.AudioFrame{
+		Seq:         seq,
+		CaptureTime: start.Add(time.Duration(seq) * 100 * time.Millisecond),
+		SampleRate:  16000,
+		Channels:    1,
+		Samples:     BytesToSamples(data),
+	}
+}
+
+// runScript feeds n loud chunks through a Transcriber backed by a FakeEngine
+// playing script, closes the input and returns every event produced.
+func runScript(t *testing.T, n int, configure func(*Transcriber), script ...engine.FakeStep) []types.TranscriptionEvent {
+	t.Helper()
+	tr, err := NewTranscriberWithEngine(engine.NewFakeEngine(script...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	tr.SetStabilization(0, 0)
+	if configure != nil {
+		configure(tr)
+	}
+
+	go func() {
+		defer close(tr.InputChan)
+		for i := 0; i < n; i++ {
+			tr.InputChan <- frame(scriptStart, uint64(i), chunk(8000))
+		}
+	}()
+	tr.Start(context.Background())
+
+	var events []types.TranscriptionEvent
+	for ev := range tr.OutputChan {
+		events = append(events, ev)
+	}
+	if err := tr.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	return events
+}
+
+// scriptStart is the capture time of the first frame runScript sends.
+var scriptStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestTranscriberSegments(t *testing.T) {
+	events := runScript(t, 4, nil,
+		engine.FakeStep{At: 1600, Text: "hello"},
+		engine.FakeStep{At: 3200, Text: "hello world", Endpoint: true},
+		engine.FakeStep{At: 4800, Text: "bye"},
+	)
+
+	want := []struct {
+		id   uint64
+		kind types.EventKind
+		text string
+	}{
+		{1, types.EventPartial, "hello"},
+		{1, types.EventFinal, "hello world"},
+		{2, types.EventPartial, "bye"},
+		// The open segment is flushed as a final when input ends.
+		{2, types.EventFinal, "bye"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		if ev := events[i]; ev.SegmentID != w.id || ev.Kind != w.kind || ev.Text != w.text {
+			t.Errorf("event %d = %+v, want segment %d %s %q", i, ev, w.id, w.kind, w.text)
+		}
+	}
+}
+
+func TestTranscriberFlushDecodesHeldBackWords(t *testing.T) {
+	events := runScript(t, 2, nil,
+		engine.FakeStep{At: 1600, Text: "the last"},
+		engine.FakeStep{At: -1, Text: "the last word"},
+	)
+	last := events[len(events)-1]
+	if last.Kind != types.EventFinal || last.Text != "the last word" {
+		t.Errorf("last event = %+v, want final %q", last, "the last word")
+	}
+}
+
+func TestTranscriberReportsEngineErrors(t *testing.T) {
+	tr, err := NewTranscriberWithEngine(engine.NewFakeEngine(
+		engine.FakeStep{At: 1600, Text: "still", Err: errors.New("server unavailable")},
+		engine.FakeStep{At: 3200, Text: "still here"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	tr.SetStabilization(0, 0)
+	tr.InputChan <- frame(scriptStart, 0, chunk(8000))
+	tr.InputChan <- frame(scriptStart, 1, chunk(8000))
+	close(tr.InputChan)
+	tr.Start(context.Background())
+
+	var texts []string
+	for ev := range tr.OutputChan {
+		texts = append(texts, ev.Text)
+	}
+	if err := tr.Wait(); err != nil {
+		t.Errorf("an engine error should not stop the transcriber, Wait() = %v", err)
+	}
+	select {
+	case err := <-tr.Errors():
+		if !strings.Contains(err.Error(), "server unavailable") {
+			t.Errorf("Errors() = %v", err)
+		}
+	default:
+		t.Error("the engine error was not reported on Errors()")
+	}
+	if got := strings.Join(texts, "|"); got != "still here|still here" {
+		t.Errorf("events = %q, want the partial and final after the error", got)
+	}
+}
+
+func TestTranscriberForcedSplitKeepsWords(t *testing.T) {
+	events := runScript(t, 4, func(tr *Transcriber) {
+		tr.SetSegmentLimits(0, 10)
+		tr.segmenter.graceSamples = 1600
+	},
+		engine.FakeStep{At: 1600, Text: "one two three four"},
+		engine.FakeStep{At: 3200, Text: "one two three four five"},
+		engine.FakeStep{At: 4800, Text: "one two three four five six"},
+	)
+
+	var finals []string
+	for _, ev := range events {
+		if ev.Kind == types.EventFinal {
+			finals = append(finals, ev.Text)
+		}
+	}
+	// The fresh stream decodes the replayed audio again; the words it
+	// repeats are skipped and the held-back word is not lost.
+	if got := strings.Join(finals, "|"); got != "one two three four|five six" {
+		t.Errorf("finals = %q", got)
+	}
+}
+
+func TestTranscriberForcedSplitRewrittenWords(t *testing.T) {
+	events := runScript(t, 4, func(tr *Transcriber) {
+		tr.SetSegmentLimits(0, 20)
+		tr.segmenter.graceSamples = 1600
+	},
+		engine.FakeStep{At: 1600, Text: "we went there some times"},
+		engine.FakeStep{At: 3200, Text: "we went there some times when"},
+		// Decoded again, two finalized words come back as one.
+		engine.FakeStep{At: 4800, Text: "we went there sometimes when it rained"},
+	)
+
+	var finals []string
+	for _, ev := range events {
+		if ev.Kind == types.EventFinal {
+			finals = append(finals, ev.Text)
+		}
+	}
+	if got := strings.Join(finals, "|"); got != "we went there some times|when it rained" {
+		t.Errorf("finals = %q", got)
+	}
+}
+
+func TestTranscriberForcedSplitSingleWord(t *testing.T) {
+	events := runScript(t, 4, func(tr *Transcriber) {
+		tr.SetSegmentLimits(0, 10)
+		tr.segmenter.graceSamples = 1600
+	},
+		engine.FakeStep{At: 1600, Text: "antidisestablishment"},
+		engine.FakeStep{At: 4800, Text: "antidisestablishmentarianism"},
+	)
+
+	var got []string
+	for _, ev := range events {
+		got = append(got, ev.Kind.String()+" "+ev.Text)
+	}
+	// A word that is still growing is never finalized or retracted early.
+	want := "partial antidisestablishment|partial antidisestablishmentarianism|final antidisestablishmentarianism"
+	if strings.Join(got, "|") != want {
+		t.Errorf("events = %q, want %q", strings.Join(got, "|"), want)
+	}
+}
+
+func TestTranscriberTimestamps(t *testing.T) {
+	events := runScript(t, 4, nil,
+		engine.FakeStep{At: 3200, Text: "hello", Endpoint: true},
+		engine.FakeStep{At: 6400, Text: "again"},
+	)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(events), events)
+	}
+	ms := func(n int) time.Time { return scriptStart.Add(time.Duration(n) * time.Millisecond) }
+	want := []struct{ start, end time.Time }{
+		{ms(0), ms(200)},   // final for the first two frames
+		{ms(200), ms(400)}, // partial of the next segment
+		{ms(200), ms(400)}, // and its final when input ends
+	}
+	for i, w := range want {
+		if ev := events[i]; !ev.StartTime.Equal(w.start) || !ev.EndTime.Equal(w.end) {
+			t.Errorf("event %d %q spans %v-%v, want %v-%v", i, ev.Text,
+				ev.StartTime.Sub(scriptStart), ev.EndTime.Sub(scriptStart),
+				w.start.Sub(scriptStart), w.end.Sub(scriptStart))
+		}
+	}
+}
+
+func TestTranscriberCountsLostFrames(t *testing.T) {
+	tr, err := NewTranscriberWithEngine(engine.NewFakeEngine())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	for _, seq := range []uint64{0, 1, 4, 5, 9} {
+		tr.InputChan <- frame(scriptStart, seq, chunk(8000))
+	}
+	close(tr.InputChan)
+	tr.Start(context.Background())
+	for range tr.OutputChan {
+	}
+	if tr.lostFrames != 5 {
+		t.Errorf("lostFrames = %d, want 5", tr.lostFrames)
+	}
+}
+
+func TestFrameSamplesDownmixesStereo(t *testing.T) {
+	// Left at 0.5, right at -0.25, for two sample frames.
+	samples := []float32{0.5, -0.25, 0.5, -0.25}
+	got := frameSamples(types.AudioFrame{SampleRate: 16000, Channels: 2, Samples: samples})
+	if len(got) != 2 || got[0] != 0.125 || got[1] != 0.125 {
+		t.Errorf("frameSamples() = %v, want [0.125 0.125]", got)
+	}
+}
+
+func TestTranscriberRecordsMetrics(t *testing.T) {
+	tr, err := NewTranscriberWithEngine(engine.NewFakeEngine(
+		engine.FakeStep{At: 1600, Text: "hello"},
+		engine.FakeStep{At: 3200, Text: "hello world", Endpoint: true},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	tr.SetStabilization(0, 0)
+	captured := time.Now().Add(-time.Second)
+	tr.InputChan <- frame(captured, 0, chunk(8000))
+	tr.InputChan <- frame(captured, 1, chunk(8000))
+	close(tr.InputChan)
+	tr.Start(context.Background())
+	for range tr.OutputChan {
+	}
+
+	m := tr.Metrics().Snapshot()
+	if m.Partials != 1 || m.Finals != 1 {
+		t.Errorf("measured %d partials and %d finals, want 1 each", m.Partials, m.Finals)
+	}
+	if m.Audio != 200*time.Millisecond {
+		t.Errorf("decoded audio = %v, want 200ms", m.Audio)
+	}
+	// The audio was captured about a second ago, so events can't be faster.
+	if m.FinalP50 < 700*time.Millisecond {
+		t.Errorf("final latency p50 = %v, want about 800ms", m.FinalP50)
+	}
+}
+
+func TestTranscriberFramesWithoutCaptureTime(t *testing.T) {
+	tr, err := NewTranscriberWithEngine(engine.NewFakeEngine(
+		engine.FakeStep{At: 1600, Text: "hello"},
+		engine.FakeStep{At: 3200, Text: "hello world", Endpoint: true},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	tr.SetStabilization(0, 0)
+	for seq := range uint64(2) {
+		f := frame(scriptStart, seq, chunk(8000))
+		f.CaptureTime = time.Time{}
+		tr.InputChan <- f
+	}
+	close(tr.InputChan)
+	tr.Start(context.Background())
+
+	n := 0
+	for ev := range tr.OutputChan {
+		n++
+		if !ev.StartTime.IsZero() || !ev.EndTime.IsZero() {
+			t.Errorf("event %q spans %v-%v, want no times", ev.Text, ev.StartTime, ev.EndTime)
+		}
+	}
+	if n != 2 {
+		t.Errorf("got %d events, want a partial and a final", n)
+	}
+	// There is no capture time to measure latency from.
+	if m := tr.Metrics().Snapshot(); m.Partials != 0 || m.Finals != 0 || m.FinalP95 != 0 {
+		t.Errorf("metrics = %+v, want no latency samples", m)
+	}
+}
+
+func TestPickProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []ProbeResult
+		want    hardware.Provider
+	}{
+		{"none worked", []ProbeResult{{Provider: hardware.ProviderCPU, Error: "x"}}, ""},
+		{"only cuda", []ProbeResult{{Provider: hardware.ProviderCPU, Error: "x"}, {Provider: hardware.ProviderCUDA, RTF: 0.5}}, hardware.ProviderCUDA},
+		{"cuda faster", []ProbeResult{{Provider: hardware.ProviderCPU, RTF: 0.4}, {Provider: hardware.ProviderCUDA, RTF: 0.05}}, hardware.ProviderCUDA},
+		// A "cuda" that runs on the CPU ties it, so the CPU is kept
+		{"cuda ties", []ProbeResult{{Provider: hardware.ProviderCPU, RTF: 0.4}, {Provider: hardware.ProviderCUDA, RTF: 0.38}}, hardware.ProviderCPU},
+	}
+	for _, tt := range tests {
+		if got := pickProvider(tt.results); got != tt.want {
+			t.Errorf("%s: pickProvider() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProviderProbeRanked(t *testing.T) {
+	probe := ProviderProbe{
+		Results: []ProbeResult{
+			{Provider: hardware.ProviderCPU, RTF: 0.4},
+			{Provider: hardware.ProviderCUDA, RTF: 0.05},
+			{Provider: "other", Error: "failed"},
+		},
+	}
+	probe.Best = pickProvider(probe.Results)
+	want := []hardware.Provider{hardware.ProviderCUDA, hardware.ProviderCPU}
+	if got := probe.Ranked(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Ranked() = %v, want %v", got, want)
+	}
+}
+
+func TestProbeCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "probe.json")
+	probe := ProviderProbe{
+		Version:  probeCacheVersion,
+		Key:      "key",
+		ProbedAt: time.Now().Truncate(time.Second),
+		Results:  []ProbeResult{{Provider: hardware.ProviderCPU, RTF: 0.25}},
+		Best:     hardware.ProviderCPU,
+	}
+	if err := saveProbe(path, probe); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadProbe(path, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Best != probe.Best || !reflect.DeepEqual(got.Results, probe.Results) {
+		t.Errorf("loadProbe() = %+v, want %+v", got, probe)
+	}
+	if _, err := loadProbe(path, "other key"); err == nil {
+		t.Error("loadProbe() accepted a probe made for another key")
+	}
+}
+
+func TestMeasureRTF(t *testing.T) {
+	samples := BytesToSamples(probeClip)
+	if len(samples) != 16000 {
+		t.Fatalf("probe clip has %d samples, want one second", len(samples))
+	}
+	counting := &countingEngine{FakeEngine: engine.NewFakeEngine()}
+	rtf, err := measureRTF(counting, samples, 16000)
+	if err != nil || rtf < 0 {
+		t.Errorf("measureRTF() = %v, %v", rtf, err)
+	}
+	if counting.streams != probeRuns+1 {
+		t.Errorf("measureRTF() decoded %d times, want a warm-up and %d timed runs", counting.streams, probeRuns)
+	}
+	counting.Close()
+	if !counting.Released() {
+		t.Error("measureRTF() left a stream open")
+	}
+
+	failing := engine.NewFakeEngine(engine.FakeStep{At: 0, Err: errors.New("boom")})
+	if _, err := measureRTF(failing, samples, 16000); err == nil {
+		t.Error("measureRTF() ignored a decode error")
+	}
+}
+
+// countingEngine counts the streams a FakeEngine creates.
+type countingEngine struct {
+	*engine.FakeEngine
+	streams int
+}
+
+func (e *countingEngine) NewStream() (engine.Stream, error) {
+	e.streams++
+	return e.FakeEngine.NewStream()
+}
+
+func TestProbeClipIsSpeechLike(t *testing.T) {
+	samples := BytesToSamples(probeClip)
+	if level := dsp.RMS(samples); level < 0.03 || level > 0.3 {
+		t.Errorf("probe clip RMS = %.3f, want a conversational level", level)
+	}
+	// Speech comes and goes; a steady tone doesn't.
+	quietest, loudest := math.Inf(1), 0.0
+	for i := 0; i+800 <= len(samples); i += 800 {
+		level := dsp.RMS(samples[i : i+800])
+		quietest, loudest = min(quietest, level), max(loudest, level)
+	}
+	if quietest > loudest/10 {
+		t.Errorf("probe clip level only varies from %.3f to %.3f in 50ms windows", quietest, loudest)
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	e := engine.NewFakeEngine(engine.FakeStep{At: 8000, Text: "warm"})
+	tr, err := NewTranscriberWithEngine(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.WarmUp(); err != nil {
+		t.Fatalf("WarmUp() = %v", err)
+	}
+	tr.Close()
+	if !e.Released() {
+		t.Error("WarmUp left its stream open")
+	}
+
+	tr, err = NewTranscriberWithEngine(engine.NewFakeEngine(engine.FakeStep{At: 0, Err: errors.New("boom")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if _, err := tr.WarmUp(); err == nil {
+		t.Error("WarmUp() ignored a decode error")
+	}
+}
@@ -1,23 +1,207 @@
 package types
 
-import "livelylivecaptions/internal/hardware"
+import (
+	"fmt"
+	"livelylivecaptions/internal/hardware"
+	"time"
+)
 
-// TranscriptionEvent represents a single update from the transcriber
+// EventKind describes how a TranscriptionEvent relates to the segment it belongs to.
+type EventKind int
+
+const (
+	EventPartial EventKind = iota // Hypothesis for a segment that is still being decoded
+	EventFinal                    // The segment is complete and its text will not change again
+	EventRetract                  // The segment produced nothing and should be removed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventPartial:
+		return "partial"
+	case EventFinal:
+		return "final"
+	case EventRetract:
+		return "retract"
+	default:
+		return "unknown"
+	}
+}
+
+// TranscriptionEvent represents a single update from the transcriber.
+// Events that share a SegmentID describe the same utterance; a higher
+// Revision always supersedes a lower one, so consumers can update in place.
 type TranscriptionEvent struct {
-	Text       string
-	IsFinal    bool
-	Confidence float64
+	SegmentID uint64    // Identifies the utterance; IDs start at 1 and only increase
+	Revision  int       // Incremented on every event for the same segment
+	Kind      EventKind // How this event changes the segment
+	Text      string
+	// StableText is the leading part of Text that has stopped changing between
+	// partials, and UnstableText is the rest. Both are whole words. For finals,
+	// StableText holds the full text.
+	StableText   string
+	UnstableText string
+	IsFinal      bool // Kept for older consumers; true only when Kind is EventFinal
+	Confidence   float64
+	// StartTime and EndTime are the wall-clock capture times of the audio the
+	// segment covers so far.
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// AudioFrame is one block of captured audio together with what is needed to
+// interpret it, measure latency and notice lost audio.
+type AudioFrame struct {
+	// Seq increases by one for every frame a device captures, including frames
+	// it later had to drop, so a jump downstream means audio was lost.
+	Seq         uint64
+	CaptureTime time.Time // Wall-clock time the first sample was captured
+	SampleRate  int
+	Channels    int
+	Samples     []float32 // Interleaved samples in the range [-1, 1]
+
+	// Pool is where Samples came from, if they are pooled. Whoever holds the
+	// frame last calls Release so the slice can be reused.
+	Pool *SamplePool
+}
+
+// Frames returns the number of samples per channel in the frame.
+func (f AudioFrame) Frames() int {
+	if f.Channels <= 0 {
+		return 0
+	}
+	return len(f.Samples) / f.Channels
+}
+
+// Duration returns how much audio the frame holds.
+func (f AudioFrame) Duration() time.Duration {
+	if f.SampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(f.Frames()) * time.Second / time.Duration(f.SampleRate)
+}
+
+// Release hands the frame's samples back to their pool. Neither the frame nor
+// its samples may be used afterwards.
+func (f *AudioFrame) Release() {
+	if f.Pool != nil {
+		f.Pool.Put(f.Samples)
+	}
+	f.Samples = nil
+	f.Pool = nil
+}
+
+// SamplePool recycles sample slices of one size so steady-state capture
+// doesn't allocate. It is safe for concurrent use.
+type SamplePool struct {
+	size int
+	free chan []float32
+}
+
+// NewSamplePool creates a pool of slices with length size that keeps up to
+// capacity released slices for reuse.
+func NewSamplePool(size, capacity int) *SamplePool {
+	return &SamplePool{size: size, free: make(chan []float32, capacity)}
+}
+
+// Get returns a slice of the pool's size, reusing a released one if possible.
+// Its contents are undefined.
+func (p *SamplePool) Get() []float32 {
+	select {
+	case s := <-p.free:
+		return s[:p.size]
+	default:
+		return make([]float32, p.size)
+	}
+}
+
+// Put makes s available to Get again. Slices that are too small, or that
+// arrive while the pool is full, are left to the garbage collector.
+func (p *SamplePool) Put(s []float32) {
+	if cap(s) < p.size {
+		return
+	}
+	select {
+	case p.free <- s:
+	default:
+	}
 }
 
 // AudioLevelMsg carries the RMS value for UI updates
 type AudioLevelMsg float64
 
+// AudioStatsMsg counts what happened to captured audio frames, for the UI
+// and logs.
+type AudioStatsMsg struct {
+	Captured uint64 // Frames read from the device
+	Lost     uint64 // Frames the device itself dropped
+	Dropped  uint64 // Frames discarded because the transcriber fell behind
+	Late     uint64 // Frames that reached the transcriber well after capture
+}
+
+func (s AudioStatsMsg) String() string {
+	return fmt.Sprintf("%d captured, %d lost by the device, %d dropped, %d late", s.Captured, s.Lost, s.Dropped, s.Late)
+}
+
+// DeviceState is how the audio device is doing.
+type DeviceState int
+
+const (
+	DeviceConnected    DeviceState = iota // Capturing normally
+	DeviceReconnecting                    // Lost; waiting for it, or a fallback, to come back
+	DeviceStopped                         // Capture ended for good
+)
+
+// DeviceStatusMsg reports a change in the audio device's state, for the UI.
+type DeviceStatusMsg struct {
+	State  DeviceState
+	Device string // Name of the device concerned
+	Reason string // Why the device was lost or stopped, if it was
+}
+
+// MetricsMsg reports how quickly captions follow speech, for the UI and logs.
+type MetricsMsg struct {
+	// Latency percentiles over recent events, measured from the capture of the
+	// newest audio an event reflects to its delivery.
+	PartialP50, PartialP95 time.Duration
+	FinalP50, FinalP95     time.Duration
+	Partials, Finals       int // Events measured over the whole session
+
+	// RTF is the decoder's real-time factor over the session: time spent
+	// decoding divided by the duration of the audio decoded. Below 1 keeps up.
+	RTF   float64
+	Audio time.Duration // Audio decoded over the session
+}
+
+func (m MetricsMsg) String() string {
+	return fmt.Sprintf("partial latency p50 %v p95 %v (%d), final latency p50 %v p95 %v (%d), RTF %.3f over %v of audio",
+		m.PartialP50.Round(time.Millisecond), m.PartialP95.Round(time.Millisecond), m.Partials,
+		m.FinalP50.Round(time.Millisecond), m.FinalP95.Round(time.Millisecond), m.Finals,
+		m.RTF, m.Audio.Round(time.Second))
+}
+
+// DeviceInfo describes an audio device beyond its name.
+type DeviceInfo struct {
+	HostAPI            string        // e.g. "ALSA", "Windows WASAPI", or the kind of input for non-hardware devices
+	Index              int           // Position in the host's device list, or -1 if it has none
+	MaxChannels        int           // Input channels available; 0 if unknown
+	DefaultSampleRate  float64       // Hz; 0 if unknown
+	DefaultLowLatency  time.Duration // Input latency for interactive use
+	DefaultHighLatency time.Duration // Input latency for robust, non-interactive use
+	IsDefault          bool          // The system's default input device
+}
+
 // AudioDevice defines the interface for interacting with audio hardware
 type AudioDevice interface {
 	Name() string
+	// ID identifies the device unambiguously, even among devices of the same
+	// name, and stays the same across runs and reboots.
 	ID() interface{}
+	Info() DeviceInfo
 	Start() error
-	Read() ([]byte, error)
+	// Read returns the next captured frame. A frame without Samples means
+	// nothing was captured yet and the caller should try again.
+	Read() (AudioFrame, error)
 	Close() error
 }
 
@@ -37,14 +221,60 @@ type AppConfig struct {
 		Decoder  string            `mapstructure:"decoder"`
 		Joiner   string            `mapstructure:"joiner"`
 		Tokens   string            `mapstructure:"tokens"`
+		// Used by the whisper_http provider
+		ServerURL   string `mapstructure:"server_url"`   // e.g. http://127.0.0.1:8080
+		ServerModel string `mapstructure:"server_model"` // "model" field sent to the server
+		Language    string `mapstructure:"language"`     // Optional language hint, e.g. "en"
+		// Reprobe times each execution provider again when auto-detecting
+		// instead of using the result cached by an earlier run.
+		Reprobe bool `mapstructure:"reprobe"`
 	} `mapstructure:"model"`
 	Audio struct {
 		SampleRate  int    `mapstructure:"sample_rate"`  // e.g., 16000
 		DeviceID    string `mapstructure:"device_id"`    // Specific audio device ID or name
-		MonitorMode bool   `mapstructure:"monitor_mode"` // Capture output audio (if supported)
+		MonitorMode bool   `mapstructure:"monitor_mode"` // Capture system audio through monitor sources (Linux)
+		// DevicePriority lists device patterns to try, in order, after
+		// DeviceID: IDs, names, substrings, /regexps/ or "default".
+		DevicePriority []string `mapstructure:"device_priority"`
+		// NonInteractive fails startup instead of prompting for a device
+		// when none is configured or present.
+		NonInteractive bool `mapstructure:"non_interactive"`
+		// Reconnect reopens a capture device that is unplugged or fails,
+		// falling back to FallbackDevices (device patterns) while it is missing.
+		Reconnect       bool     `mapstructure:"reconnect"`
+		FallbackDevices []string `mapstructure:"fallback_devices"`
+		// OverflowPolicy is what to do with audio the transcriber can't keep
+		// up with: block, drop-oldest or drop-newest.
+		OverflowPolicy string `mapstructure:"overflow_policy"`
+		// FilePacing is how fast a "file:" device plays: realtime or fast.
+		FilePacing string `mapstructure:"file_pacing"`
+		// Format of raw PCM read from stdin ("-"), a "pipe:" device or a network listener
+		PCMFormat   string `mapstructure:"pcm_format"`   // s16le or f32le
+		PCMRate     int    `mapstructure:"pcm_rate"`     // Hz
+		PCMChannels int    `mapstructure:"pcm_channels"` // Interleaved channels
+		// An "rtp://" device waits this long for a missing packet before
+		// concealing it, and takes dynamic payload type RTPL16PayloadType to
+		// be L16 at PCMRate with PCMChannels.
+		RTPJitterMs       int `mapstructure:"rtp_jitter_ms"`
+		RTPL16PayloadType int `mapstructure:"rtp_l16_payload_type"`
+		// Filters condition audio before it is transcribed, applied in order:
+		// dc, highpass, gate, agc and gain, configured by the fields below.
+		Filters         []string `mapstructure:"filters"`
+		HighPassHz      float64  `mapstructure:"highpass_hz"`       // High-pass cutoff
+		GateThresholdDB float64  `mapstructure:"gate_threshold_db"` // Noise gate threshold, dBFS
+		AGCTargetDB     float64  `mapstructure:"agc_target_db"`     // Level the AGC aims for, dBFS
+		AGCMaxGainDB    float64  `mapstructure:"agc_max_gain_db"`   // Most the AGC amplifies
+		GainDB          float64  `mapstructure:"gain_db"`           // Fixed gain
 	} `mapstructure:"audio"`
+	Transcriber struct {
+		StableUpdates     int     `mapstructure:"stable_updates"`      // Partials a word must survive to be shown as stable
+		StableMs          int     `mapstructure:"stable_ms"`           // Or milliseconds it must stay unchanged
+		MaxSegmentSeconds float64 `mapstructure:"max_segment_seconds"` // Split a segment with no endpoint after this much audio
+		MaxSegmentChars   int     `mapstructure:"max_segment_chars"`   // Or once its text is this long
+		WarmUp            bool    `mapstructure:"warm_up"`             // Decode a throwaway clip before captioning starts
+	} `mapstructure:"transcriber"`
 	Log struct {
-		ToMemory bool `mapstructure:"to_memory"` // Log to in-memory ring buffer for UI display
+		ToMemory bool   `mapstructure:"to_memory"` // Log to in-memory ring buffer for UI display
 		FilePath string `mapstructure:"file_path"` // Path to log file
 		Level    string `mapstructure:"level"`     // info, debug, warn, error
 	} `mapstructure:"log"`
@@ -56,7 +286,7 @@ type AppConfig struct {
 
 // LogConfig mirrors the structure of AppConfig.Log for passing log-specific settings.
 type LogConfig struct {
-	ToMemory bool `mapstructure:"to_memory"` // Log to in-memory ring buffer for UI display
+	ToMemory bool   `mapstructure:"to_memory"` // Log to in-memory ring buffer for UI display
 	FilePath string `mapstructure:"file_path"` // Path to log file
 	Level    string `mapstructure:"level"`     // info, debug, warn, error
 }
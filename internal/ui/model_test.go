@@ -0,0 +1,109 @@
+package ui
+
+import (
+	"errors"
+	"livelylivecaptions/internal/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEvent(t *testing.T) {
+	var captions []caption
+	apply := func(ev types.TranscriptionEvent) {
+		captions = applyEvent(captions, ev)
+	}
+
+	apply(types.TranscriptionEvent{SegmentID: 1, Revision: 1, Kind: types.EventPartial, Text: "hel"})
+	apply(types.TranscriptionEvent{SegmentID: 1, Revision: 2, Kind: types.EventPartial, Text: "hello"})
+	apply(types.TranscriptionEvent{SegmentID: 1, Revision: 3, Kind: types.EventFinal, Text: "hello there", IsFinal: true})
+	// A stale partial arriving after the final must be ignored.
+	apply(types.TranscriptionEvent{SegmentID: 1, Revision: 2, Kind: types.EventPartial, Text: "hello"})
+	apply(types.TranscriptionEvent{SegmentID: 2, Revision: 1, Kind: types.EventPartial, Text: "um"})
+
+	if len(captions) != 2 {
+		t.Fatalf("got %d captions, want 2: %+v", len(captions), captions)
+	}
+	if c := captions[0]; c.text != "hello there" || !c.final {
+		t.Errorf("segment 1 = %+v, want final 'hello there'", c)
+	}
+
+	apply(types.TranscriptionEvent{SegmentID: 2, Revision: 2, Kind: types.EventRetract})
+	if len(captions) != 1 || captions[0].text != "hello there" {
+		t.Errorf("after retract = %+v", captions)
+	}
+
+	// Retracting an unknown segment does nothing.
+	apply(types.TranscriptionEvent{SegmentID: 9, Revision: 1, Kind: types.EventRetract})
+	if len(captions) != 1 {
+		t.Errorf("retract of unknown segment changed captions: %+v", captions)
+	}
+}
+
+func TestApplyEventWithoutSegmentIDs(t *testing.T) {
+	var captions []caption
+	captions = applyEvent(captions, types.TranscriptionEvent{Text: "one"})
+	captions = applyEvent(captions, types.TranscriptionEvent{Text: "one two", IsFinal: true})
+	captions = applyEvent(captions, types.TranscriptionEvent{Text: "three"})
+
+	if len(captions) != 2 || captions[0].text != "one two" || !captions[0].final || captions[1].text != "three" || captions[1].final {
+		t.Errorf("legacy events = %+v", captions)
+	}
+}
+
+func TestStatsLine(t *testing.T) {
+	var m model
+	if got := m.statsLine(); got != "" {
+		t.Errorf("statsLine() before any stats = %q, want empty", got)
+	}
+	m.stats = types.AudioStatsMsg{Captured: 120, Dropped: 3, Late: 1}
+	if got := m.statsLine(); !strings.Contains(got, "120 frames, 0 lost, 3 dropped, 1 late") {
+		t.Errorf("statsLine() = %q", got)
+	}
+}
+
+func TestMetricsLine(t *testing.T) {
+	var m model
+	if got := m.metricsLine(); got != "" {
+		t.Errorf("metricsLine() before any audio = %q, want empty", got)
+	}
+	m.metrics = types.MetricsMsg{
+		PartialP50: 120 * time.Millisecond,
+		PartialP95: 340 * time.Millisecond,
+		FinalP50:   400 * time.Millisecond,
+		FinalP95:   900 * time.Millisecond,
+		RTF:        0.18,
+		Audio:      time.Minute,
+	}
+	want := "partial p50 120ms p95 340ms, final p50 400ms p95 900ms, RTF 0.18"
+	if got := m.metricsLine(); !strings.Contains(got, want) {
+		t.Errorf("metricsLine() = %q, want it to contain %q", got, want)
+	}
+}
+
+func TestStoppedLine(t *testing.T) {
+	var m model
+	if got := m.stoppedLine(); got != "Captioning stopped. Press q to quit." {
+		t.Errorf("stoppedLine() = %q", got)
+	}
+	next, _ := m.Update(stoppedMsg{err: errors.New("decoding failed: CUDA out of memory")})
+	m = next.(model)
+	if got := m.stoppedLine(); !m.stopped || !strings.Contains(got, "stopped: decoding failed: CUDA out of memory.") {
+		t.Errorf("stoppedLine() after a failure = %q", got)
+	}
+}
+
+func TestDeviceLine(t *testing.T) {
+	var m model
+	if got := m.deviceLine(); got != "" {
+		t.Errorf("deviceLine() while connected = %q, want empty", got)
+	}
+	m.device = types.DeviceStatusMsg{State: types.DeviceReconnecting, Device: "USB Mic", Reason: "no audio for 3s"}
+	if got := m.deviceLine(); !strings.Contains(got, "USB Mic lost (no audio for 3s). Reconnecting") {
+		t.Errorf("deviceLine() = %q", got)
+	}
+	m.device = types.DeviceStatusMsg{State: types.DeviceStopped, Device: "talk.wav"}
+	if got := m.deviceLine(); !strings.Contains(got, "talk.wav stopped") {
+		t.Errorf("deviceLine() = %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -34,11 +35,12 @@ var (
 			Width(width - 14).
 			Height(height)
 
-	finalTextStyle   = transcriptionTextStyle    // Fire color
-	partialTextStyle = transcriptionTextStyle   // Fire color
-	warningTextStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("202"))   // Orange
+	finalTextStyle    = transcriptionTextStyle                                    // Fire color
+	partialTextStyle  = transcriptionTextStyle                                    // Fire color
+	unstableTextStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#B36B3D")) // Dimmed fire for words that may still change
+	warningTextStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("202"))     // Orange
 
-	levelTextStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("214")) // Amber for "Level"
+	levelTextStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))     // Amber for "Level"
 	transcriptionTextStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF6600")) // Fire color for transcription
 )
 
@@ -50,29 +52,53 @@ func tickCmd() tea.Cmd {
 	})
 }
 
+// caption is one utterance as currently shown in the viewport.
+type caption struct {
+	id       uint64 // SegmentID from the transcriber; 0 for events that carry none
+	revision int    // Highest revision applied so far
+	text     string
+	stable   string // Settled prefix of a partial, if the producer reports one
+	unstable string // Remainder of a partial that may still be rewritten
+	final    bool
+}
+
 type model struct {
-	transcription  []string // History of final transcriptions
-	partial        string   // Current partial transcription
+	captions       []caption // Utterances in the order they were first seen
 	audioLevel     float64
 	viewport       viewport.Model
 	lastSoundTime  time.Time
 	silenceWarning bool
+	stopped        bool  // The transcription channel was closed
+	stopErr        error // Why captioning stopped, if it failed
+	stats          types.AudioStatsMsg
+	metrics        types.MetricsMsg
+	device         types.DeviceStatusMsg
 
-	// Channels for receiving updates
-	transChan <-chan types.TranscriptionEvent
-	levelChan <-chan types.AudioLevelMsg
-	quitChan  chan<- struct{}
+	ch Channels
 }
 
-func InitialModel(transChan <-chan types.TranscriptionEvent, levelChan <-chan types.AudioLevelMsg, quitChan chan<- struct{}) model {
+// Channels connects the UI to the rest of the program: the feeds it shows,
+// and Quit, which it closes when the user quits. Transcriptions and Quit are
+// required; a nil feed is simply never shown.
+type Channels struct {
+	Transcriptions <-chan types.TranscriptionEvent
+	Levels         <-chan types.AudioLevelMsg
+	Stats          <-chan types.AudioStatsMsg
+	Metrics        <-chan types.MetricsMsg
+	Devices        <-chan types.DeviceStatusMsg
+	// Stopped delivers why captioning stopped: nil when the input ended,
+	// otherwise the error that ended it.
+	Stopped <-chan error
+	Quit    chan<- struct{}
+}
+
+func InitialModel(ch Channels) model {
 	vp := viewport.New(width-16, height-2)
 	vp.SetContent("Waiting for speech...")
 
 	return model{
-		transcription:  make([]string, 0),
-		transChan:      transChan,
-		levelChan:      levelChan,
-		quitChan:       quitChan,
+		captions:       make([]caption, 0),
+		ch:             ch,
 		viewport:       vp,
 		lastSoundTime:  time.Now(),
 		silenceWarning: false,
@@ -81,8 +107,12 @@ func InitialModel(transChan <-chan types.TranscriptionEvent, levelChan <-chan ty
 
 func (m model) Init() tea.Cmd {
 	return tea.Batch(
-		waitForTranscription(m.transChan),
-		waitForAudioLevel(m.levelChan),
+		waitForTranscription(m.ch.Transcriptions),
+		waitForAudioLevel(m.ch.Levels),
+		waitForAudioStats(m.ch.Stats),
+		waitForMetrics(m.ch.Metrics),
+		waitForDeviceStatus(m.ch.Devices),
+		waitForStopped(m.ch.Stopped),
 		tickCmd(),
 	)
 }
@@ -94,19 +124,22 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "q", "ctrl+c":
-			close(m.quitChan)
+			close(m.ch.Quit)
 			return m, tea.Quit
 		}
 
 	case types.TranscriptionEvent:
-		if msg.IsFinal {
-			m.transcription = append(m.transcription, msg.Text)
-			m.partial = ""
-		} else {
-			m.partial = msg.Text
-		}
+		m.captions = applyEvent(m.captions, msg)
 		// We always update the viewport on a transcription event
-		cmds = append(cmds, waitForTranscription(m.transChan))
+		cmds = append(cmds, waitForTranscription(m.ch.Transcriptions))
+
+	case transcriptionClosedMsg:
+		// The transcriber has stopped; stop listening but keep the captions on screen.
+		m.stopped = true
+
+	case stoppedMsg:
+		m.stopped = true
+		m.stopErr = msg.err
 
 	case types.AudioLevelMsg:
 		m.audioLevel = float64(msg)
@@ -119,10 +152,25 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.lastSoundTime = time.Now()
 			}
 		}
-		cmds = append(cmds, waitForAudioLevel(m.levelChan))
+		cmds = append(cmds, waitForAudioLevel(m.ch.Levels))
+
+	case types.AudioStatsMsg:
+		m.stats = msg
+		cmds = append(cmds, waitForAudioStats(m.ch.Stats))
+
+	case types.MetricsMsg:
+		m.metrics = msg
+		cmds = append(cmds, waitForMetrics(m.ch.Metrics))
+
+	case types.DeviceStatusMsg:
+		m.device = msg
+		// Silence is expected while the device is away; the status says why.
+		m.lastSoundTime = time.Now()
+		m.silenceWarning = false
+		cmds = append(cmds, waitForDeviceStatus(m.ch.Devices))
 
 	case tickMsg:
-		if time.Since(m.lastSoundTime) > silenceDuration {
+		if time.Since(m.lastSoundTime) > silenceDuration && m.device.State == types.DeviceConnected {
 			m.silenceWarning = true
 		}
 		// Always re-tick
@@ -132,14 +180,30 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// === Viewport Update Logic ===
 	// This logic now runs on every message to keep the view consistent.
 	var sb strings.Builder
-	if m.silenceWarning {
+	if line := m.deviceLine(); line != "" {
+		sb.WriteString(warningTextStyle.Render(line + "\n\n"))
+	} else if m.silenceWarning {
 		sb.WriteString(warningTextStyle.Render("Warning: No audio detected. Check microphone.\n\n"))
 	}
-	for _, line := range m.transcription {
-		sb.WriteString(finalTextStyle.Render(line) + "\n")
+	for _, c := range m.captions {
+		switch {
+		case c.final:
+			sb.WriteString(finalTextStyle.Render(c.text) + "\n")
+		case c.stable == "" && c.unstable == "":
+			sb.WriteString(partialTextStyle.Render(c.text))
+		default:
+			// Style the settled words and the tail differently so rewrites don't draw the eye.
+			if c.stable != "" {
+				sb.WriteString(partialTextStyle.Render(c.stable))
+				if c.unstable != "" {
+					sb.WriteString(" ")
+				}
+			}
+			sb.WriteString(unstableTextStyle.Render(c.unstable))
+		}
 	}
-	if m.partial != "" {
-		sb.WriteString(partialTextStyle.Render(m.partial))
+	if m.stopped {
+		sb.WriteString(warningTextStyle.Render("\n" + m.stoppedLine()))
 	}
 	m.viewport.SetContent(sb.String())
 	m.viewport.GotoBottom()
@@ -148,6 +212,53 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// applyEvent updates the caption the event belongs to, in place.
+// Events without a SegmentID come from producers that only set IsFinal; they
+// update the trailing partial, which matches how captions were tracked before
+// segments existed.
+func applyEvent(captions []caption, ev types.TranscriptionEvent) []caption {
+	idx := -1
+	if ev.SegmentID == 0 {
+		if n := len(captions); n > 0 && !captions[n-1].final {
+			idx = n - 1
+		}
+	} else {
+		for i := len(captions) - 1; i >= 0; i-- {
+			if captions[i].id == ev.SegmentID {
+				idx = i
+				break
+			}
+		}
+	}
+
+	// A late event must not undo a newer one for the same segment.
+	if idx >= 0 && ev.SegmentID != 0 && ev.Revision <= captions[idx].revision {
+		return captions
+	}
+
+	switch ev.Kind {
+	case types.EventRetract:
+		if idx >= 0 {
+			captions = append(captions[:idx], captions[idx+1:]...)
+		}
+		return captions
+	}
+
+	if idx < 0 {
+		captions = append(captions, caption{id: ev.SegmentID})
+		idx = len(captions) - 1
+	}
+	c := &captions[idx]
+	c.revision = ev.Revision
+	c.text = ev.Text
+	c.stable = ev.StableText
+	c.unstable = ev.UnstableText
+	if ev.IsFinal || ev.Kind == types.EventFinal {
+		c.final = true
+	}
+	return captions
+}
+
 func (m model) View() string {
 	// Render Audio Meter - scale RMS to visible range
 	// RMS is typically 0.0-0.3 for normal speech, so we amplify it
@@ -174,28 +285,164 @@ func (m model) View() string {
 	meterContent := strings.Join(meterLines, "\n")
 
 	// Render Layout
-	return lipgloss.JoinHorizontal(lipgloss.Top,
+	view := lipgloss.JoinHorizontal(lipgloss.Top,
 		meterStyle.Render(meterContent),
 		logStyle.Render(m.viewport.View()),
 	)
+	if line := m.statsLine(); line != "" {
+		view += "\n" + line
+	}
+	if line := m.metricsLine(); line != "" {
+		view += "\n" + line
+	}
+	return view
+}
+
+// statsLine summarizes the capture statistics, highlighting lost audio.
+// It is empty until the first statistics arrive.
+func (m model) statsLine() string {
+	if m.stats.Captured == 0 {
+		return ""
+	}
+	style := levelTextStyle
+	if m.stats.Lost > 0 || m.stats.Dropped > 0 {
+		style = warningTextStyle
+	}
+	return style.Render(fmt.Sprintf("Audio: %d frames, %d lost, %d dropped, %d late",
+		m.stats.Captured, m.stats.Lost, m.stats.Dropped, m.stats.Late))
+}
+
+// deviceLine explains why no audio is coming in when the device was lost or
+// capture stopped. It is empty while the device is connected.
+func (m model) deviceLine() string {
+	switch m.device.State {
+	case types.DeviceReconnecting:
+		return fmt.Sprintf("Audio device %s lost (%s). Reconnecting...", m.device.Device, m.device.Reason)
+	case types.DeviceStopped:
+		if m.device.Reason == "" {
+			return fmt.Sprintf("Audio capture from %s stopped.", m.device.Device)
+		}
+		return fmt.Sprintf("Audio capture from %s stopped: %s.", m.device.Device, m.device.Reason)
+	}
+	return ""
 }
 
+// stoppedLine tells the user that captioning has stopped, and why if it failed.
+func (m model) stoppedLine() string {
+	if m.stopErr != nil {
+		return fmt.Sprintf("Captioning stopped: %v. Press q to quit.", m.stopErr)
+	}
+	return "Captioning stopped. Press q to quit."
+}
+
+// metricsLine shows rolling caption latency and the decoder's real-time
+// factor. It is empty until audio has been decoded.
+func (m model) metricsLine() string {
+	if m.metrics.Audio == 0 {
+		return ""
+	}
+	style := levelTextStyle
+	if m.metrics.RTF >= 1 {
+		// The decoder can't keep up, so latency will only grow.
+		style = warningTextStyle
+	}
+	ms := func(d time.Duration) int64 { return d.Milliseconds() }
+	return style.Render(fmt.Sprintf("Latency: partial p50 %dms p95 %dms, final p50 %dms p95 %dms, RTF %.2f",
+		ms(m.metrics.PartialP50), ms(m.metrics.PartialP95),
+		ms(m.metrics.FinalP50), ms(m.metrics.FinalP95), m.metrics.RTF))
+}
+
+// transcriptionClosedMsg reports that the transcription channel was closed.
+type transcriptionClosedMsg struct{}
+
+// stoppedMsg carries why captioning stopped.
+type stoppedMsg struct{ err error }
+
 // Commands
 func waitForTranscription(sub <-chan types.TranscriptionEvent) tea.Cmd {
 	return func() tea.Msg {
-		return <-sub
+		event, ok := <-sub
+		if !ok {
+			return transcriptionClosedMsg{}
+		}
+		return event
 	}
 }
 
+// waitForAudioLevel stops listening once sub is closed, which happens when
+// capture stops; a nil sub never delivers.
 func waitForAudioLevel(sub <-chan types.AudioLevelMsg) tea.Cmd {
+	if sub == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		level, ok := <-sub
+		if !ok {
+			return nil
+		}
+		return level
+	}
+}
+
+// waitForAudioStats stops listening once sub is closed; a nil sub never delivers.
+func waitForAudioStats(sub <-chan types.AudioStatsMsg) tea.Cmd {
+	if sub == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		stats, ok := <-sub
+		if !ok {
+			return nil
+		}
+		return stats
+	}
+}
+
+// waitForMetrics stops listening once sub is closed; a nil sub never delivers.
+func waitForMetrics(sub <-chan types.MetricsMsg) tea.Cmd {
+	if sub == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		metrics, ok := <-sub
+		if !ok {
+			return nil
+		}
+		return metrics
+	}
+}
+
+// waitForDeviceStatus stops listening once sub is closed; a nil sub never delivers.
+func waitForDeviceStatus(sub <-chan types.DeviceStatusMsg) tea.Cmd {
+	if sub == nil {
+		return nil
+	}
 	return func() tea.Msg {
-		return <-sub
+		status, ok := <-sub
+		if !ok {
+			return nil
+		}
+		return status
+	}
+}
+
+// waitForStopped delivers the reason captioning stopped once; a nil sub never delivers.
+func waitForStopped(sub <-chan error) tea.Cmd {
+	if sub == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		err, ok := <-sub
+		if !ok {
+			return nil
+		}
+		return stoppedMsg{err: err}
 	}
 }
 
-// RunProgram starts the Bubble Tea program
-func RunProgram(transChan <-chan types.TranscriptionEvent, levelChan <-chan types.AudioLevelMsg, quitChan chan<- struct{}) error {
-	p := tea.NewProgram(InitialModel(transChan, levelChan, quitChan))
+// RunProgram starts the Bubble Tea program with opts
+func RunProgram(ch Channels, opts ...tea.ProgramOption) error {
+	p := tea.NewProgram(InitialModel(ch), opts...)
 	if _, err := p.Run(); err != nil {
 		return err
 	}
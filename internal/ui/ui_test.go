@@ -11,13 +11,12 @@ import (
 )
 
 func TestUI(t *testing.T) {
-	// Create mock channels for the UI model
-	transChan := make(chan types.TranscriptionEvent)
-	levelChan := make(chan types.AudioLevelMsg)
-	quitChan := make(chan struct{})
-
-	// Initialize the UI model
-	m := ui.InitialModel(transChan, levelChan, quitChan)
+	// Initialize the UI model with mock channels
+	m := ui.InitialModel(ui.Channels{
+		Transcriptions: make(chan types.TranscriptionEvent),
+		Levels:         make(chan types.AudioLevelMsg),
+		Quit:           make(chan struct{}),
+	})
 
 	// Create a test program
 	tm := teatest.NewTestModel(t, m, teatest.WithInitialTermSize(120, 25))
@@ -12,7 +12,7 @@ echo "\nBuilding Nemotron-primary version with GPU support linking against: $GPU
 export CGO_LDFLAGS="-L$GPU_LIB_DIR -lsherpa-onnx-c-api -Wl,-rpath,$GPU_LIB_DIR"
 
 # Build the application with Nemotron as primary model
-go build -tags cuda -o LivelyLiveCaptions_Nemotron cmd/livelylivecaptions/main.go
+go build -tags cuda -o LivelyLiveCaptions_Nemotron ./cmd/livelylivecaptions
 
 if [ $? -eq 0 ]; then
     echo "\n✓ Nemotron-primary build successful: ./LivelyLiveCaptions_Nemotron\n"
@@ -12,7 +12,7 @@ echo "\nBuilding Sherpa-only version with GPU support linking against: $GPU_LIB_
 export CGO_LDFLAGS="-L$GPU_LIB_DIR -lsherpa-onnx-c-api -Wl,-rpath,$GPU_LIB_DIR"
 
 # Build the application with Sherpa-only model selection
-go build -tags cuda -o LivelyLiveCaptions_Sherpa cmd/livelylivecaptions/main.go
+go build -tags cuda -o LivelyLiveCaptions_Sherpa ./cmd/livelylivecaptions
 
 if [ $? -eq 0 ]; then
     echo "\n✓ Sherpa-only build successful: ./LivelyLiveCaptions_Sherpa\n"